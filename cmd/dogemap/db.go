@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/store"
+)
+
+const dbUsage = `usage: dogemap db [--dir <path>] [--db <file|dsn>] <command>
+
+commands:
+  migrate              apply all pending schema migrations
+  status               list schema migrations and their state
+  rollback [--steps N] revert the last N applied migrations (default 1)
+  backup <file>        write a consistent snapshot of the SQLite database
+                       (safe while DogeMap is running)
+  restore <file>       replace the SQLite database with a backup
+                       (stop DogeMap first; the current database is kept
+                       as <db>.pre-restore)
+`
+
+// dogemap db <command>
+func dbCmd(args []string) int {
+	flags := flag.NewFlagSet("db", flag.ExitOnError)
+	dir := DefaultStorage
+	dbfile := DBFile
+	flags.StringVar(&dir, "dir", DefaultStorage, "<path> - storage directory")
+	flags.StringVar(&dbfile, "db", DBFile, "path to SQLite database (relative: in storage dir) or postgres://... DSN")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), dbUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 1
+	}
+	cmd, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	dsn := dbfile
+	if !store.IsPostgresDSN(dsn) {
+		if dsn == store.MemoryDSN {
+			stderr.Printf("db: the in-memory database has no schema")
+			return 1
+		}
+		if !path.IsAbs(dsn) {
+			dsn = path.Join(dir, dsn)
+		}
+	}
+
+	switch cmd {
+	case "migrate", "status", "rollback":
+		return migrateCmd(dsn, cmd, cmdArgs)
+	case "backup", "restore":
+		return backupCmd(dsn, cmd, cmdArgs)
+	default:
+		stderr.Printf("db: unknown command: %v", cmd)
+		flags.Usage()
+		return 1
+	}
+}
+
+func migrateCmd(dsn string, cmd string, args []string) int {
+	flags := flag.NewFlagSet("db "+cmd, flag.ExitOnError)
+	steps := flags.Int("steps", 1, "number of migrations to roll back")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		stderr.Printf("Unexpected argument: %v", flags.Arg(0))
+		return 1
+	}
+	if !store.IsPostgresDSN(dsn) && cmd != "migrate" {
+		if _, err := os.Stat(dsn); err != nil {
+			stderr.Printf("db: %v", err)
+			return 1
+		}
+	}
+
+	m, err := store.OpenMigrator(dsn)
+	if err != nil {
+		stderr.Printf("Error opening database: %v", err)
+		return 1
+	}
+	defer m.Close()
+
+	switch cmd {
+	case "migrate":
+		n, err := m.Migrate()
+		if err != nil {
+			stderr.Printf("migrate: %v", err)
+			return 1
+		}
+		fmt.Printf("applied %d migrations\n", n)
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			stderr.Printf("status: %v", err)
+			return 1
+		}
+		for _, st := range status {
+			applied := ""
+			if st.Applied != 0 {
+				applied = time.Unix(st.Applied, 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-24s %-9s %s\n", st.Version, st.Name, st.State, applied)
+		}
+	case "rollback":
+		n, err := m.Rollback(*steps)
+		if err != nil {
+			stderr.Printf("rollback: %v", err)
+			return 1
+		}
+		fmt.Printf("rolled back %d migrations\n", n)
+	}
+	return 0
+}
+
+func backupCmd(dsn string, cmd string, args []string) int {
+	flags := flag.NewFlagSet("db "+cmd, flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		stderr.Printf("usage: dogemap db %v <file>", cmd)
+		return 1
+	}
+	file := flags.Arg(0)
+	if store.IsPostgresDSN(dsn) {
+		stderr.Printf("db %v: not supported for PostgreSQL (use pg_dump and pg_restore)", cmd)
+		return 1
+	}
+
+	switch cmd {
+	case "backup":
+		err := store.BackupSQLite(dsn, file)
+		if err != nil {
+			stderr.Printf("backup: %v", err)
+			return 1
+		}
+		fmt.Printf("backed up %v to %v\n", dsn, file)
+	case "restore":
+		err := store.RestoreSQLite(file, dsn)
+		if err != nil {
+			stderr.Printf("restore: %v", err)
+			return 1
+		}
+		fmt.Printf("restored %v from %v\n", dsn, file)
+	}
+	return 0
+}
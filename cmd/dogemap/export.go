@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/export"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// Nodes stored per transaction by `dogemap import`
+const ImportBatch = 5000
+
+// dogemap export [--format ndjson|csv|json] [--type core|net] [--since <time>] [--out <file>]
+//
+// Writes the nodes in the store, e.g. for loading into a notebook or
+// moving data to another backend with `dogemap import`.
+func exportCmd(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dir, dbfile, format, typ := storeFlags(flags)
+	since := ""
+	out := "-"
+	flags.StringVar(&since, "since", "", "only nodes with a time after: <unix>, RFC3339, YYYY-MM-DD or a duration (e.g. 24h)")
+	flags.StringVar(&out, "out", "-", "<file> - output file ('-' for stdout)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: dogemap export [--format ndjson|csv|json] [--type core|net] [--since <time>] [--out <file>]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 1
+	}
+	if err := export.CheckFormat(*format, *typ); err != nil {
+		stderr.Printf("export: %v", err)
+		return 1
+	}
+	var after int64
+	if since != "" {
+		var err error
+		after, err = parseSince(since, time.Now())
+		if err != nil {
+			stderr.Printf("export: --since: %v", err)
+			return 1
+		}
+	}
+
+	db, err := openStore(*dir, *dbfile, true)
+	if err != nil {
+		stderr.Printf("Error opening database: %v", err)
+		return 1
+	}
+	var nodes spec.NodeListRes
+	if *typ != export.TypeNet {
+		core, err := db.NodeList()
+		if err != nil {
+			stderr.Printf("export: %v", err)
+			return 1
+		}
+		for _, n := range core {
+			if n.Time >= after {
+				nodes.Core = append(nodes.Core, n)
+			}
+		}
+	}
+	if *typ != export.TypeCore {
+		net, err := db.NetNodeList()
+		if err != nil {
+			stderr.Printf("export: %v", err)
+			return 1
+		}
+		for _, n := range net {
+			if n.Time >= after {
+				nodes.Net = append(nodes.Net, n)
+			}
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		file, err := os.Create(out)
+		if err != nil {
+			stderr.Printf("export: %v", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	buf := bufio.NewWriter(w)
+	err = export.Write(buf, *format, *typ, nodes)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		stderr.Printf("export: %v", err)
+		return 1
+	}
+	stderr.Printf("exported %d core nodes, %d dogenet nodes", len(nodes.Core), len(nodes.Net))
+	return 0
+}
+
+// dogemap import [--format ndjson|csv|json] [--type core|net] <file>
+//
+// Stores nodes written by `dogemap export`, replacing existing nodes.
+func importCmd(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dir, dbfile, format, typ := storeFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: dogemap import [--format ndjson|csv|json] [--type core|net] <file> ('-' for stdin)\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	if err := export.CheckFormat(*format, *typ); err != nil {
+		stderr.Printf("import: %v", err)
+		return 1
+	}
+
+	var r io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			stderr.Printf("import: %v", err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+	nodes, err := export.Read(bufio.NewReader(r), *format, *typ)
+	if err != nil {
+		stderr.Printf("import: %v", err)
+		return 1
+	}
+
+	db, err := openStore(*dir, *dbfile, false)
+	if err != nil {
+		stderr.Printf("Error opening database: %v", err)
+		return 1
+	}
+	// core nodes first, so dogenet nodes are linked to them.
+	for core := nodes.Core; len(core) > 0; core = core[batchLen(len(core)):] {
+		if err := db.ImportCoreNodes(core[:batchLen(len(core))]); err != nil {
+			stderr.Printf("import: %v", err)
+			return 1
+		}
+	}
+	for net := nodes.Net; len(net) > 0; net = net[batchLen(len(net)):] {
+		if err := db.ImportNetNodes(net[:batchLen(len(net))]); err != nil {
+			stderr.Printf("import: %v", err)
+			return 1
+		}
+	}
+	fmt.Printf("imported %d core nodes, %d dogenet nodes\n", len(nodes.Core), len(nodes.Net))
+	return 0
+}
+
+// storeFlags adds the flags shared by export and import.
+func storeFlags(flags *flag.FlagSet) (dir *string, dbfile *string, format *string, typ *string) {
+	dir = flags.String("dir", DefaultStorage, "<path> - storage directory")
+	dbfile = flags.String("db", DBFile, "path to SQLite database (relative: in storage dir) or postgres://... DSN")
+	format = flags.String("format", export.FormatNDJSON, "ndjson, csv or json")
+	typ = flags.String("type", export.TypeAll, "core or net (default: both; csv requires a type)")
+	return
+}
+
+// parseSince parses a unix time, RFC3339 time, date, or a duration before `now`.
+func parseSince(s string, now time.Time) (int64, error) {
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return unix, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d).Unix(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Unix(), nil
+	}
+	return 0, fmt.Errorf("expecting <unix>, RFC3339, YYYY-MM-DD or a duration: %v", s)
+}
+
+// batchLen is the size of the next import batch of `remaining` nodes.
+func batchLen(remaining int) int {
+	if remaining > ImportBatch {
+		return ImportBatch
+	}
+	return remaining
+}
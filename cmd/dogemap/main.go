@@ -16,8 +16,10 @@ import (
 
 	"code.dogecoin.org/governor"
 
+	"code.dogecoin.org/dogemap-backend/internal/capture"
 	"code.dogecoin.org/dogemap-backend/internal/collector"
 	"code.dogecoin.org/dogemap-backend/internal/geoip"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
 	"code.dogecoin.org/dogemap-backend/internal/store"
 	"code.dogecoin.org/dogemap-backend/internal/web"
 )
@@ -30,11 +32,38 @@ const DBFile = "dogemap.db"
 const GeoIPFile = "dbip-city-ipv4-num.csv"
 const DefaultStorage = "./storage"
 const DefaultWebDir = "./web"
+const DefaultBackupEvery = 24 * time.Hour
+const DefaultBackupKeep = 7
+
+// --mode: run crawlers and the web API in one process (all), or in separate
+// processes sharing one database: crawl (writer) and web (read-only).
+const (
+	ModeAll   = "all"
+	ModeCrawl = "crawl"
+	ModeWeb   = "web"
+)
 
 var stderr = log.New(os.Stderr, "", 0)
 
 func main() {
+	// sub-commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(replayCmd(os.Args[2:]))
+		case "simulate":
+			os.Exit(simulateCmd(os.Args[2:]))
+		case "db":
+			os.Exit(dbCmd(os.Args[2:]))
+		case "export":
+			os.Exit(exportCmd(os.Args[2:]))
+		case "import":
+			os.Exit(importCmd(os.Args[2:]))
+		}
+	}
+
 	var crawl int
+	mode := ModeAll
 	binds := []dnet.Address{}
 	core := dnet.Address{}
 	dbfile := DBFile
@@ -42,6 +71,13 @@ func main() {
 	dogenetAddr := ""
 	identityAddr := ""
 	dir := DefaultStorage
+	captureDir := ""
+	retention := spec.DefaultRetention
+	backupDir := ""
+	backupEvery := DefaultBackupEvery
+	backupKeep := DefaultBackupKeep
+	identityRefresh := collector.DefaultIdentityRefresh
+	identityTTL := collector.DefaultIdentityTTL
 	flag.Func("dir", "<path> - storage directory (default './storage')", func(arg string) error {
 		ent, err := os.Stat(arg)
 		if err != nil {
@@ -64,8 +100,16 @@ func main() {
 		webdir = arg
 		return nil
 	})
+	flag.StringVar(&mode, "mode", ModeAll, "all, crawl (collectors only) or web (read-only web API)")
 	flag.IntVar(&crawl, "crawl", 0, "number of core node crawlers")
-	flag.StringVar(&dbfile, "db", DBFile, "path to SQLite database (relative: in storage dir)")
+	flag.StringVar(&captureDir, "capture", "", "<path> - record raw P2P sessions in this directory (relative: in storage dir)")
+	flag.StringVar(&dbfile, "db", DBFile, "path to SQLite database (relative: in storage dir), postgres://... DSN, or :memory:")
+	flag.Var(&retention, "retention", "days to keep nodes: gossiped=N,reachable=N,identity=N[,archive] (archive keeps expired core nodes only)")
+	flag.StringVar(&backupDir, "backup", "", "<path> - write scheduled SQLite backups in this directory (relative: in storage dir; not in --mode web)")
+	flag.DurationVar(&backupEvery, "backup-every", DefaultBackupEvery, "time between scheduled backups")
+	flag.IntVar(&backupKeep, "backup-keep", DefaultBackupKeep, "number of scheduled backups to keep")
+	flag.DurationVar(&identityRefresh, "identity-refresh", collector.DefaultIdentityRefresh, "time between fetches of each identity profile")
+	flag.DurationVar(&identityTTL, "identity-ttl", collector.DefaultIdentityTTL, "keep identity profiles that cannot be fetched for this long")
 	flag.Func("bind", "Bind web API <ip>:<port> (use [<ip>]:<port> for IPv6)", func(arg string) error {
 		addr, err := parseIPPort(arg, "bind", WebAPIDefaultPort)
 		if err != nil {
@@ -103,6 +147,22 @@ func main() {
 		log.Printf("Unexpected argument: %v", flag.Arg(0))
 		os.Exit(1)
 	}
+	if mode != ModeAll && mode != ModeCrawl && mode != ModeWeb {
+		log.Printf("--mode: expecting all, crawl or web: %v", mode)
+		os.Exit(1)
+	}
+	if mode == ModeWeb && dbfile == store.MemoryDSN {
+		log.Printf("--mode web cannot use an in-memory database")
+		os.Exit(1)
+	}
+	if backupKeep < 1 || backupEvery <= 0 {
+		log.Printf("--backup-keep and --backup-every must be positive")
+		os.Exit(1)
+	}
+	if identityRefresh <= 0 || identityTTL <= 0 {
+		log.Printf("--identity-refresh and --identity-ttl must be positive")
+		os.Exit(1)
+	}
 	if len(binds) < 1 {
 		binds = append(binds, dnet.Address{
 			Host: net.IP([]byte{0, 0, 0, 0}),
@@ -114,43 +174,88 @@ func main() {
 	nodeKey := keysFromEnv()
 	log.Printf("Node PubKey is: %v", hex.EncodeToString(nodeKey.Pub[:]))
 
-	// open database.
-	dbpath := path.Join(dir, dbfile)
-	db, err := store.NewSQLiteStore(dbpath, context.Background())
+	// open database: web instances only read.
+	db, err := openStore(dir, dbfile, mode == ModeWeb)
 	if err != nil {
-		log.Printf("Error opening database: %v [%s]\n", err, dbpath)
+		log.Printf("Error opening database: %v\n", err)
 		os.Exit(1)
 	}
 
+	// optional P2P session capture.
+	var rec *capture.Recorder
+	if captureDir != "" {
+		if !path.IsAbs(captureDir) {
+			captureDir = path.Join(dir, captureDir)
+		}
+		rec, err = capture.NewRecorder(captureDir, capture.DefaultMaxFiles, capture.DefaultMaxBytes)
+		if err != nil {
+			log.Printf("Error creating capture directory: %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("recording P2P sessions in: %v", captureDir)
+	}
+
 	gov := governor.New().CatchSignals().Restart(1 * time.Second)
 
-	// stay connected to local node if specified.
-	if core.IsValid() {
-		gov.Add("local-node", collector.New(db, core, 60*time.Second, true))
-	}
+	if mode != ModeWeb {
+		// load the geoIP database (the web API serves stored locations)
+		// https://github.com/sapics/ip-location-db/tree/main/dbip-city/dbip-city-ipv4-num.csv.gz
+		geoFile := path.Join(dir, GeoIPFile)
+		log.Printf("loading GeoIP database: %v", geoFile)
+		geoIP, err := geoip.NewGeoIPDatabase(geoFile)
+		if err != nil {
+			log.Printf("Error reading GeoIP database: %v [%s]\n", err, geoFile)
+			os.Exit(1)
+		}
 
-	// start crawling Core Nodes.
-	for n := 0; n < crawl; n++ {
-		gov.Add(fmt.Sprintf("crawler-%d", n), collector.New(db, store.Address{}, 5*time.Minute, false))
-	}
+		// stay connected to local node if specified.
+		if core.IsValid() {
+			gov.Add("local-node", collector.New(db, core, 60*time.Second, true).WithCapture(rec).WithLocator(geoIP))
+		}
 
-	// load the geoIP database
-	// https://github.com/sapics/ip-location-db/tree/main/dbip-city/dbip-city-ipv4-num.csv.gz
-	geoFile := path.Join(dir, GeoIPFile)
-	log.Printf("loading GeoIP database: %v", geoFile)
-	geoIP, err := geoip.NewGeoIPDatabase(geoFile)
-	if err != nil {
-		log.Printf("Error reading GeoIP database: %v [%s]\n", err, geoFile)
-		os.Exit(1)
+		// start crawling Core Nodes.
+		for n := 0; n < crawl; n++ {
+			gov.Add(fmt.Sprintf("crawler-%d", n), collector.New(db, store.Address{}, 5*time.Minute, false).WithCapture(rec).WithLocator(geoIP))
+		}
+
+		// collect DogeBox nodes from `dogenet`.
+		if dogenetAddr != "" {
+			gov.Add("dogenet", collector.NewNetCollector(db, dogenetAddr, collector.DefaultNetPeriod).WithLocator(geoIP))
+		}
+
+		// cache identity profiles from `identity`.
+		if identityAddr != "" {
+			gov.Add("identity", collector.NewIdentityCache(db, identityAddr, identityRefresh, identityTTL))
+		}
+
+		// start the store trimmer, node relocator, network census and search indexer
+		gov.Add("store", store.NewStoreTrimmer(db, retention))
+		gov.Add("relocate", store.NewRelocator(db, geoIP))
+		gov.Add("census", store.NewCensus(db))
+		gov.Add("search", store.NewSearchIndexer(db))
 	}
 
-	// start the web server.
-	for _, to := range binds {
-		gov.Add("web-api", web.New(to, db, geoIP, webdir, dogenetAddr, identityAddr))
+	if mode != ModeCrawl {
+		// start the web server.
+		for _, to := range binds {
+			gov.Add("web-api", web.New(to, db, webdir, identityAddr))
+		}
 	}
 
-	// start the store trimmer
-	gov.Add("store", store.NewStoreTrimmer(db))
+	// optional scheduled backups, written by the crawler: a `--mode web`
+	// server shares the crawler's database, so it does not back it up too.
+	if backupDir != "" && mode != ModeWeb {
+		backuper, ok := db.(store.Backuper)
+		if !ok {
+			log.Printf("--backup is only supported for SQLite databases")
+			os.Exit(1)
+		}
+		if !path.IsAbs(backupDir) {
+			backupDir = path.Join(dir, backupDir)
+		}
+		log.Printf("writing backups every %v in: %v", backupEvery, backupDir)
+		gov.Add("backup", store.NewBackupService(backuper, backupDir, backupEvery, backupKeep))
+	}
 
 	// run services until interrupted.
 	gov.Start()
@@ -158,6 +263,35 @@ func main() {
 	fmt.Println("finished.")
 }
 
+// openStore opens a PostgreSQL DSN or a SQLite file (relative: in storage dir)
+func openStore(dir string, dbfile string, readOnly bool) (spec.Store, error) {
+	if store.IsPostgresDSN(dbfile) {
+		if readOnly {
+			return store.NewPostgresStoreReadOnly(dbfile, context.Background())
+		}
+		return store.NewPostgresStore(dbfile, context.Background())
+	}
+	if dbfile == store.MemoryDSN {
+		log.Printf("Using an in-memory database: nothing will be saved")
+		return store.NewMemoryStore(context.Background()), nil
+	}
+	dbpath := dbfile
+	if !path.IsAbs(dbpath) {
+		dbpath = path.Join(dir, dbfile)
+	}
+	var db spec.Store
+	var err error
+	if readOnly {
+		db, err = store.NewSQLiteStoreReadOnly(dbpath, context.Background())
+	} else {
+		db, err = store.NewSQLiteStore(dbpath, context.Background())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v [%s]", err, dbpath)
+	}
+	return db, nil
+}
+
 // Parse an IPv4 or IPv6 address with optional port.
 func parseIPPort(arg string, name string, defaultPort uint16) (dnet.Address, error) {
 	// net.SplitHostPort doesn't return a specific error code,
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+
+	"code.dogecoin.org/dogemap-backend/internal/capture"
+)
+
+// dogemap replay [--serve <ip>:<port>] <file.cap>
+//
+// Prints the decoded messages in a capture file. With --serve, also
+// listens for connections and plays the peer's side of the session,
+// so a collector (e.g. `dogemap --core <ip>:<port>`) can be pointed at it.
+func replayCmd(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	serve := ""
+	flags.StringVar(&serve, "serve", "", "<ip>:<port> - replay the session to connecting clients")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: dogemap replay [--serve <ip>:<port>] <file.cap>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+
+	session, err := capture.ReadFile(flags.Arg(0))
+	if err != nil {
+		stderr.Printf("%v: %v", flags.Arg(0), err)
+		return 1
+	}
+
+	// print the decoded session.
+	fmt.Printf("session %s with %s: %d frames\n", session.Who, session.Peer, len(session.Frames))
+	peerVer := session.PeerVersion()
+	for _, f := range session.Frames {
+		offset := f.Time.Sub(session.Frames[0].Time).Seconds()
+		fmt.Printf("%10.3f %s %s\n", offset, f.Dir, capture.Describe(f, peerVer))
+	}
+
+	if serve != "" {
+		addr, err := parseIPPort(serve, "serve", CoreNodeDefaultPort)
+		if err != nil {
+			stderr.Printf("%v", err)
+			return 1
+		}
+		ln, err := net.Listen("tcp", addr.String())
+		if err != nil {
+			stderr.Printf("--serve: %v", err)
+			return 1
+		}
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		fmt.Printf("replaying on %v (Ctrl+C to stop)\n", ln.Addr())
+		err = capture.ServeReplay(ctx, ln, session)
+		if err != nil {
+			stderr.Printf("replay: %v", err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/collector"
+	"code.dogecoin.org/dogemap-backend/internal/simnet"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/store"
+)
+
+// dogemap simulate [options]
+//
+// Runs the crawlers against a simulated network of fake nodes on loopback
+// and reports how quickly the map converges to the true node set.
+// With --fast-forward, the crawlers and the store trimmer run on a fast
+// clock, so node expiry (see --retention) plays out in seconds.
+func simulateCmd(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	cfg := simnet.Config{}
+	opts := simnet.CrawlOptions{}
+	var scale float64
+	var speed float64
+	retention := spec.DefaultRetention
+	var timeout time.Duration
+	flags.IntVar(&cfg.Nodes, "nodes", 50, "number of simulated nodes")
+	flags.IntVar(&cfg.KnownPeers, "known", 10, "addrman size of each node")
+	flags.Float64Var(&cfg.Offline, "offline", 0.1, "fraction of nodes initially offline")
+	flags.Float64Var(&cfg.Churn, "churn", 0.05, "fraction of nodes toggled per churn round")
+	flags.DurationVar(&cfg.ChurnEvery, "churn-every", 2*time.Second, "interval between churn rounds, in real time (0 to disable)")
+	flags.Int64Var(&cfg.Seed, "seed", 1, "random seed")
+	flags.IntVar(&opts.Crawlers, "crawl", 4, "number of crawlers")
+	flags.Float64Var(&opts.Target, "target", 0.95, "stop when this fraction of online nodes is mapped")
+	flags.Float64Var(&scale, "scale", 0.01, "crawler schedule time-scale (1 = real time)")
+	flags.Float64Var(&speed, "fast-forward", 0, "run the crawlers and trimmer on a clock this many times faster than real time (e.g. 3600: an hour per second; replaces --scale)")
+	flags.Var(&retention, "retention", "with --fast-forward: days to keep nodes: gossiped=N,reachable=N,identity=N[,archive]")
+	flags.DurationVar(&timeout, "timeout", 2*time.Minute, "give up after this long")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		stderr.Printf("Unexpected argument: %v", flags.Arg(0))
+		return 1
+	}
+	if speed < 0 {
+		stderr.Printf("--fast-forward must be positive")
+		return 1
+	}
+	opts.Schedule = collector.DefaultSchedule.Scaled(scale)
+	if speed > 0 {
+		// the clock scales the schedule.
+		clk := clock.NewFastForward(time.Now(), speed)
+		cfg.Clock, opts.Clock = clk, clk
+		cfg.ChurnEvery = time.Duration(float64(cfg.ChurnEvery) * speed)
+		opts.Schedule = collector.DefaultSchedule
+		opts.Retain = retention
+	}
+	opts.MaxTime = 5 * time.Second
+	opts.Poll = 250 * time.Millisecond
+
+	// throw-away database.
+	db := store.NewMemoryStore(context.Background())
+
+	net, err := simnet.New(cfg)
+	if err != nil {
+		stderr.Printf("%v", err)
+		return 1
+	}
+	defer net.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancelT := context.WithTimeout(ctx, timeout)
+	defer cancelT()
+
+	res, err := net.Converge(ctx, db, opts)
+	if err != nil {
+		stderr.Printf("simulate: %v", err)
+		return 1
+	}
+	for _, s := range res.Samples {
+		fmt.Println(s)
+	}
+	if !res.Converged {
+		fmt.Printf("did not converge to %.0f%% within %v\n", opts.Target*100, timeout)
+		return 2
+	}
+	fmt.Printf("converged to %.0f%% in %.2fs\n", opts.Target*100, res.Elapsed.Seconds())
+	return 0
+}
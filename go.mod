@@ -5,6 +5,7 @@ go 1.20
 require (
 	code.dogecoin.org/gossip v0.0.18
 	code.dogecoin.org/governor v1.0.2
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/philpearl/intern v0.0.1
 )
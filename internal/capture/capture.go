@@ -0,0 +1,306 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	core "code.dogecoin.org/dogemap-backend/internal/core"
+)
+
+// Capture file format:
+//
+//	magic    [8]  "DMCAP01\n"
+//	who      u16le length + bytes (crawler name)
+//	peer     u16le length + bytes (peer address)
+//	frames   repeated until EOF:
+//	  time   i64le UnixNano
+//	  dir    u8    'I' (inbound) or 'O' (outbound)
+//	  length u32le (at most MaxFrameSize)
+//	  msg    [length] raw message: 24-byte header + payload (see core.EncodeMessage)
+//	         as sent or received, which may be invalid or truncated
+const FileMagic = "DMCAP01\n"
+const FileExt = ".cap"
+
+// Largest frame: a header and the protocol's largest payload.
+const MaxFrameSize = 24 + core.MaxMsgSize
+
+// Default limits for the rolling capture directory.
+const DefaultMaxFiles = 200
+const DefaultMaxBytes = 256 * 1024 * 1024
+
+type Direction byte
+
+const (
+	Inbound  Direction = 'I' // received from the peer
+	Outbound Direction = 'O' // sent to the peer
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "<-"
+	case Outbound:
+		return "->"
+	default:
+		return "??"
+	}
+}
+
+// Frame is a single P2P message captured on the wire.
+type Frame struct {
+	Time time.Time
+	Dir  Direction
+	Msg  []byte // raw message including the 24-byte header
+}
+
+// Command returns the command name from the frame's message header.
+func (f Frame) Command() string {
+	if len(f.Msg) < 24 {
+		return ""
+	}
+	return core.DecodeHeader([24]byte(f.Msg[:24])).Command
+}
+
+// Payload returns the message payload following the header.
+func (f Frame) Payload() []byte {
+	if len(f.Msg) < 24 {
+		return nil
+	}
+	return f.Msg[24:]
+}
+
+// Recorder writes P2P sessions into a rolling capture directory.
+// Once the directory exceeds MaxFiles or MaxBytes, the oldest
+// captures are deleted.
+type Recorder struct {
+	Dir      string
+	MaxFiles int
+	MaxBytes int64
+	mutex    sync.Mutex // serialises pruning between crawlers
+}
+
+func NewRecorder(dir string, maxFiles int, maxBytes int64) (*Recorder, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("capture: %v", err)
+	}
+	return &Recorder{Dir: dir, MaxFiles: maxFiles, MaxBytes: maxBytes}, nil
+}
+
+// Open starts a new capture file for a session between `who` (the crawler)
+// and `peer`. A nil Recorder returns a nil Session, which records nothing.
+func (r *Recorder) Open(who string, peer string) (*Session, error) {
+	if r == nil {
+		return nil, nil
+	}
+	r.prune()
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s_%s_%s%s", now.Format("20060102T150405.000000000"), safeName(who), safeName(peer), FileExt)
+	file, err := os.Create(filepath.Join(r.Dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("capture: %v", err)
+	}
+	s := &Session{file: file, w: bufio.NewWriter(file)}
+	s.w.WriteString(FileMagic)
+	writeString(s.w, who)
+	writeString(s.w, peer)
+	return s, nil
+}
+
+// prune removes the oldest capture files to stay within limits.
+func (r *Recorder) prune() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ents, err := os.ReadDir(r.Dir)
+	if err != nil {
+		log.Printf("[capture] cannot list directory: %v", err)
+		return
+	}
+	type capFile struct {
+		name string
+		size int64
+	}
+	var files []capFile
+	var total int64
+	for _, ent := range ents {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), FileExt) {
+			continue
+		}
+		info, err := ent.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, capFile{name: ent.Name(), size: info.Size()})
+		total += info.Size()
+	}
+	// file names begin with a UTC timestamp, so they sort oldest-first.
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	// leave room for the file about to be created.
+	for len(files) > 0 && ((r.MaxFiles > 0 && len(files) >= r.MaxFiles) || (r.MaxBytes > 0 && total > r.MaxBytes)) {
+		err := os.Remove(filepath.Join(r.Dir, files[0].name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[capture] cannot remove old capture: %v", err)
+		}
+		total -= files[0].size
+		files = files[1:]
+	}
+}
+
+// Session records the frames of one P2P connection.
+// All methods are safe to call on a nil Session.
+type Session struct {
+	mutex sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+}
+
+// Sent records a raw message sent to the peer.
+func (s *Session) Sent(msg []byte) {
+	s.record(Outbound, msg)
+}
+
+// Received records the raw bytes of a message received from the peer
+// (see core.ReadFrame), including messages that fail to decode.
+func (s *Session) Received(raw []byte) {
+	s.record(Inbound, raw)
+}
+
+func (s *Session) record(dir Direction, msg []byte) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var hdr [13]byte
+	binary.LittleEndian.PutUint64(hdr[0:8], uint64(time.Now().UnixNano()))
+	hdr[8] = byte(dir)
+	binary.LittleEndian.PutUint32(hdr[9:13], uint32(len(msg)))
+	s.w.Write(hdr[:])
+	s.w.Write(msg)
+}
+
+func (s *Session) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := s.w.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WrapConn returns a net.Conn that records everything written to it as
+// Outbound frames. Each Write must contain exactly one encoded message.
+func WrapConn(conn net.Conn, s *Session) net.Conn {
+	if s == nil {
+		return conn
+	}
+	return &recordingConn{Conn: conn, s: s}
+}
+
+type recordingConn struct {
+	net.Conn
+	s *Session
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.s.Sent(b[:n])
+	}
+	return n, err
+}
+
+// Capture is a decoded capture file.
+type Capture struct {
+	Who    string
+	Peer   string
+	Frames []Frame
+}
+
+// ReadFile reads a capture file written by a Recorder.
+// A truncated final frame (e.g. after a crash) is ignored.
+func ReadFile(fileName string) (Capture, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return Capture{}, err
+	}
+	defer file.Close()
+	return Read(bufio.NewReader(file))
+}
+
+// Read decodes a capture from a stream.
+func Read(r io.Reader) (c Capture, err error) {
+	magic := make([]byte, len(FileMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != FileMagic {
+		return c, errors.New("capture: not a capture file")
+	}
+	if c.Who, err = readString(r); err != nil {
+		return c, fmt.Errorf("capture: reading header: %v", err)
+	}
+	if c.Peer, err = readString(r); err != nil {
+		return c, fmt.Errorf("capture: reading header: %v", err)
+	}
+	for {
+		var hdr [13]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return c, nil // EOF or truncated frame
+		}
+		length := binary.LittleEndian.Uint32(hdr[9:13])
+		if length > MaxFrameSize {
+			return c, fmt.Errorf("capture: frame %d: invalid length %d", len(c.Frames), length)
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return c, nil // truncated frame
+		}
+		c.Frames = append(c.Frames, Frame{
+			Time: time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[0:8]))),
+			Dir:  Direction(hdr[8]),
+			Msg:  msg,
+		})
+	}
+}
+
+func writeString(w *bufio.Writer, s string) {
+	var n [2]byte
+	binary.LittleEndian.PutUint16(n[:], uint16(len(s)))
+	w.Write(n[:])
+	w.WriteString(s)
+}
+
+func readString(r io.Reader) (string, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	buf := make([]byte, binary.LittleEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// safeName makes a string safe for use in a file name.
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, s)
+}
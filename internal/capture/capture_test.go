@@ -0,0 +1,101 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	core "code.dogecoin.org/dogemap-backend/internal/core"
+)
+
+func TestRecordRead(t *testing.T) {
+	rec, err := NewRecorder(t.TempDir(), DefaultMaxFiles, DefaultMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := rec.Open("crawler-0", "1.2.3.4:22556")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ping := core.EncodeMessage("ping", core.EncodePing(core.PingMsg{Nonce: 42}))
+	bad := core.EncodeMessage("pong", []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	bad[20] ^= 0xff // checksum mismatch
+	garbage := []byte("GET / HTTP/1.1\r\n")
+	sess.Sent(ping)
+	sess.Received(bad)
+	sess.Received(garbage)
+	if err := sess.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(rec.Dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one capture file, got %v %v", files, err)
+	}
+
+	c, err := ReadFile(filepath.Join(rec.Dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Who != "crawler-0" || c.Peer != "1.2.3.4:22556" {
+		t.Fatalf("unexpected header: %q %q", c.Who, c.Peer)
+	}
+	expect := []Frame{{Dir: Outbound, Msg: ping}, {Dir: Inbound, Msg: bad}, {Dir: Inbound, Msg: garbage}}
+	if len(c.Frames) != len(expect) {
+		t.Fatalf("expected %d frames, got %d", len(expect), len(c.Frames))
+	}
+	for i, f := range c.Frames {
+		if f.Dir != expect[i].Dir || !bytes.Equal(f.Msg, expect[i].Msg) || f.Time.IsZero() {
+			t.Fatalf("frame %d: expected %v %x, got %v %x", i, expect[i].Dir, expect[i].Msg, f.Dir, f.Msg)
+		}
+	}
+	if c.Frames[0].Command() != "ping" {
+		t.Fatalf("expected 'ping', got %q", c.Frames[0].Command())
+	}
+	if desc := Describe(c.Frames[0], 0); desc != "ping nonce=42" {
+		t.Fatalf("Describe: unexpected %q", desc)
+	}
+	if desc := Describe(c.Frames[1], 0); !strings.HasPrefix(desc, `invalid "pong"`) {
+		t.Fatalf("Describe: expected an invalid frame, got %q", desc)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(FileMagic)
+	buf.Write([]byte{1, 0, 'a', 1, 0, 'b'})
+	frame := func(length uint32, msg []byte) {
+		var hdr [13]byte
+		hdr[8] = byte(Inbound)
+		binary.LittleEndian.PutUint32(hdr[9:13], length)
+		buf.Write(hdr[:])
+		buf.Write(msg)
+	}
+	frame(4, []byte("abcd"))
+	frame(10, []byte("abc")) // truncated (e.g. after a crash)
+	c, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil || len(c.Frames) != 1 {
+		t.Fatalf("expected 1 frame, got %d: %v", len(c.Frames), err)
+	}
+}
+
+func TestReadCorruptLength(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(FileMagic)
+	buf.Write([]byte{1, 0, 'a', 1, 0, 'b'})
+	var hdr [13]byte
+	hdr[8] = byte(Inbound)
+	binary.LittleEndian.PutUint32(hdr[9:13], 0xffffffff)
+	buf.Write(hdr[:])
+	if _, err := Read(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("expected an error for an invalid frame length")
+	}
+}
+
+func TestReadNotCapture(t *testing.T) {
+	if _, err := Read(strings.NewReader("not a capture file")); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
@@ -0,0 +1,120 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	core "code.dogecoin.org/dogemap-backend/internal/core"
+)
+
+// Describe decodes a frame into a human-readable summary.
+// `peerVer` is the protocol version announced by the peer (0 if unknown),
+// which determines the 'addr' message format.
+func Describe(f Frame, peerVer int32) string {
+	cmd := f.Command()
+	payload := f.Payload()
+	if _, _, err := core.DecodeFrame(f.Msg); err != nil {
+		// recorded as received (see Session.Received)
+		return fmt.Sprintf("invalid %q len=%d: %v", cmd, len(f.Msg), err)
+	}
+	switch cmd {
+	case "version":
+		v := core.DecodeVersion(payload)
+		return fmt.Sprintf("version %d agent=%q services=%x height=%d time=%d relay=%v", v.Version, v.Agent, v.Services, v.Height, v.Timestamp, v.Relay)
+	case "ping", "pong":
+		return fmt.Sprintf("%s nonce=%d", cmd, core.DecodePing(payload).Nonce)
+	case "reject":
+		re := core.DecodeReject(payload)
+		return fmt.Sprintf("reject %s %s %q", re.CodeName(), re.Message, re.Reason)
+	case "addr":
+		addr := core.DecodeAddrMsg(payload, peerVer)
+		var b strings.Builder
+		fmt.Fprintf(&b, "addr count=%d", len(addr.AddrList))
+		for i, a := range addr.AddrList {
+			if i == 3 {
+				b.WriteString(" …")
+				break
+			}
+			fmt.Fprintf(&b, " %v", net.JoinHostPort(net.IP(a.Address).String(), fmt.Sprint(a.Port)))
+		}
+		return b.String()
+	case "inv", "getdata", "notfound":
+		inv := core.DecodeInvMsg(payload)
+		return fmt.Sprintf("%s count=%d", cmd, len(inv.InvList))
+	case "getheaders":
+		gh := core.DecodeGetHeaders(payload)
+		return fmt.Sprintf("getheaders version=%d locators=%d", gh.Version, len(gh.BlockLocatorHashes))
+	default:
+		if len(payload) > 32 {
+			return fmt.Sprintf("%s len=%d %s…", cmd, len(payload), hex.EncodeToString(payload[:32]))
+		}
+		return fmt.Sprintf("%s len=%d %s", cmd, len(payload), hex.EncodeToString(payload))
+	}
+}
+
+// PeerVersion returns the protocol version from the first inbound
+// 'version' frame, or 0 if there is none.
+func (c Capture) PeerVersion() int32 {
+	for _, f := range c.Frames {
+		if f.Dir == Inbound && f.Command() == "version" {
+			return core.DecodeVersion(f.Payload()).Version
+		}
+	}
+	return 0
+}
+
+// ServeReplay accepts connections on `ln` and plays the peer's side of the
+// captured session to each client, until the context is cancelled.
+//
+// The replay is lock-step: inbound frames are written to the client, and
+// each outbound frame waits for the client to send its next message.
+// This allows a captured session to be fed back into a Collector.
+func ServeReplay(ctx context.Context, ln net.Listener, c Capture) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			err := Replay(conn, c)
+			if err != nil {
+				log.Printf("[replay] %v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Replay plays the peer's side of a captured session over `conn`.
+func Replay(conn net.Conn, c Capture) error {
+	reader := bufio.NewReader(conn)
+	for i, f := range c.Frames {
+		switch f.Dir {
+		case Inbound:
+			if _, err := conn.Write(f.Msg); err != nil {
+				return fmt.Errorf("frame %d: write: %v", i, err)
+			}
+		case Outbound:
+			cmd, _, err := core.ReadMessage(reader)
+			if err != nil {
+				return fmt.Errorf("frame %d: expecting '%s': %v", i, f.Command(), err)
+			}
+			if cmd != f.Command() {
+				log.Printf("[replay] frame %d: client sent '%s', capture has '%s'", i, cmd, f.Command())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time, so that time-dependent
+// code (e.g. expiry) can be tested with a Fake clock.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for `d` to pass on this clock; it returns true if it
+	// was interrupted by `ctx` (like governor.ServiceCtx.Sleep)
+	Sleep(ctx context.Context, d time.Duration) bool
+}
+
+// System is the real wall clock.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) bool {
+	return sleep(ctx, d)
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done(): // Canceled or DeadlineExceeded
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Fake is a manually-controlled Clock for tests.
+// Sleep blocks until the clock is moved past the end of the sleep.
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	until time.Time
+	wake  chan struct{}
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *Fake) Sleep(ctx context.Context, d time.Duration) bool {
+	f.mutex.Lock()
+	if d <= 0 {
+		f.mutex.Unlock()
+		return ctx.Err() != nil
+	}
+	w := fakeWaiter{until: f.now.Add(d), wake: make(chan struct{})}
+	f.waiters = append(f.waiters, w)
+	f.mutex.Unlock()
+	select {
+	case <-ctx.Done():
+		f.mutex.Lock()
+		for i, other := range f.waiters {
+			if other.wake == w.wake {
+				f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+				break
+			}
+		}
+		f.mutex.Unlock()
+		return true
+	case <-w.wake:
+		return false
+	}
+}
+
+// Sleepers returns the number of goroutines blocked in Sleep, so a
+// test can wait for a service to reach its next Sleep before advancing.
+func (f *Fake) Sleepers() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.waiters)
+}
+
+// Set moves the clock to `now` (which may be in the past).
+func (f *Fake) Set(now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = now
+	f.wake()
+}
+
+// Advance moves the clock forward by `d`.
+func (f *Fake) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+	f.wake()
+}
+
+// wake ends every Sleep that has reached its end; caller holds the mutex.
+func (f *Fake) wake() {
+	waiting := f.waiters[:0]
+	for _, w := range f.waiters {
+		if f.now.Before(w.until) {
+			waiting = append(waiting, w)
+		} else {
+			close(w.wake)
+		}
+	}
+	f.waiters = waiting
+}
+
+// FastForward is a Clock that runs `speed` times faster than real time,
+// starting at `start`: e.g. at speed 3600 an hour passes every second,
+// so expiry and hourly rollups can be simulated (see `dogemap simulate`)
+type FastForward struct {
+	start time.Time // clock time at `real`
+	real  time.Time // real time when the clock started
+	speed float64
+}
+
+func NewFastForward(start time.Time, speed float64) *FastForward {
+	return &FastForward{start: start, real: time.Now(), speed: speed}
+}
+
+func (f *FastForward) Now() time.Time {
+	elapsed := time.Since(f.real)
+	return f.start.Add(time.Duration(float64(elapsed) * f.speed))
+}
+
+func (f *FastForward) Sleep(ctx context.Context, d time.Duration) bool {
+	return sleep(ctx, time.Duration(float64(d)/f.speed))
+}
@@ -0,0 +1,143 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// sleepAsync starts a Sleep on `clk` and returns its result channel,
+// once the Sleep is blocked.
+func sleepAsync(t *testing.T, ctx context.Context, clk *Fake, d time.Duration) chan bool {
+	t.Helper()
+	before := clk.Sleepers()
+	res := make(chan bool, 1)
+	go func() { res <- clk.Sleep(ctx, d) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for clk.Sleepers() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for Sleep")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return res
+}
+
+func expectWake(t *testing.T, res chan bool, cancelled bool) {
+	t.Helper()
+	select {
+	case got := <-res:
+		if got != cancelled {
+			t.Fatalf("Sleep: expected %v, got %v", cancelled, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep did not return")
+	}
+}
+
+func expectAsleep(t *testing.T, res chan bool) {
+	t.Helper()
+	select {
+	case got := <-res:
+		t.Fatalf("Sleep returned early: %v", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestFakeNow(t *testing.T) {
+	clk := NewFake(testStart)
+	if !clk.Now().Equal(testStart) {
+		t.Fatalf("expected %v, got %v", testStart, clk.Now())
+	}
+	clk.Advance(time.Hour)
+	if expect := testStart.Add(time.Hour); !clk.Now().Equal(expect) {
+		t.Fatalf("Advance: expected %v, got %v", expect, clk.Now())
+	}
+	clk.Set(testStart.Add(-time.Hour))
+	if expect := testStart.Add(-time.Hour); !clk.Now().Equal(expect) {
+		t.Fatalf("Set: expected %v, got %v", expect, clk.Now())
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFake(testStart)
+	res := sleepAsync(t, ctx, clk, time.Minute)
+	clk.Advance(59 * time.Second)
+	expectAsleep(t, res)
+	if n := clk.Sleepers(); n != 1 {
+		t.Fatalf("expected 1 sleeper, got %d", n)
+	}
+	clk.Advance(time.Second)
+	expectWake(t, res, false)
+	if n := clk.Sleepers(); n != 0 {
+		t.Fatalf("expected no sleepers after waking, got %d", n)
+	}
+
+	// Set wakes only the sleeps it passes.
+	short := sleepAsync(t, ctx, clk, time.Minute)
+	long := sleepAsync(t, ctx, clk, time.Hour)
+	clk.Set(clk.Now().Add(30 * time.Minute))
+	expectWake(t, short, false)
+	expectAsleep(t, long)
+	if n := clk.Sleepers(); n != 1 {
+		t.Fatalf("expected 1 sleeper, got %d", n)
+	}
+	clk.Set(testStart) // backwards: nothing wakes
+	expectAsleep(t, long)
+	clk.Advance(2 * time.Hour)
+	expectWake(t, long, false)
+
+	// zero and negative durations return at once.
+	if clk.Sleep(ctx, 0) || clk.Sleep(ctx, -time.Second) {
+		t.Fatal("Sleep(0): expected not cancelled")
+	}
+}
+
+func TestFakeSleepCancel(t *testing.T) {
+	clk := NewFake(testStart)
+	ctx, cancel := context.WithCancel(context.Background())
+	res := sleepAsync(t, ctx, clk, time.Minute)
+	other := sleepAsync(t, context.Background(), clk, time.Minute)
+	cancel()
+	expectWake(t, res, true)
+	if n := clk.Sleepers(); n != 1 {
+		t.Fatalf("expected the cancelled sleeper to be removed, got %d sleepers", n)
+	}
+	clk.Advance(time.Minute)
+	expectWake(t, other, false)
+
+	// a cancelled context ends even zero-length sleeps.
+	if !clk.Sleep(ctx, 0) || !clk.Sleep(ctx, time.Hour) {
+		t.Fatal("Sleep: expected cancelled")
+	}
+	if n := clk.Sleepers(); n != 0 {
+		t.Fatalf("expected no sleepers, got %d", n)
+	}
+}
+
+func TestFastForward(t *testing.T) {
+	clk := NewFastForward(testStart, 3600)
+	if now := clk.Now(); now.Before(testStart) || now.After(testStart.Add(time.Minute)) {
+		t.Fatalf("expected about %v, got %v", testStart, now)
+	}
+	// an hour passes in a second, so a 36s sleep takes 10ms.
+	began := time.Now()
+	if clk.Sleep(context.Background(), 36*time.Second) {
+		t.Fatal("Sleep: expected not cancelled")
+	}
+	if real := time.Since(began); real < 10*time.Millisecond || real > time.Second {
+		t.Errorf("Sleep(36s) at 3600x: expected about 10ms, took %v", real)
+	}
+	if elapsed := clk.Now().Sub(testStart); elapsed < 36*time.Second {
+		t.Errorf("expected at least 36s to pass on the clock, got %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if !clk.Sleep(ctx, time.Hour) {
+		t.Fatal("Sleep: expected cancelled")
+	}
+}
@@ -2,6 +2,7 @@ package collector
 
 import (
 	"bufio"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net"
@@ -10,6 +11,8 @@ import (
 
 	"code.dogecoin.org/governor"
 
+	"code.dogecoin.org/dogemap-backend/internal/capture"
+	"code.dogecoin.org/dogemap-backend/internal/clock"
 	core "code.dogecoin.org/dogemap-backend/internal/core"
 	"code.dogecoin.org/dogemap-backend/internal/spec"
 )
@@ -20,23 +23,85 @@ const CurrentProtocolVersion = 70015
 // Minimum height accepted by other nodes
 const MinimumBlockHeight = 700000
 
+// Core Nodes disconnect peers with a longer user agent (MAX_SUBVERSION_LENGTH)
+const MaxSubVersionLength = 256
+
 // Our DogeMap Node services
 const DogeMapServices = 0
 
+// Dogecoin mainnet genesis block and block 1 (internal byte order)
+var GenesisBlockHash = reverseHex("1a91e3dace36e2be3bf030a65679fe821aa1d6ef92e7c9902eb318182c355691")
+var Block1Hash = reverseHex("82bc68038f6034c0596b6e313729793a887fded6e92a31fbdf70863f89d9bea2")
+
+// Schedule controls how often a Collector connects to nodes.
+type Schedule struct {
+	Retry       time.Duration // wait when no nodes are available to connect to
+	Reconnect   time.Duration // wait between connections (avoid spamming on connect errors)
+	Backoff     time.Duration // wait after receiving addresses, when no new nodes were found
+	BackoffStep time.Duration // Backoff is reduced by this much per new node found (also the minimum wait)
+}
+
+var DefaultSchedule = Schedule{
+	Retry:       5 * time.Second,
+	Reconnect:   10 * time.Second,
+	Backoff:     60 * time.Second,
+	BackoffStep: 1 * time.Second,
+}
+
+// Scaled returns the schedule with all durations multiplied by `factor`.
+func (s Schedule) Scaled(factor float64) Schedule {
+	scale := func(d time.Duration) time.Duration { return time.Duration(float64(d) * factor) }
+	return Schedule{
+		Retry:       scale(s.Retry),
+		Reconnect:   scale(s.Reconnect),
+		Backoff:     scale(s.Backoff),
+		BackoffStep: scale(s.BackoffStep),
+	}
+}
+
 func New(store spec.Store, fromAddr spec.Address, maxTime time.Duration, isLocal bool) *Collector {
-	c := &Collector{_store: store, Address: fromAddr, maxTime: maxTime, isLocal: isLocal}
+	c := &Collector{_store: store, Address: fromAddr, maxTime: maxTime, isLocal: isLocal, schedule: DefaultSchedule, clock: clock.System}
 	return c
 }
 
 type Collector struct {
 	governor.ServiceCtx
-	_store  spec.Store
-	store   spec.Store
-	mutex   sync.Mutex
-	conn    net.Conn
-	Address spec.Address
-	maxTime time.Duration
-	isLocal bool
+	_store   spec.Store
+	store    spec.Store
+	mutex    sync.Mutex
+	conn     net.Conn
+	Address  spec.Address
+	maxTime  time.Duration
+	isLocal  bool
+	capture  *capture.Recorder
+	schedule Schedule
+	locator  spec.Locator
+	clock    clock.Clock
+}
+
+// WithSchedule replaces the DefaultSchedule.
+func (c *Collector) WithSchedule(s Schedule) *Collector {
+	c.schedule = s
+	return c
+}
+
+// WithClock replaces clock.System, for the store and the schedule;
+// network timing (RTT and clock skew) always uses real time.
+func (c *Collector) WithClock(clk clock.Clock) *Collector {
+	c.clock = clk
+	return c
+}
+
+// WithCapture records every P2P session of this collector using `rec`.
+func (c *Collector) WithCapture(rec *capture.Recorder) *Collector {
+	c.capture = rec
+	return c
+}
+
+// WithLocator resolves the location of each address as it is ingested.
+func (c *Collector) WithLocator(l spec.Locator) *Collector {
+	c.locator = l
+	return c
 }
 
 func (c *Collector) Stop() {
@@ -54,24 +119,26 @@ func (c *Collector) Stop() {
 func (c *Collector) Run() {
 	who := c.Address.String()
 	for {
-		c.store = c._store.WithCtx(c.Context) // Service Context is first available here
+		c.store = c._store.WithCtx(c.Context).WithClock(c.clock) // Service Context is first available here
 		// choose the next node to connect to
 		remoteNode := c.Address
 		for !remoteNode.IsValid() {
 			var err error
 			remoteNode, err = c.store.ChooseCoreNode()
-			if err != nil {
+			if err != nil && !spec.IsNotFoundError(err) {
 				log.Printf("[%s] ChooseCoreNode: %v", who, err)
 			} else if remoteNode.IsValid() {
 				break
 			}
 			// none available, wait for local listener to add nodes
-			c.Sleep(5 * time.Second)
+			if c.clock.Sleep(c.Context, c.schedule.Retry) {
+				return // context was cancelled
+			}
 		}
 		// collect addresses from the node until the timeout
 		c.collectAddresses(remoteNode)
 		// avoid spamming on connect errors
-		if c.Sleep(10 * time.Second) {
+		if c.clock.Sleep(c.Context, c.schedule.Reconnect) {
 			// context was cancelled
 			return
 		}
@@ -90,6 +157,14 @@ func (c *Collector) collectAddresses(nodeAddr spec.Address) {
 	}
 	defer conn.Close()
 
+	// optionally record the raw session.
+	sess, err := c.capture.Open(c.ServiceName, who)
+	if err != nil {
+		log.Printf("[%s] %v", who, err)
+	}
+	defer sess.Close()
+	conn = capture.WrapConn(conn, sess)
+
 	c.mutex.Lock()
 	c.conn = conn // for shutdown
 	c.mutex.Unlock()
@@ -101,6 +176,7 @@ func (c *Collector) collectAddresses(nodeAddr spec.Address) {
 	reader := bufio.NewReader(conn)
 
 	// send our 'version' message
+	sentAt := time.Now()
 	_, err = conn.Write(core.EncodeMessage("version", makeVersion(CurrentProtocolVersion))) // nodeVer
 	if err != nil {
 		fmt.Printf("[%s] Error sending version message: %v\n", who, err)
@@ -110,15 +186,21 @@ func (c *Collector) collectAddresses(nodeAddr spec.Address) {
 	//fmt.Printf("[%s] Sent 'version' message\n", who)
 
 	// expect the version message from the node
-	version, err := expectVersion(reader)
+	version, err := expectVersion(reader, sess)
 	if err != nil {
 		fmt.Printf("[%s] %v\n", who, err)
 		return
 	}
+	recvAt := time.Now()
 
 	//fmt.Printf("[%s] Received 'version': %v\n", who, version)
 
 	nodeVer := version.Version // other node's version
+	if nodeVer >= WtxidRelayVersion {
+		// must be sent before 'verack' (BIP 339). Not 'sendaddrv2': the
+		// peer would answer 'getaddr' with 'addrv2', which we don't decode.
+		sendMessage(conn, "wtxidrelay", []byte{}, who)
+	}
 	if nodeVer >= 209 {
 		// send 'verack' in response
 		_, err = conn.Write(core.EncodeMessage("verack", []byte{}))
@@ -134,10 +216,26 @@ func (c *Collector) collectAddresses(nodeAddr spec.Address) {
 		c.store.UpdateCoreTime(nodeAddr)
 	}
 
+	// probe optional protocol features; the node's own negotiation
+	// messages and its 'headers' reply are recorded in `probe`.
+	probe := measureClock(version, sentAt, recvAt)
+	probe.Version = version.Version
+	probe.Agent = version.Agent
+	if len(probe.Agent) > MaxSubVersionLength {
+		probe.Agent = probe.Agent[:MaxSubVersionLength]
+	}
+	defer func() {
+		err := c.store.UpdateCoreProbe(nodeAddr, probe)
+		if err != nil {
+			log.Printf("[%s] UpdateCoreProbe: %v", who, err)
+		}
+	}()
+	sendFeatures(conn, nodeVer, who)
+
 	addresses := 0
 	total := 0
 	for {
-		cmd, payload, err := core.ReadMessage(reader)
+		cmd, payload, err := readMessage(reader, sess)
 		if err != nil {
 			fmt.Printf("[%s] Error reading message: %v\n", who, err)
 			return
@@ -152,46 +250,62 @@ func (c *Collector) collectAddresses(nodeAddr spec.Address) {
 			sendGetAddr(conn, who)
 			//fmt.Printf("[%s] Sent getaddr.\n", who)
 
+		case "sendheaders":
+			probe.Caps |= spec.CapSendHeaders
+
+		case "sendcmpct":
+			probe.Caps |= spec.CapSendCmpct
+
+		case "feefilter":
+			probe.Caps |= spec.CapFeeFilter
+
+		case "sendaddrv2":
+			probe.Caps |= spec.CapSendAddrV2
+
+		case "wtxidrelay":
+			probe.Caps |= spec.CapWtxidRelay
+
+		case "headers":
+			probe.Caps |= spec.CapHeaders
+
 		case "reject":
 			re := core.DecodeReject(payload)
 			fmt.Printf("[%s] Reject: %v %v %v\n", who, re.CodeName(), re.Message, re.Reason)
 
 		case "addr":
 			addr := core.DecodeAddrMsg(payload, nodeVer)
-			_, oldLen, err := c.store.CoreStats()
-			if err != nil {
-				fmt.Printf("[%s] CoreStats: %v\n", who, err)
-				break
-			}
-			kept := 0
-			unixTimeSec := time.Now().Unix()
+			unixTimeSec := c.clock.Now().Unix()
 			validAfter := unixTimeSec - spec.MaxCoreNodeDays*spec.SecondsPerDay
+			batch := make([]spec.CoreAddr, 0, len(addr.AddrList))
 			for _, a := range addr.AddrList {
 				unixTimeSec := int64(a.Time)
 				if unixTimeSec > validAfter {
-					c.store.AddCoreNode(spec.Address{Host: net.IP(a.Address), Port: a.Port}, unixTimeSec, a.Services)
-					kept++
+					node := spec.CoreAddr{Address: spec.Address{Host: net.IP(a.Address), Port: a.Port}, Time: unixTimeSec, Services: a.Services}
+					if c.locator != nil {
+						node.Location = c.locator.Locate(node.Address.Host)
+					}
+					batch = append(batch, node)
 				}
 			}
-			dbSize, newLen, err := c.store.CoreStats()
+			added, updated, err := c.store.AddCoreNodes(batch)
 			if err != nil {
-				fmt.Printf("[%s] CoreStats: %v\n", who, err)
+				fmt.Printf("[%s] AddCoreNodes: %v\n", who, err)
 				break
 			}
-			fmt.Printf("[%s] Addresses: %d received, %d expired, %d new, %d in DB\n", who, len(addr.AddrList), len(addr.AddrList)-kept, (newLen - oldLen), dbSize)
+			fmt.Printf("[%s] Addresses: %d received, %d expired, %d new, %d updated\n", who, len(addr.AddrList), len(addr.AddrList)-len(batch), added, updated)
 			addresses += len(addr.AddrList)
-			total += (newLen - oldLen)
+			total += added
 			if addresses >= 1000 {
 				// done: try the next node (or reconnect to local node)
 				// a node will only respond once to the 'addr' request
 				conn.Close()
 				// back off as the number of kept nodes falls towards zero
-				wait := 60 - total
-				if wait < 1 {
-					wait = 1
+				wait := c.schedule.Backoff - time.Duration(total)*c.schedule.BackoffStep
+				if wait < c.schedule.BackoffStep {
+					wait = c.schedule.BackoffStep
 				}
 				//fmt.Printf("[%s] Sleeping for %v\n", who, wait)
-				c.Sleep(time.Duration(wait) * time.Second)
+				c.clock.Sleep(c.Context, wait)
 				return
 			}
 
@@ -230,11 +344,11 @@ func makeVersion(remoteVersion int32) []byte {
 	return core.EncodeVersion(version)
 }
 
-func expectVersion(reader *bufio.Reader) (core.VersionMsg, error) {
+func expectVersion(reader *bufio.Reader, sess *capture.Session) (core.VersionMsg, error) {
 	// Core Node implementation: if connection is inbound, send Version immediately.
 	// This means we'll receive the Node's version before `verack` for our Version,
 	// however this is undocumented, so other nodes might ack first.
-	cmd, payload, err := core.ReadMessage(reader)
+	cmd, payload, err := readMessage(reader, sess)
 	if err != nil {
 		return core.VersionMsg{}, fmt.Errorf("error reading message: %v", err)
 	}
@@ -248,6 +362,78 @@ func expectVersion(reader *bufio.Reader) (core.VersionMsg, error) {
 	return core.VersionMsg{}, fmt.Errorf("expected 'version' message from node, but received: %s", cmd)
 }
 
+// readMessage reads the next message (see core.ReadMessage), recording
+// the raw bytes received in the capture session, even if they are invalid.
+func readMessage(reader *bufio.Reader, sess *capture.Session) (cmd string, payload []byte, err error) {
+	raw, err := core.ReadFrame(reader)
+	if len(raw) > 0 {
+		sess.Received(raw)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return core.DecodeFrame(raw)
+}
+
+// Protocol versions that introduced each negotiation message
+const (
+	SendHeadersVersion = 70012 // BIP 130
+	FeeFilterVersion   = 70013 // BIP 133
+	ShortIDsVersion    = 70014 // BIP 152
+	WtxidRelayVersion  = 70016 // BIP 339
+)
+
+// measureClock estimates the node's clock offset from its 'version' timestamp.
+// Core Nodes send their 'version' as soon as they receive ours, so the
+// timestamp was taken about half-way through the round trip.
+func measureClock(version core.VersionMsg, sentAt time.Time, recvAt time.Time) spec.CoreProbe {
+	rtt := recvAt.Sub(sentAt)
+	midpoint := sentAt.Add(rtt / 2)
+	skew := time.Unix(version.Timestamp, 0).Sub(midpoint).Round(time.Second)
+	return spec.CoreProbe{
+		Skew: int64(skew / time.Second),
+		RTT:  rtt.Milliseconds(),
+	}
+}
+
+// sendFeatures announces optional features after the handshake,
+// and asks for a single block header to see if the node serves headers.
+func sendFeatures(conn net.Conn, nodeVer int32, who string) {
+	if nodeVer >= SendHeadersVersion {
+		sendMessage(conn, "sendheaders", []byte{}, who)
+	}
+	if nodeVer >= ShortIDsVersion {
+		sendMessage(conn, "sendcmpct", core.EncodeSendCmpct(core.SendCmpctMsg{Announce: false, Version: 1}), who)
+	}
+	if nodeVer >= FeeFilterVersion {
+		sendMessage(conn, "feefilter", core.EncodeFeeFilter(core.FeeFilterMsg{FeeRate: 0}), who)
+	}
+	sendMessage(conn, "getheaders", core.EncodeGetHeaders(core.GetHeadersMsg{
+		Version:            CurrentProtocolVersion,
+		BlockLocatorHashes: [][]byte{GenesisBlockHash},
+		HashStop:           Block1Hash,
+	}), who)
+}
+
+func sendMessage(conn net.Conn, cmd string, payload []byte, who string) {
+	_, err := conn.Write(core.EncodeMessage(cmd, payload))
+	if err != nil {
+		fmt.Printf("[%s] failed to send '%s': %v\n", who, cmd, err)
+	}
+}
+
+// reverseHex decodes a hash in display (big-endian) order to internal order.
+func reverseHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
 func sendPong(conn net.Conn, pingPayload []byte, who string) {
 	// reply with 'pong', same payload (nonce)
 	_, err := conn.Write(core.EncodeMessage("pong", pingPayload))
@@ -0,0 +1,315 @@
+package collector
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	core "code.dogecoin.org/dogemap-backend/internal/core"
+	"code.dogecoin.org/dogemap-backend/internal/fakepeer"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/store"
+)
+
+var testStart = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+var testSchedule = Schedule{
+	Retry:       time.Minute,
+	Reconnect:   time.Hour,
+	Backoff:     2000 * time.Second,
+	BackoffStep: time.Second,
+}
+
+const waitTimeout = 5 * time.Second
+
+// startCollector runs a Collector connected to `peer` (without a governor)
+// on a Fake clock; the collector is stopped when the test ends.
+func startCollector(t *testing.T, db spec.Store, peer *fakepeer.Peer, maxTime time.Duration) (*Collector, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(testStart)
+	c := New(db, peer.Address(), maxTime, false).WithSchedule(testSchedule).WithClock(clk)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Context = ctx
+	c.ServiceName = "collector-test"
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		c.Stop()
+		select {
+		case <-done:
+		case <-time.After(waitTimeout):
+			t.Error("collector did not stop")
+		}
+		peer.Close()
+	})
+	return c, clk
+}
+
+func listen(t *testing.T, script ...fakepeer.Step) *fakepeer.Peer {
+	t.Helper()
+	peer, err := fakepeer.Listen(script...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitSleeping waits for the collector to block in its clock.
+func waitSleeping(t *testing.T, clk *clock.Fake) {
+	t.Helper()
+	waitFor(t, "collector to sleep", func() bool { return clk.Sleepers() == 1 })
+}
+
+func waitConn(t *testing.T, peer *fakepeer.Peer, n int) *fakepeer.Conn {
+	t.Helper()
+	conn, err := peer.WaitConn(n, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func waitClosed(t *testing.T, conn *fakepeer.Conn) {
+	t.Helper()
+	if !conn.WaitClosed(waitTimeout) {
+		t.Fatal("collector did not close the connection")
+	}
+}
+
+func findNode(t *testing.T, db spec.Store, address string) (spec.CoreNode, bool) {
+	t.Helper()
+	nodes, err := db.NodeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		if node.Address == address {
+			return node, true
+		}
+	}
+	return spec.CoreNode{}, false
+}
+
+func TestHandshake(t *testing.T) {
+	peer := listen(t,
+		fakepeer.Handshake(),
+		fakepeer.Expect("getheaders"),
+		fakepeer.SendPing(7),
+		fakepeer.Expect("getaddr"),
+		fakepeer.Disconnect())
+	db := store.NewMemoryStore(context.Background())
+	must(t, db.AddCoreNode(peer.Address(), testStart.Add(-time.Hour).Unix(), 0))
+	_, clk := startCollector(t, db, peer, 0)
+
+	conn := waitConn(t, peer, 0)
+	waitClosed(t, conn)
+	if err := conn.Err(); err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	expect := []string{"version", "verack", "sendheaders", "sendcmpct", "feefilter", "getheaders", "pong", "getaddr"}
+	if got := conn.Received(); strings.Join(got, " ") != strings.Join(expect, " ") {
+		t.Fatalf("received %v, expected %v", got, expect)
+	}
+
+	// the session ends in the Reconnect sleep, after storing the probe.
+	waitSleeping(t, clk)
+	node, found := findNode(t, db, peer.Address().String())
+	if !found {
+		t.Fatal("peer node missing from the store")
+	}
+	if !node.Reachable || node.Time != testStart.Unix() {
+		t.Errorf("expected a reachable node seen at %v, got %+v", testStart.Unix(), node)
+	}
+	if node.Probed != testStart.Unix() || node.Version != fakepeer.DefaultProtocolVersion || node.Agent != fakepeer.DefaultVersion().Agent {
+		t.Errorf("expected a probe at %v, got %+v", testStart.Unix(), node)
+	}
+}
+
+// A BIP 155 peer must not be asked for 'addrv2' (see the 'addr' case).
+func TestModernPeer(t *testing.T) {
+	version := fakepeer.DefaultVersion()
+	version.Version = WtxidRelayVersion
+	peer := listen(t,
+		fakepeer.Expect("version"),
+		fakepeer.SendVersion(version),
+		fakepeer.Send("sendaddrv2", nil),
+		fakepeer.Send("wtxidrelay", nil),
+		fakepeer.Send("verack", nil),
+		fakepeer.Expect("wtxidrelay"),
+		fakepeer.Expect("verack"),
+		fakepeer.Expect("getheaders"),
+		fakepeer.Disconnect())
+	db := store.NewMemoryStore(context.Background())
+	must(t, db.AddCoreNode(peer.Address(), testStart.Add(-time.Hour).Unix(), 0))
+	_, clk := startCollector(t, db, peer, 0)
+
+	conn := waitConn(t, peer, 0)
+	waitClosed(t, conn)
+	if err := conn.Err(); err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	for _, cmd := range conn.Received() {
+		if cmd == "sendaddrv2" {
+			t.Fatalf("sent 'sendaddrv2': %v", conn.Received())
+		}
+	}
+	waitSleeping(t, clk)
+	node, _ := findNode(t, db, peer.Address().String())
+	if node.Caps&spec.CapSendAddrV2 == 0 || node.Caps&spec.CapWtxidRelay == 0 {
+		t.Errorf("expected the peer's sendaddrv2 and wtxidrelay, got caps %v", node.Caps)
+	}
+}
+
+func TestAddrIngestion(t *testing.T) {
+	fresh := fakepeer.MakeAddrs(3, net.IPv4(10, 1, 0, 1), 22556, testStart.Add(-time.Hour))
+	expired := fakepeer.MakeAddrs(1, net.IPv4(10, 2, 0, 1), 22556, testStart.Add(-(spec.MaxCoreNodeDays+1)*24*time.Hour))
+	peer := listen(t,
+		fakepeer.Handshake(),
+		fakepeer.SendPing(1),
+		fakepeer.Expect("getaddr"),
+		fakepeer.SendAddr(append(fresh, expired...)),
+		fakepeer.Hang())
+	db := store.NewMemoryStore(context.Background())
+	startCollector(t, db, peer, 0)
+
+	waitConn(t, peer, 0)
+	waitFor(t, "addresses to be stored", func() bool {
+		nodes, err := db.NodeList()
+		return err == nil && len(nodes) == 3
+	})
+	for _, a := range fresh {
+		addr := spec.Address{Host: net.IP(a.Address), Port: a.Port}
+		node, found := findNode(t, db, addr.String())
+		if !found {
+			t.Fatalf("missing node %v", addr)
+		}
+		if node.Time != int64(a.Time) || node.Services != a.Services {
+			t.Errorf("node %v: expected time %v services %v, got %+v", addr, a.Time, a.Services, node)
+		}
+	}
+	if _, found := findNode(t, db, "10.2.0.1:22556"); found {
+		t.Error("expired address was stored")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	peer := listen(t,
+		fakepeer.Handshake(),
+		fakepeer.SendPing(1),
+		fakepeer.Expect("getaddr"),
+		fakepeer.SendAddr(fakepeer.MakeAddrs(1000, net.IPv4(10, 1, 0, 1), 22556, testStart)),
+		fakepeer.Hang())
+	db := store.NewMemoryStore(context.Background())
+	_, clk := startCollector(t, db, peer, 0)
+
+	// after 1000 addresses the collector disconnects and backs off
+	// for Backoff - 1000 new nodes * BackoffStep = 1000s.
+	waitClosed(t, waitConn(t, peer, 0))
+	waitSleeping(t, clk)
+	nodes, err := db.NodeList()
+	if err != nil || len(nodes) != 1000 {
+		t.Fatalf("expected 1000 nodes, got %d %v", len(nodes), err)
+	}
+	clk.Advance(999 * time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if clk.Sleepers() != 1 {
+		t.Fatal("backoff ended early")
+	}
+	clk.Advance(time.Second)
+	waitSleeping(t, clk) // now in the Reconnect sleep
+	clk.Advance(testSchedule.Reconnect)
+	waitConn(t, peer, 1)
+}
+
+func TestStopClosesConn(t *testing.T) {
+	peer := listen(t, fakepeer.Handshake(), fakepeer.Hang())
+	db := store.NewMemoryStore(context.Background())
+	c, clk := startCollector(t, db, peer, 0)
+
+	conn := waitConn(t, peer, 0)
+	waitFor(t, "handshake", func() bool {
+		received := conn.Received()
+		return len(received) > 0 && received[len(received)-1] == "verack"
+	})
+	c.Stop()
+	waitClosed(t, conn)
+	waitSleeping(t, clk)
+}
+
+func TestGarbage(t *testing.T) {
+	peer := listen(t,
+		fakepeer.Handshake(),
+		fakepeer.SendGarbage([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")),
+		fakepeer.Hang())
+	db := store.NewMemoryStore(context.Background())
+	_, clk := startCollector(t, db, peer, 0)
+
+	waitClosed(t, waitConn(t, peer, 0))
+	waitSleeping(t, clk)
+}
+
+func TestSlowRead(t *testing.T) {
+	payload := core.EncodePing(core.PingMsg{Nonce: 1})
+	peer := listen(t,
+		fakepeer.Handshake(),
+		fakepeer.SendSlowly("ping", payload, 50*time.Millisecond),
+		fakepeer.Hang())
+	db := store.NewMemoryStore(context.Background())
+	_, clk := startCollector(t, db, peer, 200*time.Millisecond)
+
+	// the read deadline (maxTime) ends the session before the ping arrives.
+	conn := waitConn(t, peer, 0)
+	waitClosed(t, conn)
+	waitSleeping(t, clk)
+	for _, cmd := range conn.Received() {
+		if cmd == "pong" {
+			t.Fatal("collector answered the slow ping")
+		}
+	}
+}
+
+func TestStopWhileIdle(t *testing.T) {
+	// a crawler with no nodes to choose from waits in Retry.
+	db := store.NewMemoryStore(context.Background())
+	clk := clock.NewFake(testStart)
+	c := New(db, spec.Address{}, 0, false).WithSchedule(testSchedule).WithClock(clk)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Context = ctx
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run()
+	}()
+	waitSleeping(t, clk)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(waitTimeout):
+		t.Fatal("collector did not stop")
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
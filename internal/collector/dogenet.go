@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/governor"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// How often NetCollector fetches the node list from `dogenet`
+const DefaultNetPeriod = 1 * time.Minute
+
+// NewNetCollector periodically fetches all nodes from the `dogenet`
+// service's /nodes API and stores them (see Store.AddNetNodes), so the
+// map keeps DogeNet nodes while dogenet is restarting. Each node is
+// stored with its map location: the location its owner published (see
+// IdentityCache), or else its address located by WithLocator.
+func NewNetCollector(store spec.Store, dogeNetAddr string, period time.Duration) *NetCollector {
+	return &NetCollector{
+		_store: store,
+		url:    fmt.Sprintf("http://%v/nodes", dogeNetAddr),
+		period: period,
+		clock:  clock.System,
+	}
+}
+
+type NetCollector struct {
+	governor.ServiceCtx
+	_store  spec.Store
+	store   spec.Store
+	url     string
+	period  time.Duration
+	clock   clock.Clock
+	locator spec.Locator
+}
+
+// WithClock replaces clock.System (see Collector.WithClock)
+func (c *NetCollector) WithClock(clk clock.Clock) *NetCollector {
+	c.clock = clk
+	return c
+}
+
+// WithLocator resolves the location of nodes without a published location.
+func (c *NetCollector) WithLocator(l spec.Locator) *NetCollector {
+	c.locator = l
+	return c
+}
+
+// goroutine
+func (c *NetCollector) Run() {
+	c.store = c._store.WithCtx(c.Context).WithClock(c.clock) // Service Context is first available here
+	for !c.Stopping() {
+		err := c.collect()
+		if err != nil {
+			log.Printf("[dogenet] %v", err)
+		}
+		c.clock.Sleep(c.Context, c.period)
+	}
+}
+
+func (c *NetCollector) collect() error {
+	nodes, err := c.fetchNodes()
+	if err != nil {
+		return err
+	}
+	// cached identity profiles (see IdentityCache)
+	profiles, err := c.store.IdentityProfiles()
+	if err != nil {
+		return fmt.Errorf("IdentityProfiles: %w", err)
+	}
+	published := make(map[string]spec.Location, len(profiles))
+	for _, p := range profiles {
+		if loc, ok := p.Location(); ok {
+			published[p.Identity] = loc
+		}
+	}
+	valid := make([]spec.NetNode, 0, len(nodes))
+	for _, node := range nodes {
+		if _, err := node.NodeID(); err != nil {
+			log.Printf("[dogenet] invalid node pubkey: %v", node.PubKey)
+			continue
+		}
+		node.SetLocation(spec.Location{}) // not from dogenet
+		if loc, found := published[node.Identity]; found {
+			node.SetLocation(loc)
+		} else if c.locator != nil {
+			if addr, err := dnet.ParseAddress(node.Address); err == nil {
+				node.SetLocation(c.locator.Locate(addr.Host))
+			}
+		}
+		valid = append(valid, node)
+	}
+	added, updated, err := c.store.AddNetNodes(valid)
+	if err != nil {
+		return fmt.Errorf("AddNetNodes: %w", err)
+	}
+	log.Printf("[dogenet] %d received, %d new, %d updated", len(nodes), added, updated)
+	return nil
+}
+
+func (c *NetCollector) fetchNodes() ([]spec.NetNode, error) {
+	req, err := http.NewRequestWithContext(c.Context, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %v: %w", c.url, err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %v: %w", c.url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch: %v: status %v", c.url, res.StatusCode)
+	}
+	var nodes []spec.NetNode
+	err = json.NewDecoder(res.Body).Decode(&nodes)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %v: json decode: %w", c.url, err)
+	}
+	return nodes, nil
+}
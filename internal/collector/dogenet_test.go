@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/store"
+)
+
+// testLocator places 10.1.0.0/16 in Sydney and misses everything else.
+type testLocator struct{}
+
+func (testLocator) Source() string { return "test" }
+
+func (testLocator) Locate(ip net.IP) spec.Location {
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 10 && ip4[1] == 1 {
+		return spec.Location{Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", Source: "test", Found: true}
+	}
+	return spec.Location{Source: "test"}
+}
+
+func TestNetCollectorLocation(t *testing.T) {
+	published := strings.Repeat("cd", 32)
+	nodes := []spec.NetNode{
+		{PubKey: strings.Repeat("a1", 32), Address: "10.1.0.1:42069", Identity: published}, // owner's location wins
+		{PubKey: strings.Repeat("a2", 32), Address: "10.1.0.2:42069"},                      // located by address
+		{PubKey: strings.Repeat("a3", 32), Address: "10.2.0.1:42069"},                      // lookup missed
+		{PubKey: strings.Repeat("a4", 32), Address: "10.1.0.4:42069", Lat: 1, Lon: 2, LocSource: "dogenet", Located: true},
+		{PubKey: "abcd", Address: "10.1.0.5:42069"}, // invalid pubkey
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		must(t, json.NewEncoder(w).Encode(nodes))
+	}))
+	defer srv.Close()
+
+	db := store.NewMemoryStore(context.Background())
+	_, err := db.UpdateIdentities([]spec.IdentityProfile{
+		{Identity: published, Lat: "52.52", Lon: "13.405", Country: "DE", City: "Berlin"},
+	})
+	must(t, err)
+	c := NewNetCollector(db, strings.TrimPrefix(srv.URL, "http://"), DefaultNetPeriod).WithLocator(testLocator{})
+	c.Context = context.Background()
+	c.store = db
+	must(t, c.collect())
+
+	list, err := db.NetNodeList()
+	must(t, err)
+	got := make(map[string]spec.NetNode, len(list))
+	for _, node := range list {
+		got[node.Address] = node
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 valid nodes, got %+v", list)
+	}
+	expect := map[string]spec.NetNode{
+		"10.1.0.1:42069": {Lat: 52.52, Lon: 13.405, Country: "DE", City: "Berlin", LocSource: spec.IdentityLocSource, Located: true},
+		"10.1.0.2:42069": {Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", LocSource: "test", Located: true},
+		"10.2.0.1:42069": {LocSource: "test"},
+		"10.1.0.4:42069": {Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", LocSource: "test", Located: true},
+	}
+	for addr, loc := range expect {
+		node := got[addr]
+		if node.Lat != loc.Lat || node.Lon != loc.Lon || node.Country != loc.Country || node.City != loc.City ||
+			node.LocSource != loc.LocSource || node.Located != loc.Located {
+			t.Errorf("%s: expected location %+v, got %+v", addr, loc, node)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"code.dogecoin.org/governor"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// How often IdentityCache looks for new and stale identities
+const DefaultIdentityPeriod = 1 * time.Minute
+
+// Cached profiles are fetched again after DefaultIdentityRefresh,
+// and removed if they cannot be fetched for DefaultIdentityTTL.
+const DefaultIdentityRefresh = 1 * time.Hour
+const DefaultIdentityTTL = 7 * 24 * time.Hour
+
+// NewIdentityCache keeps the identity profiles of DogeNet nodes in the
+// store (see Store.UpdateIdentities), fetching new identities and those
+// older than `refresh` from the `identity` service's /locations API.
+// Cached profiles are served while the identity service is down, until
+// they have not been fetched for `ttl`.
+func NewIdentityCache(store spec.Store, identityAddr string, refresh time.Duration, ttl time.Duration) *IdentityCache {
+	return &IdentityCache{
+		_store:  store,
+		url:     fmt.Sprintf("http://%v/locations", identityAddr),
+		refresh: refresh,
+		ttl:     ttl,
+		missed:  make(map[string]int64),
+		clock:   clock.System,
+	}
+}
+
+type IdentityCache struct {
+	governor.ServiceCtx
+	_store  spec.Store
+	store   spec.Store
+	url     string
+	refresh time.Duration
+	ttl     time.Duration
+	missed  map[string]int64 // identities without a profile: when last requested
+	clock   clock.Clock
+}
+
+// WithClock replaces clock.System (see Collector.WithClock)
+func (c *IdentityCache) WithClock(clk clock.Clock) *IdentityCache {
+	c.clock = clk
+	return c
+}
+
+// GetChit requests the profile of `Identity`, as announced by `Node`
+type GetChit struct {
+	Identity string `json:"identity"` // identity (node owner) pubkey hex
+	Node     string `json:"node"`     // node pubkey hex
+}
+
+// goroutine
+func (c *IdentityCache) Run() {
+	c.store = c._store.WithCtx(c.Context).WithClock(c.clock) // Service Context is first available here
+	for !c.Stopping() {
+		err := c.update()
+		if err != nil {
+			log.Printf("[identity] %v", err)
+		}
+		removed, err := c.store.TrimIdentities(c.clock.Now().Add(-c.ttl).Unix())
+		if err != nil {
+			log.Printf("[identity] TrimIdentities: %v", err)
+		} else if removed > 0 {
+			log.Printf("[identity] expired %d profiles", removed)
+		}
+		c.clock.Sleep(c.Context, DefaultIdentityPeriod)
+	}
+}
+
+func (c *IdentityCache) update() error {
+	nodes, err := c.store.NetNodeList()
+	if err != nil {
+		return fmt.Errorf("NetNodeList: %w", err)
+	}
+	cached, err := c.store.IdentityProfiles()
+	if err != nil {
+		return fmt.Errorf("IdentityProfiles: %w", err)
+	}
+	fetched := make(map[string]int64, len(cached))
+	for _, p := range cached {
+		fetched[p.Identity] = p.Fetched
+	}
+	// identities not cached, or due for refresh
+	stale := c.clock.Now().Add(-c.refresh).Unix()
+	for identity, when := range c.missed {
+		if when < stale {
+			delete(c.missed, identity) // ask again
+		} else if _, found := fetched[identity]; !found {
+			fetched[identity] = when
+		}
+	}
+	var getChits []GetChit
+	for _, node := range nodes {
+		if node.Identity == "" {
+			continue
+		}
+		if when, found := fetched[node.Identity]; !found || when < stale {
+			getChits = append(getChits, GetChit{Identity: node.Identity, Node: node.PubKey})
+			fetched[node.Identity] = stale + 1 // once per identity
+		}
+	}
+	if len(getChits) == 0 {
+		return nil
+	}
+	profiles, err := c.fetchProfiles(getChits)
+	if err != nil {
+		return err
+	}
+	now := c.clock.Now().Unix()
+	for _, chit := range getChits {
+		c.missed[chit.Identity] = now
+	}
+	for _, p := range profiles {
+		delete(c.missed, p.Identity)
+	}
+	changed, err := c.store.UpdateIdentities(profiles)
+	if err != nil {
+		return fmt.Errorf("UpdateIdentities: %w", err)
+	}
+	log.Printf("[identity] %d requested, %d received, %d new or changed", len(getChits), len(profiles), changed)
+	return nil
+}
+
+// fetchProfiles fetches profiles from the identity service; identities
+// it does not know are omitted from the result.
+func (c *IdentityCache) fetchProfiles(getChits []GetChit) ([]spec.IdentityProfile, error) {
+	payload, err := json.Marshal(getChits)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %v: json encode: %w", c.url, err)
+	}
+	req, err := http.NewRequestWithContext(c.Context, http.MethodPost, c.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %v: %w", c.url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %v: %w", c.url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch: %v: status %v", c.url, res.StatusCode)
+	}
+	var found map[string]json.RawMessage
+	err = json.NewDecoder(res.Body).Decode(&found)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %v: json decode: %w", c.url, err)
+	}
+	profiles := make([]spec.IdentityProfile, 0, len(found))
+	for identity, raw := range found {
+		// keep the whole profile; the map only needs its location.
+		var loc struct {
+			Lat     string `json:"lat"`
+			Lon     string `json:"lon"`
+			Country string `json:"country"`
+			City    string `json:"city"`
+		}
+		if err := json.Unmarshal(raw, &loc); err != nil {
+			log.Printf("[identity] invalid profile for %v: %v", identity, err)
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			log.Printf("[identity] invalid profile for %v: %v", identity, err)
+			continue
+		}
+		profiles = append(profiles, spec.IdentityProfile{
+			Identity: identity,
+			Lat:      loc.Lat,
+			Lon:      loc.Lon,
+			Country:  loc.Country,
+			City:     loc.City,
+			Profile:  compact.Bytes(),
+		})
+	}
+	return profiles, nil
+}
@@ -0,0 +1,20 @@
+package msg
+
+import "code.dogecoin.org/gossip/codec"
+
+// https://github.com/bitcoin/bips/blob/master/bip-0133.mediawiki
+type FeeFilterMsg struct {
+	FeeRate int64 // minimum fee rate (koinu per kB) for tx inv announcements
+}
+
+func DecodeFeeFilter(payload []byte) (msg FeeFilterMsg) {
+	d := codec.Decode(payload)
+	msg.FeeRate = d.Int64le()
+	return
+}
+
+func EncodeFeeFilter(msg FeeFilterMsg) []byte {
+	e := codec.Encode(8)
+	e.Int64le(msg.FeeRate)
+	return e.Result()
+}
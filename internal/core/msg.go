@@ -41,22 +41,50 @@ func DecodeHeader(buf [24]byte) (hdr MessageHeader) {
 }
 
 func ReadMessage(reader *bufio.Reader) (cmd string, payload []byte, err error) {
+	raw, err := ReadFrame(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	return DecodeFrame(raw)
+}
+
+// ReadFrame reads one raw message (24-byte header and payload) without
+// checking its magic or checksum (see DecodeFrame). On error, `raw`
+// holds whatever was received, e.g. for capture.
+func ReadFrame(reader *bufio.Reader) (raw []byte, err error) {
 	// Read the message header
 	buf := [24]byte{}
 	n, err := io.ReadFull(reader, buf[:])
 	if err != nil {
-		return "", nil, fmt.Errorf("short header: received %d bytes: %v", n, err)
+		return buf[:n], fmt.Errorf("short header: received %d bytes: %v", n, err)
 	}
-	// Decode the header
 	hdr := DecodeHeader(buf)
-	if hdr.Magic != MagicBytes {
-		return "", nil, fmt.Errorf("so sad, invalid magic bytes: %08x", hdr.Magic)
+	if hdr.Length > MaxMsgSize {
+		return buf[:], fmt.Errorf("so sad, message too large: %d bytes", hdr.Length)
 	}
 	// Read the message payload
-	payload = make([]byte, hdr.Length)
-	n, err = io.ReadFull(reader, payload)
+	raw = make([]byte, 24+hdr.Length)
+	copy(raw, buf[:])
+	n, err = io.ReadFull(reader, raw[24:])
 	if err != nil {
-		return "", nil, fmt.Errorf("short payload: received %d bytes: %v", n, err)
+		return raw[:24+n], fmt.Errorf("short payload: received %d bytes: %v", n, err)
+	}
+	return raw, nil
+}
+
+// DecodeFrame checks the magic and checksum of a raw message and
+// returns its command and payload.
+func DecodeFrame(raw []byte) (cmd string, payload []byte, err error) {
+	if len(raw) < 24 {
+		return "", nil, fmt.Errorf("short header: %d bytes", len(raw))
+	}
+	hdr := DecodeHeader([24]byte(raw[:24]))
+	if hdr.Magic != MagicBytes {
+		return "", nil, fmt.Errorf("so sad, invalid magic bytes: %08x", hdr.Magic)
+	}
+	payload = raw[24:]
+	if uint32(len(payload)) != hdr.Length {
+		return "", nil, fmt.Errorf("short payload: received %d of %d bytes", len(payload), hdr.Length)
 	}
 	// Verify checksum
 	hash := DoubleSHA256(payload)
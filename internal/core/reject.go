@@ -53,3 +53,12 @@ func DecodeReject(msg []byte) (rej RejectMsg) {
 	rej.Data = d.Rest()
 	return
 }
+
+func EncodeReject(rej RejectMsg) []byte {
+	e := codec.Encode(3 + len(rej.Message) + len(rej.Reason) + len(rej.Data))
+	e.VarString(rej.Message)
+	e.UInt8(uint8(rej.Code))
+	e.VarString(rej.Reason)
+	e.Bytes(rej.Data)
+	return e.Result()
+}
@@ -0,0 +1,23 @@
+package msg
+
+import "code.dogecoin.org/gossip/codec"
+
+// https://github.com/bitcoin/bips/blob/master/bip-0152.mediawiki
+type SendCmpctMsg struct {
+	Announce bool   // use high-bandwidth mode (announce blocks with 'cmpctblock')
+	Version  uint64 // compact block protocol version (1)
+}
+
+func DecodeSendCmpct(payload []byte) (msg SendCmpctMsg) {
+	d := codec.Decode(payload)
+	msg.Announce = d.Bool()
+	msg.Version = d.UInt64le()
+	return
+}
+
+func EncodeSendCmpct(msg SendCmpctMsg) []byte {
+	e := codec.Encode(9)
+	e.Bool(msg.Announce)
+	e.UInt64le(msg.Version)
+	return e.Result()
+}
@@ -0,0 +1,333 @@
+// Package export reads and writes node lists as JSON, NDJSON or CSV
+// (see `dogemap export` and `dogemap import`)
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+const (
+	FormatJSON   = "json"   // one spec.NodeListRes object
+	FormatNDJSON = "ndjson" // one node per line; dogenet nodes have a "pubkey"
+	FormatCSV    = "csv"    // one node type per file, with a header row
+)
+
+const (
+	TypeAll  = ""
+	TypeCore = "core"
+	TypeNet  = "net"
+)
+
+// CSV columns, named as in the JSON formats.
+var coreColumns = []string{"address", "time", "services", "caps", "skew", "rtt", "probed", "version", "agent", "reachable", "lat", "lon", "country", "city", "locsrc", "located"}
+var netColumns = []string{"pubkey", "address", "time", "channels", "identity", "seen", "lat", "lon", "country", "city", "locsrc", "located"}
+
+// CheckFormat validates a format and node type combination.
+func CheckFormat(format string, typ string) error {
+	switch typ {
+	case TypeAll, TypeCore, TypeNet:
+	default:
+		return fmt.Errorf("unknown type: %v (expecting core or net)", typ)
+	}
+	switch format {
+	case FormatJSON, FormatNDJSON:
+		return nil
+	case FormatCSV:
+		if typ == TypeAll {
+			return errors.New("csv: choose a type (core or net)")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format: %v (expecting json, ndjson or csv)", format)
+	}
+}
+
+// Write writes the nodes of type `typ` (TypeAll: both) in `format`.
+func Write(w io.Writer, format string, typ string, nodes spec.NodeListRes) error {
+	if err := CheckFormat(format, typ); err != nil {
+		return err
+	}
+	if typ == TypeNet || nodes.Core == nil {
+		nodes.Core = []spec.CoreNode{}
+	}
+	if typ == TypeCore || nodes.Net == nil {
+		nodes.Net = []spec.NetNode{}
+	}
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(nodes)
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, n := range nodes.Core {
+			if err := enc.Encode(n); err != nil {
+				return err
+			}
+		}
+		for _, n := range nodes.Net {
+			if err := enc.Encode(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		cw := csv.NewWriter(w)
+		if typ == TypeCore {
+			cw.Write(coreColumns)
+			for _, n := range nodes.Core {
+				cw.Write(coreRecord(n))
+			}
+		} else {
+			cw.Write(netColumns)
+			for _, n := range nodes.Net {
+				cw.Write(netRecord(n))
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+}
+
+// Read reads nodes in `format`, keeping those of type `typ` (TypeAll: both)
+func Read(r io.Reader, format string, typ string) (res spec.NodeListRes, err error) {
+	if err = CheckFormat(format, typ); err != nil {
+		return
+	}
+	switch format {
+	case FormatJSON:
+		err = json.NewDecoder(r).Decode(&res)
+		if err != nil {
+			return res, fmt.Errorf("json: %w", err)
+		}
+	case FormatNDJSON:
+		res, err = readNDJSON(r)
+	default:
+		if typ == TypeCore {
+			res.Core, err = readCoreCSV(r)
+		} else {
+			res.Net, err = readNetCSV(r)
+		}
+	}
+	if typ == TypeNet {
+		res.Core = nil
+	}
+	if typ == TypeCore {
+		res.Net = nil
+	}
+	return
+}
+
+func readNDJSON(r io.Reader) (res spec.NodeListRes, err error) {
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1024*1024)
+	line := 0
+	for scan.Scan() {
+		line++
+		text := scan.Bytes()
+		if len(strings.TrimSpace(string(text))) == 0 {
+			continue
+		}
+		var kind struct {
+			PubKey string `json:"pubkey"`
+		}
+		if err = json.Unmarshal(text, &kind); err != nil {
+			return res, fmt.Errorf("ndjson: line %d: %w", line, err)
+		}
+		if kind.PubKey != "" {
+			var n spec.NetNode
+			if err = json.Unmarshal(text, &n); err != nil {
+				return res, fmt.Errorf("ndjson: line %d: %w", line, err)
+			}
+			res.Net = append(res.Net, n)
+		} else {
+			var n spec.CoreNode
+			if err = json.Unmarshal(text, &n); err != nil {
+				return res, fmt.Errorf("ndjson: line %d: %w", line, err)
+			}
+			res.Core = append(res.Core, n)
+		}
+	}
+	if err = scan.Err(); err != nil {
+		return res, fmt.Errorf("ndjson: %w", err)
+	}
+	return res, nil
+}
+
+func coreRecord(n spec.CoreNode) []string {
+	return []string{
+		n.Address,
+		strconv.FormatInt(n.Time, 10),
+		strconv.FormatUint(n.Services, 10),
+		strconv.FormatUint(uint64(n.Caps), 10),
+		strconv.FormatInt(n.Skew, 10),
+		strconv.FormatInt(n.RTT, 10),
+		strconv.FormatInt(n.Probed, 10),
+		strconv.FormatInt(int64(n.Version), 10),
+		n.Agent,
+		strconv.FormatBool(n.Reachable),
+		strconv.FormatFloat(n.Lat, 'f', -1, 64),
+		strconv.FormatFloat(n.Lon, 'f', -1, 64),
+		n.Country,
+		n.City,
+		n.LocSource,
+		strconv.FormatBool(n.Located),
+	}
+}
+
+func netRecord(n spec.NetNode) []string {
+	return []string{
+		n.PubKey,
+		n.Address,
+		strconv.FormatInt(n.Time, 10),
+		strings.Join(n.Channels, ","),
+		n.Identity,
+		strconv.FormatInt(n.Seen, 10),
+		strconv.FormatFloat(n.Lat, 'f', -1, 64),
+		strconv.FormatFloat(n.Lon, 'f', -1, 64),
+		n.Country,
+		n.City,
+		n.LocSource,
+		strconv.FormatBool(n.Located),
+	}
+}
+
+// csvReader reads CSV records by column name; columns may be in any
+// order, and missing columns are zero.
+type csvReader struct {
+	r      *csv.Reader
+	cols   map[string]int
+	rec    []string
+	line   int
+	failed error
+}
+
+func newCSVReader(r io.Reader, key string) (*csvReader, error) {
+	cr := &csvReader{r: csv.NewReader(r), cols: make(map[string]int)}
+	header, err := cr.r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv: header: %w", err)
+	}
+	for i, name := range header {
+		cr.cols[strings.TrimSpace(name)] = i
+	}
+	if _, found := cr.cols[key]; !found {
+		return nil, fmt.Errorf("csv: missing column: %v", key)
+	}
+	cr.r.FieldsPerRecord = len(header)
+	return cr, nil
+}
+
+// next reads the next record; it returns false at the end or on error.
+func (cr *csvReader) next() bool {
+	rec, err := cr.r.Read()
+	if err != nil {
+		if err != io.EOF {
+			cr.failed = fmt.Errorf("csv: %w", err)
+		}
+		return false
+	}
+	cr.rec = rec
+	cr.line++
+	return cr.failed == nil
+}
+
+func (cr *csvReader) str(name string) string {
+	if i, found := cr.cols[name]; found {
+		return cr.rec[i]
+	}
+	return ""
+}
+
+func (cr *csvReader) parse(name string, parse func(s string) error) {
+	if s := cr.str(name); s != "" && cr.failed == nil {
+		if err := parse(s); err != nil {
+			cr.failed = fmt.Errorf("csv: record %d: %v: %w", cr.line, name, err)
+		}
+	}
+}
+
+func (cr *csvReader) int(name string, bits int) (v int64) {
+	cr.parse(name, func(s string) (err error) { v, err = strconv.ParseInt(s, 10, bits); return })
+	return
+}
+
+func (cr *csvReader) uint(name string, bits int) (v uint64) {
+	cr.parse(name, func(s string) (err error) { v, err = strconv.ParseUint(s, 10, bits); return })
+	return
+}
+
+func (cr *csvReader) float(name string) (v float64) {
+	cr.parse(name, func(s string) (err error) { v, err = strconv.ParseFloat(s, 64); return })
+	return
+}
+
+func (cr *csvReader) bool(name string) (v bool) {
+	cr.parse(name, func(s string) (err error) { v, err = strconv.ParseBool(s); return })
+	return
+}
+
+func readCoreCSV(r io.Reader) (res []spec.CoreNode, err error) {
+	cr, err := newCSVReader(r, "address")
+	if err != nil {
+		return nil, err
+	}
+	for cr.next() {
+		res = append(res, spec.CoreNode{
+			Address:   cr.str("address"),
+			Time:      cr.int("time", 64),
+			Services:  cr.uint("services", 64),
+			Caps:      uint32(cr.uint("caps", 32)),
+			Skew:      cr.int("skew", 64),
+			RTT:       cr.int("rtt", 64),
+			Probed:    cr.int("probed", 64),
+			Version:   int32(cr.int("version", 32)),
+			Agent:     cr.str("agent"),
+			Reachable: cr.bool("reachable"),
+			Lat:       cr.float("lat"),
+			Lon:       cr.float("lon"),
+			Country:   cr.str("country"),
+			City:      cr.str("city"),
+			LocSource: cr.str("locsrc"),
+			Located:   cr.bool("located"),
+		})
+	}
+	return res, cr.failed
+}
+
+func readNetCSV(r io.Reader) (res []spec.NetNode, err error) {
+	cr, err := newCSVReader(r, "pubkey")
+	if err != nil {
+		return nil, err
+	}
+	for cr.next() {
+		channels := []string{}
+		if s := cr.str("channels"); s != "" {
+			channels = strings.Split(s, ",")
+		}
+		res = append(res, spec.NetNode{
+			PubKey:    cr.str("pubkey"),
+			Address:   cr.str("address"),
+			Time:      cr.int("time", 64),
+			Channels:  channels,
+			Identity:  cr.str("identity"),
+			Seen:      cr.int("seen", 64),
+			Lat:       cr.float("lat"),
+			Lon:       cr.float("lon"),
+			Country:   cr.str("country"),
+			City:      cr.str("city"),
+			LocSource: cr.str("locsrc"),
+			Located:   cr.bool("located"),
+		})
+	}
+	return res, cr.failed
+}
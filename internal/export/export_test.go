@@ -0,0 +1,138 @@
+package export
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+var testNodes = spec.NodeListRes{
+	Core: []spec.CoreNode{
+		{Address: "1.2.3.4:22556", Time: 1700000000, Services: 1<<10 | 5, Caps: 3, Skew: -7, RTT: 120, Probed: 1700000100,
+			Version: 70015, Agent: "/Shibetoshi:1.14.9/", Reachable: true,
+			Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney, NSW", LocSource: "geoip:1", Located: true},
+		{Address: "[2001:db8::1]:22556", Time: 1700000001, Services: 1, LocSource: "geoip:1"},
+	},
+	Net: []spec.NetNode{
+		{PubKey: strings.Repeat("ab", 32), Address: "1.2.3.4:42069", Time: 1700000002, Channels: []string{"Core", "Iden"},
+			Identity: strings.Repeat("cd", 32), Seen: 1700000003,
+			Lat: 52.52, Lon: 13.405, Country: "DE", City: "Berlin", LocSource: spec.IdentityLocSource, Located: true},
+		{PubKey: strings.Repeat("ef", 32), Address: "5.6.7.8:42069", Time: 1700000004, Channels: []string{}},
+	},
+}
+
+func roundTrip(t *testing.T, format string, typ string, nodes spec.NodeListRes) spec.NodeListRes {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, format, typ, nodes); err != nil {
+		t.Fatalf("Write %v: %v", format, err)
+	}
+	res, err := Read(&buf, format, typ)
+	if err != nil {
+		t.Fatalf("Read %v: %v", format, err)
+	}
+	return res
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		res := roundTrip(t, format, TypeAll, testNodes)
+		if !reflect.DeepEqual(res, testNodes) {
+			t.Errorf("%v: expected %+v, got %+v", format, testNodes, res)
+		}
+		res = roundTrip(t, format, TypeCore, testNodes)
+		if !reflect.DeepEqual(res.Core, testNodes.Core) || res.Net != nil {
+			t.Errorf("%v core: expected %+v, got %+v", format, testNodes.Core, res)
+		}
+		res = roundTrip(t, format, TypeNet, testNodes)
+		if !reflect.DeepEqual(res.Net, testNodes.Net) || res.Core != nil {
+			t.Errorf("%v net: expected %+v, got %+v", format, testNodes.Net, res)
+		}
+	}
+	res := roundTrip(t, FormatCSV, TypeCore, testNodes)
+	if !reflect.DeepEqual(res.Core, testNodes.Core) || res.Net != nil {
+		t.Errorf("csv core: expected %+v, got %+v", testNodes.Core, res)
+	}
+	res = roundTrip(t, FormatCSV, TypeNet, testNodes)
+	if !reflect.DeepEqual(res.Net, testNodes.Net) || res.Core != nil {
+		t.Errorf("csv net: expected %+v, got %+v", testNodes.Net, res)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	// JSON always has both arrays, so the output is never `null`.
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJSON, TypeAll, spec.NodeListRes{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strings.Fields(buf.String()), ""); got != `{"core":[],"net":[]}` {
+		t.Fatalf("expected empty arrays, got %s", got)
+	}
+	res, err := Read(strings.NewReader("\n\n"), FormatNDJSON, TypeAll)
+	if err != nil || len(res.Core) != 0 || len(res.Net) != 0 {
+		t.Fatalf("ndjson: expected no nodes, got %+v %v", res, err)
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	// columns in any order; missing columns are zero.
+	in := "city,address,reachable,lat,lon\n" +
+		"Berlin,1.2.3.4:22556,true,52.52,13.405\n" +
+		",5.6.7.8:22556,,,\n"
+	res, err := Read(strings.NewReader(in), FormatCSV, TypeCore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []spec.CoreNode{
+		{Address: "1.2.3.4:22556", Reachable: true, Lat: 52.52, Lon: 13.405, City: "Berlin"},
+		{Address: "5.6.7.8:22556"},
+	}
+	if !reflect.DeepEqual(res.Core, expect) {
+		t.Fatalf("expected %+v, got %+v", expect, res.Core)
+	}
+
+	in = " seen , pubkey,channels\n" +
+		"99," + strings.Repeat("ab", 32) + ",\"Core,Iden\"\n"
+	res, err = Read(strings.NewReader(in), FormatCSV, TypeNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNet := []spec.NetNode{{PubKey: strings.Repeat("ab", 32), Channels: []string{"Core", "Iden"}, Seen: 99}}
+	if !reflect.DeepEqual(res.Net, expectNet) {
+		t.Fatalf("expected %+v, got %+v", expectNet, res.Net)
+	}
+}
+
+func TestBadRecords(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		typ    string
+		in     string
+		expect string // error text
+	}{
+		{"csv no header", FormatCSV, TypeCore, "", "csv: header"},
+		{"csv no key", FormatCSV, TypeCore, "time,services\n1,2\n", "missing column: address"},
+		{"csv no pubkey", FormatCSV, TypeNet, "address\n1.2.3.4:42069\n", "missing column: pubkey"},
+		{"csv bad int", FormatCSV, TypeCore, "address,time\n1.2.3.4:22556,1\n5.6.7.8:22556,soon\n", "record 2: time"},
+		{"csv bad bool", FormatCSV, TypeCore, "address,reachable\n1.2.3.4:22556,maybe\n", "record 1: reachable"},
+		{"csv bad float", FormatCSV, TypeCore, "address,lat\n1.2.3.4:22556,north\n", "record 1: lat"},
+		{"csv overflow", FormatCSV, TypeCore, "address,caps\n1.2.3.4:22556,4294967296\n", "record 1: caps"},
+		{"csv field count", FormatCSV, TypeCore, "address,time\n1.2.3.4:22556\n", "wrong number of fields"},
+		{"ndjson syntax", FormatNDJSON, TypeAll, `{"address":"1.2.3.4:22556"}` + "\n{\n", "ndjson: line 2"},
+		{"ndjson type", FormatNDJSON, TypeAll, `{"address":"1.2.3.4:22556","time":"soon"}`, "ndjson: line 1"},
+		{"json syntax", FormatJSON, TypeAll, `{"core":[`, "json:"},
+		{"bad format", "xml", TypeAll, "", "unknown format"},
+		{"bad type", FormatJSON, "dogebox", "", "unknown type"},
+		{"csv all", FormatCSV, TypeAll, "", "choose a type"},
+	}
+	for _, tc := range tests {
+		_, err := Read(strings.NewReader(tc.in), tc.format, tc.typ)
+		if err == nil || !strings.Contains(err.Error(), tc.expect) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.expect, err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package fakepeer
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/capture"
+	core "code.dogecoin.org/dogemap-backend/internal/core"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// Peer is an in-process fake Dogecoin Core node listening on loopback.
+// Each inbound connection runs the Peer's script, then keeps reading
+// (recording commands) until the client disconnects.
+type Peer struct {
+	ln     net.Listener
+	script []Step
+	mutex  sync.Mutex // protects the following:
+	conns  []*Conn
+	wg     sync.WaitGroup
+	closed chan struct{}
+}
+
+// Step is one scripted action performed on a connection.
+type Step func(c *Conn) error
+
+// Conn is the fake peer's side of one connection.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	mutex    sync.Mutex // protects the following:
+	received []string   // commands received, in order
+	err      error      // script error, if any
+	done     chan struct{}
+}
+
+// Listen starts a fake peer on 127.0.0.1 with an OS-assigned port.
+func Listen(script ...Step) (*Peer, error) {
+	return ListenOn("127.0.0.1:0", script...)
+}
+
+// ListenOn starts a fake peer on a specific <ip>:<port>.
+func ListenOn(bind string, script ...Step) (*Peer, error) {
+	ln, err := net.Listen("tcp", bind)
+	if err != nil {
+		return nil, fmt.Errorf("fakepeer: %v", err)
+	}
+	p := &Peer{ln: ln, script: script, closed: make(chan struct{})}
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+// Address is the address the peer is listening on.
+func (p *Peer) Address() spec.Address {
+	tcp := p.ln.Addr().(*net.TCPAddr)
+	return spec.Address{Host: tcp.IP.To16(), Port: uint16(tcp.Port)}
+}
+
+// Close stops listening and closes all connections.
+func (p *Peer) Close() {
+	select {
+	case <-p.closed:
+		return
+	default:
+		close(p.closed)
+	}
+	p.ln.Close()
+	p.mutex.Lock()
+	for _, c := range p.conns {
+		c.conn.Close()
+	}
+	p.mutex.Unlock()
+	p.wg.Wait()
+}
+
+// Conns returns all connections accepted so far.
+func (p *Peer) Conns() []*Conn {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]*Conn(nil), p.conns...)
+}
+
+// WaitConn waits for the n-th connection (0-based) to be accepted.
+func (p *Peer) WaitConn(n int, timeout time.Duration) (*Conn, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		conns := p.Conns()
+		if len(conns) > n {
+			return conns[n], nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("fakepeer: timeout waiting for connection %d", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (p *Peer) accept() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		c := &Conn{conn: conn, reader: bufio.NewReader(conn), done: make(chan struct{})}
+		p.mutex.Lock()
+		p.conns = append(p.conns, c)
+		p.mutex.Unlock()
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			c.run(p.script)
+		}()
+	}
+}
+
+func (c *Conn) run(script []Step) {
+	defer close(c.done)
+	defer c.conn.Close()
+	for _, step := range script {
+		if err := step(c); err != nil {
+			c.mutex.Lock()
+			c.err = err
+			c.mutex.Unlock()
+			return
+		}
+	}
+	// drain until the client disconnects.
+	for {
+		if _, _, err := c.Read(); err != nil {
+			return
+		}
+	}
+}
+
+// Received returns the commands received from the client so far.
+func (c *Conn) Received() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.received...)
+}
+
+// Err returns the error that stopped the script, if any.
+func (c *Conn) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// WaitClosed waits for the client to close the connection
+// (or for the script to fail) and reports whether it did.
+func (c *Conn) WaitClosed(timeout time.Duration) bool {
+	select {
+	case <-c.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Read reads the next message from the client and records its command.
+func (c *Conn) Read() (cmd string, payload []byte, err error) {
+	cmd, payload, err = core.ReadMessage(c.reader)
+	if err == nil {
+		c.mutex.Lock()
+		c.received = append(c.received, cmd)
+		c.mutex.Unlock()
+	}
+	return
+}
+
+// Send writes an encoded message to the client.
+func (c *Conn) Send(cmd string, payload []byte) error {
+	_, err := c.conn.Write(core.EncodeMessage(cmd, payload))
+	return err
+}
+
+// STEPS
+
+// Expect reads messages until `cmd` is received.
+func Expect(cmd string) Step {
+	return func(c *Conn) error {
+		for {
+			got, _, err := c.Read()
+			if err != nil {
+				return fmt.Errorf("expecting '%s': %v", cmd, err)
+			}
+			if got == cmd {
+				return nil
+			}
+		}
+	}
+}
+
+// Send sends an arbitrary message.
+func Send(cmd string, payload []byte) Step {
+	return func(c *Conn) error {
+		return c.Send(cmd, payload)
+	}
+}
+
+// SendVersion sends a 'version' message.
+func SendVersion(v core.VersionMsg) Step {
+	return Send("version", core.EncodeVersion(v))
+}
+
+// Handshake performs the usual inbound handshake: wait for the
+// client's 'version', reply with DefaultVersion(), wait for 'verack'.
+func Handshake() Step {
+	return Steps(Expect("version"), SendVersion(DefaultVersion()), Send("verack", nil), Expect("verack"))
+}
+
+// SendPing sends a 'ping' with the given nonce.
+func SendPing(nonce uint64) Step {
+	return Send("ping", core.EncodePing(core.PingMsg{Nonce: nonce}))
+}
+
+// SendAddr sends an 'addr' message (in the DefaultVersion format).
+func SendAddr(addrs []core.NetAddr) Step {
+	return Send("addr", core.EncodeAddrMsg(core.AddrMsg{AddrList: addrs}, DefaultProtocolVersion))
+}
+
+// SendReject sends a 'reject' message.
+func SendReject(message string, code core.RejectCode, reason string) Step {
+	return Send("reject", core.EncodeReject(core.RejectMsg{Message: message, Code: code, Reason: reason}))
+}
+
+// SendGarbage writes bytes that are not a valid message.
+func SendGarbage(data []byte) Step {
+	return func(c *Conn) error {
+		_, err := c.conn.Write(data)
+		return err
+	}
+}
+
+// SendSlowly sends a message one byte at a time, pausing between bytes.
+func SendSlowly(cmd string, payload []byte, pause time.Duration) Step {
+	return func(c *Conn) error {
+		for _, b := range core.EncodeMessage(cmd, payload) {
+			if _, err := c.conn.Write([]byte{b}); err != nil {
+				return err
+			}
+			time.Sleep(pause)
+		}
+		return nil
+	}
+}
+
+// Delay pauses the script without reading from the client.
+func Delay(d time.Duration) Step {
+	return func(c *Conn) error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+// Hang stops responding until the client disconnects (input is discarded).
+func Hang() Step {
+	return func(c *Conn) error {
+		buf := make([]byte, 1)
+		for {
+			if _, err := c.conn.Read(buf); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// Disconnect closes the connection.
+func Disconnect() Step {
+	return func(c *Conn) error {
+		return c.conn.Close()
+	}
+}
+
+// Replay plays the peer's side of a captured session.
+// It must come before any step that reads from the client.
+func Replay(session capture.Capture) Step {
+	return func(c *Conn) error {
+		return capture.Replay(c.conn, session)
+	}
+}
+
+// Steps combines several steps into one.
+func Steps(steps ...Step) Step {
+	return func(c *Conn) error {
+		for _, step := range steps {
+			if err := step(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
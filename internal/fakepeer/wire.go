@@ -0,0 +1,49 @@
+package fakepeer
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	core "code.dogecoin.org/dogemap-backend/internal/core"
+)
+
+// Protocol version announced by DefaultVersion.
+const DefaultProtocolVersion = 70015
+
+// DefaultVersion is a 'version' message typical of a Dogecoin Core 1.14 node.
+func DefaultVersion() core.VersionMsg {
+	return core.VersionMsg{
+		Version:   DefaultProtocolVersion,
+		Services:  core.NodeNetwork | core.NodeBloom,
+		Timestamp: time.Now().Unix(),
+		RemoteAddr: core.NetAddr{
+			Address: net.IPv4(127, 0, 0, 1).To16(),
+		},
+		LocalAddr: core.NetAddr{
+			Address: net.IPv6zero,
+		},
+		Nonce:  0xd06ed06e,
+		Agent:  "/Shibetoshi:1.14.9/",
+		Height: 5400000,
+		Relay:  true,
+	}
+}
+
+// MakeAddrs generates `n` distinct IPv4 addresses starting at `first`,
+// all with the given port and timestamp.
+func MakeAddrs(n int, first net.IP, port uint16, when time.Time) []core.NetAddr {
+	base := binary.BigEndian.Uint32(first.To4())
+	res := make([]core.NetAddr, 0, n)
+	for i := 0; i < n; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+uint32(i))
+		res = append(res, core.NetAddr{
+			Time:     uint32(when.Unix()),
+			Services: core.NodeNetwork,
+			Address:  ip.To16(),
+			Port:     port,
+		})
+	}
+	return res
+}
@@ -2,13 +2,16 @@ package geoip
 
 import (
 	"encoding/csv"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 
+	"code.dogecoin.org/dogemap-backend/internal/spec"
 	"github.com/philpearl/intern"
 )
 
@@ -25,14 +28,21 @@ type GeoIPDatabase struct {
 	Records []IPRecord
 	index   []uint32
 	intern  *intern.Intern
+	source  string // identifies the database file version (see Source)
 }
 
+var _ spec.Locator = &GeoIPDatabase{}
+
 func NewGeoIPDatabase(filename string) (*GeoIPDatabase, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
 
 	r := csv.NewReader(file)
 	records, err := r.ReadAll()
@@ -44,6 +54,7 @@ func NewGeoIPDatabase(filename string) (*GeoIPDatabase, error) {
 		Records: make([]IPRecord, 0, len(records)),
 		index:   make([]uint32, 0, len(records)),
 		intern:  intern.New(256),
+		source:  fmt.Sprintf("geoip:%s:%d", filepath.Base(filename), info.ModTime().Unix()),
 	}
 
 	for i, record := range records {
@@ -77,6 +88,14 @@ func substr(s string, n int) string {
 }
 
 func (db *GeoIPDatabase) FindLocation(ip net.IP) (string, string, string, string) {
+	if rec := db.lookup(ip); rec != nil {
+		return rec.Latitude, rec.Longitude, rec.Country, rec.City
+	}
+	return "0.0", "0.0", "", ""
+}
+
+// lookup finds the record containing an IPv4 address (nil if none)
+func (db *GeoIPDatabase) lookup(ip net.IP) *IPRecord {
 	ipLong := ipToUint32(ip.To4())
 	if ipLong != 0 {
 		// 0 <= pos <= len(index)
@@ -84,21 +103,43 @@ func (db *GeoIPDatabase) FindLocation(ip net.IP) (string, string, string, string
 		if pos > 0 {
 			// usually our search result (insertion-point) is immediately
 			// after the record we're looking for.
-			rec := db.Records[pos-1]
+			rec := &db.Records[pos-1]
 			if ipLong >= rec.Start && ipLong <= rec.End {
-				return rec.Latitude, rec.Longitude, rec.Country, rec.City
+				return rec
 			}
 		}
 		if pos < len(db.Records) {
 			// however, if our address exactly equals the Start address,
 			// we'll get an exact record index.
-			rec := db.Records[pos]
+			rec := &db.Records[pos]
 			if ipLong >= rec.Start && ipLong <= rec.End {
-				return rec.Latitude, rec.Longitude, rec.Country, rec.City
+				return rec
 			}
 		}
 	}
-	return "0.0", "0.0", "", ""
+	return nil
+}
+
+// Source identifies the database file: it changes when the file is replaced.
+func (db *GeoIPDatabase) Source() string {
+	return db.source
+}
+
+// Locate implements spec.Locator; addresses not in the database (including
+// all IPv6 addresses) are not Found.
+func (db *GeoIPDatabase) Locate(ip net.IP) spec.Location {
+	loc := spec.Location{Source: db.source}
+	rec := db.lookup(ip)
+	if rec == nil {
+		return loc
+	}
+	lat, e1 := strconv.ParseFloat(rec.Latitude, 64)
+	lon, e2 := strconv.ParseFloat(rec.Longitude, 64)
+	if e1 != nil || e2 != nil || !spec.ValidLatLon(lat, lon) {
+		return loc
+	}
+	loc.Lat, loc.Lon, loc.Country, loc.City, loc.Found = lat, lon, rec.Country, rec.City, true
+	return loc
 }
 
 func SearchUInt32(a []uint32, x uint32) int {
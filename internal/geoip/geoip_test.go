@@ -0,0 +1,40 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 10.1.0.0/24 in Sydney; a row with a bad latitude.
+const testCSV = `167837696,167837951,AU,New South Wales,,Sydney,,-33.8688,151.2093
+167903232,167903487,XX,,,Bad,,north,0
+`
+
+func TestLocate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dbip-city-ipv4-num.csv")
+	if err := os.WriteFile(file, []byte(testCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewGeoIPDatabase(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := db.Locate(net.IPv4(10, 1, 0, 0))
+	if !loc.Found || loc.Lat != -33.8688 || loc.Lon != 151.2093 || loc.Country != "AU" || loc.City != "Sydney" || loc.Source != db.Source() {
+		t.Fatalf("expected Sydney, got %+v", loc)
+	}
+	for _, ip := range []net.IP{net.IPv4(10, 1, 1, 0), net.IPv4(10, 2, 0, 1), net.ParseIP("2001:db8::1")} {
+		loc := db.Locate(ip)
+		if loc.Found || loc.Lat != 0 || loc.Lon != 0 || loc.Country != "" || loc.City != "" {
+			t.Errorf("%v: expected not found, got %+v", ip, loc)
+		}
+		if loc.Source != db.Source() {
+			t.Errorf("%v: expected source %q so the lookup is not retried, got %+v", ip, db.Source(), loc)
+		}
+	}
+	if lat, lon, country, city := db.FindLocation(net.ParseIP("2001:db8::1")); lat != "0.0" || lon != "0.0" || country != "" || city != "" {
+		t.Errorf("FindLocation: expected the 0.0 fallback, got %v %v %v %v", lat, lon, country, city)
+	}
+}
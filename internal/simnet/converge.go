@@ -0,0 +1,109 @@
+package simnet
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"code.dogecoin.org/governor"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/collector"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/store"
+)
+
+// CrawlOptions configures the crawlers run against the simulation.
+type CrawlOptions struct {
+	Crawlers int                // number of crawlers (in addition to the seed-node collector)
+	Schedule collector.Schedule // crawler schedule (e.g. collector.DefaultSchedule.Scaled(0.01))
+	MaxTime  time.Duration      // per-node session limit
+	Target   float64            // stop once this fraction of online nodes is mapped (0..1)
+	Poll     time.Duration      // how often to measure the store (real time)
+	Clock    clock.Clock        // crawler and store clock, e.g. clock.FastForward (nil: clock.System)
+	Retain   spec.Retention     // if set, also run the StoreTrimmer, so offline nodes expire
+}
+
+// Sample is one measurement of the map against the true node set.
+type Sample struct {
+	Elapsed  time.Duration // on CrawlOptions.Clock
+	Online   int           // nodes currently online
+	Mapped   int           // online nodes present in the store
+	Stale    int           // offline nodes present in the store
+	Coverage float64       // Mapped / Online
+}
+
+func (s Sample) String() string {
+	return fmt.Sprintf("%8.2fs online=%d mapped=%d stale=%d coverage=%.1f%%", s.Elapsed.Seconds(), s.Online, s.Mapped, s.Stale, s.Coverage*100)
+}
+
+// Result of a convergence run.
+type Result struct {
+	Samples   []Sample
+	Converged bool          // reached Target before the context ended
+	Elapsed   time.Duration // time to reach Target (or time spent)
+}
+
+// Converge runs crawlers against the network, writing to `db`,
+// and samples the store until the map covers `Target` of the online
+// nodes or the context is done.
+func (n *Network) Converge(ctx context.Context, db spec.Store, opts CrawlOptions) (res Result, err error) {
+	if opts.Poll <= 0 {
+		opts.Poll = 100 * time.Millisecond
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.System
+	}
+	gov := governor.New()
+	gov.Add("sim-seed", collector.New(db, n.Seed(), opts.MaxTime, true).WithSchedule(opts.Schedule).WithClock(opts.Clock))
+	for i := 0; i < opts.Crawlers; i++ {
+		gov.Add(fmt.Sprintf("sim-crawler-%d", i), collector.New(db, spec.Address{}, opts.MaxTime, false).WithSchedule(opts.Schedule).WithClock(opts.Clock))
+	}
+	if opts.Retain != (spec.Retention{}) {
+		gov.Add("sim-trimmer", store.NewStoreTrimmer(db, opts.Retain).WithClock(opts.Clock))
+	}
+	gov.Start()
+	defer gov.Shutdown()
+
+	start := opts.Clock.Now()
+	db = db.WithCtx(ctx).WithClock(opts.Clock)
+	for {
+		sample, err := n.Measure(db)
+		if err != nil {
+			return res, err
+		}
+		sample.Elapsed = opts.Clock.Now().Sub(start)
+		res.Samples = append(res.Samples, sample)
+		res.Elapsed = sample.Elapsed
+		if sample.Coverage >= opts.Target {
+			res.Converged = true
+			return res, nil
+		}
+		select {
+		case <-ctx.Done():
+			return res, nil
+		case <-time.After(opts.Poll):
+		}
+	}
+}
+
+// Measure compares the nodes in `store` with the nodes currently online.
+func (n *Network) Measure(store spec.Store) (s Sample, err error) {
+	nodes, err := store.NodeList()
+	if err != nil {
+		return s, err
+	}
+	online := n.Online()
+	for _, node := range nodes {
+		if online[node.Address] {
+			s.Mapped++
+		} else {
+			s.Stale++
+		}
+	}
+	s.Online = len(online)
+	if s.Online > 0 {
+		s.Coverage = float64(s.Mapped) / float64(s.Online)
+	}
+	return s, nil
+}
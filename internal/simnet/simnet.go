@@ -0,0 +1,190 @@
+package simnet
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	core "code.dogecoin.org/dogemap-backend/internal/core"
+	"code.dogecoin.org/dogemap-backend/internal/fakepeer"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// Config describes a simulated Dogecoin network.
+// The same Config (including Seed) always produces the same topology
+// and the same sequence of churn events.
+type Config struct {
+	Nodes      int           // number of fake nodes
+	KnownPeers int           // size of each node's addrman
+	Offline    float64       // fraction of nodes that start offline (0..1)
+	Churn      float64       // fraction of nodes toggled online/offline per churn round (0..1)
+	ChurnEvery time.Duration // interval between churn rounds (0 = no churn)
+	Seed       int64         // random seed
+	Clock      clock.Clock   // timestamps in gossiped addresses, and the churn schedule (nil: clock.System)
+}
+
+// Node is one fake Core node in the simulation.
+type Node struct {
+	Index   int
+	Address spec.Address
+	Known   []int // addrman: indexes of nodes this node knows about
+	mutex   sync.Mutex
+	peer    *fakepeer.Peer // nil while offline
+}
+
+// Network is a set of fake nodes listening on loopback.
+type Network struct {
+	Config Config
+	Nodes  []*Node
+	rand   *rand.Rand
+	mutex  sync.Mutex      // protects rand
+	ctx    context.Context // cancelled by Close
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates the simulated network and brings its online nodes up.
+// Node 0 is the seed node: it is always online.
+func New(cfg Config) (*Network, error) {
+	if cfg.Nodes < 1 {
+		return nil, fmt.Errorf("simnet: need at least one node")
+	}
+	if cfg.KnownPeers <= 0 || cfg.KnownPeers >= cfg.Nodes {
+		cfg.KnownPeers = cfg.Nodes - 1
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.System
+	}
+	n := &Network{Config: cfg, rand: rand.New(rand.NewSource(cfg.Seed))}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+
+	// reserve a loopback port for each node.
+	for i := 0; i < cfg.Nodes; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			n.Close()
+			return nil, fmt.Errorf("simnet: %v", err)
+		}
+		port := ln.Addr().(*net.TCPAddr).Port
+		ln.Close()
+		n.Nodes = append(n.Nodes, &Node{
+			Index:   i,
+			Address: spec.Address{Host: net.IPv4(127, 0, 0, 1), Port: uint16(port)},
+		})
+	}
+
+	// give each node its own addrman.
+	for _, node := range n.Nodes {
+		for _, k := range n.rand.Perm(cfg.Nodes) {
+			if len(node.Known) == cfg.KnownPeers {
+				break
+			}
+			if k != node.Index {
+				node.Known = append(node.Known, k)
+			}
+		}
+	}
+
+	// bring nodes online.
+	for _, node := range n.Nodes {
+		if node.Index == 0 || n.rand.Float64() >= cfg.Offline {
+			if err := n.SetOnline(node, true); err != nil {
+				n.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.ChurnEvery > 0 {
+		n.wg.Add(1)
+		go n.churn()
+	}
+	return n, nil
+}
+
+// Seed returns the address of the seed node.
+func (n *Network) Seed() spec.Address {
+	return n.Nodes[0].Address
+}
+
+// Online returns the addresses of all nodes currently online.
+func (n *Network) Online() map[string]bool {
+	res := make(map[string]bool, len(n.Nodes))
+	for _, node := range n.Nodes {
+		if node.IsOnline() {
+			res[node.Address.String()] = true
+		}
+	}
+	return res
+}
+
+// SetOnline starts or stops a node's listener.
+func (n *Network) SetOnline(node *Node, online bool) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if online && node.peer == nil {
+		peer, err := fakepeer.ListenOn(node.Address.String(),
+			fakepeer.Handshake(),
+			fakepeer.SendPing(uint64(node.Index)),
+			fakepeer.Expect("getaddr"),
+			fakepeer.SendAddr(n.addrman(node)),
+			fakepeer.Disconnect())
+		if err != nil {
+			return fmt.Errorf("simnet: node %d: %v", node.Index, err)
+		}
+		node.peer = peer
+	} else if !online && node.peer != nil {
+		node.peer.Close()
+		node.peer = nil
+	}
+	return nil
+}
+
+func (node *Node) IsOnline() bool {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.peer != nil
+}
+
+// Close stops churn and takes all nodes offline.
+func (n *Network) Close() {
+	n.cancel()
+	n.wg.Wait()
+	for _, node := range n.Nodes {
+		n.SetOnline(node, false)
+	}
+}
+
+// addrman builds the 'addr' entries a node gossips, all seen just now.
+func (n *Network) addrman(node *Node) []core.NetAddr {
+	res := make([]core.NetAddr, 0, len(node.Known))
+	now := uint32(n.Config.Clock.Now().Unix())
+	for _, k := range node.Known {
+		res = append(res, core.NetAddr{
+			Time:     now,
+			Services: core.NodeNetwork,
+			Address:  n.Nodes[k].Address.Host.To16(),
+			Port:     n.Nodes[k].Address.Port,
+		})
+	}
+	return res
+}
+
+// goroutine
+func (n *Network) churn() {
+	defer n.wg.Done()
+	for !n.Config.Clock.Sleep(n.ctx, n.Config.ChurnEvery) {
+		for _, node := range n.Nodes[1:] { // seed node stays online
+			n.mutex.Lock()
+			flip := n.rand.Float64() < n.Config.Churn
+			n.mutex.Unlock()
+			if flip {
+				n.SetOnline(node, !node.IsOnline())
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package simnet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/collector"
+	"code.dogecoin.org/dogemap-backend/internal/store"
+)
+
+func TestConverge(t *testing.T) {
+	n, err := New(Config{Nodes: 30, KnownPeers: 6, Offline: 0.2, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	db := store.NewMemoryStore(context.Background())
+	opts := CrawlOptions{
+		Crawlers: 3,
+		Schedule: collector.DefaultSchedule.Scaled(0.001),
+		MaxTime:  2 * time.Second,
+		Target:   1.0,
+		Poll:     20 * time.Millisecond,
+	}
+	res, err := n.Converge(ctx, db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := res.Samples[len(res.Samples)-1]
+	if !res.Converged || last.Coverage < opts.Target {
+		t.Fatalf("did not converge: %v", last)
+	}
+	if last.Online != len(n.Online()) || last.Mapped != last.Online {
+		t.Errorf("expected every online node mapped, got %v", last)
+	}
+}
+
+func TestSeedTopology(t *testing.T) {
+	cfg := Config{Nodes: 20, KnownPeers: 5, Offline: 0.5, Seed: 42}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for i := range a.Nodes {
+		if len(a.Nodes[i].Known) != cfg.KnownPeers {
+			t.Fatalf("node %d: expected %d known peers, got %v", i, cfg.KnownPeers, a.Nodes[i].Known)
+		}
+		for k := range a.Nodes[i].Known {
+			if a.Nodes[i].Known[k] != b.Nodes[i].Known[k] {
+				t.Fatalf("node %d: addrman differs with the same seed: %v %v", i, a.Nodes[i].Known, b.Nodes[i].Known)
+			}
+		}
+		if a.Nodes[i].IsOnline() != b.Nodes[i].IsOnline() {
+			t.Fatalf("node %d: online state differs with the same seed", i)
+		}
+	}
+	if !a.Nodes[0].IsOnline() {
+		t.Error("seed node is offline")
+	}
+}
+
+func TestChurnClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	n, err := New(Config{Nodes: 10, Churn: 1, ChurnEvery: time.Minute, Seed: 1, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	waitSleeping(t, clk)
+	if online := len(n.Online()); online != 10 {
+		t.Fatalf("expected 10 nodes online, got %d", online)
+	}
+
+	// every node except the seed flips each round.
+	clk.Advance(59 * time.Second)
+	if online := len(n.Online()); online != 10 {
+		t.Fatalf("churn before ChurnEvery: %d nodes online", online)
+	}
+	clk.Advance(time.Second)
+	waitSleeping(t, clk)
+	if online := n.Online(); len(online) != 1 || !online[n.Seed().String()] {
+		t.Fatalf("expected only the seed node online, got %v", online)
+	}
+	clk.Advance(time.Minute)
+	waitSleeping(t, clk)
+	if online := len(n.Online()); online != 10 {
+		t.Fatalf("expected 10 nodes online again, got %d", online)
+	}
+}
+
+// waitSleeping waits for the churn goroutine to block in its clock.
+func waitSleeping(t *testing.T, clk *clock.Fake) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for clk.Sleepers() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for churn to sleep")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -3,6 +3,8 @@ package spec
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"net"
 
 	"code.dogecoin.org/gossip/dnet"
 )
@@ -27,11 +29,38 @@ func (id NodeID) String() string {
 func NodeIDFromAddress(a Address) NodeID {
 	var id NodeID
 	id[0] = NodeIDAddress
-	copy(id[1:17], a.Host)
+	copy(id[1:17], a.Host.To16())
 	binary.BigEndian.PutUint16(id[17:], a.Port)
 	return id
 }
 
+// ParseNodeID parses the hex form of a NodeID (see String)
+func ParseNodeID(s string) (NodeID, error) {
+	var id NodeID
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, errors.New("invalid node id: expecting 66 hex digits")
+	}
+	copy(id[:], b)
+	if id[0] != NodeIDAddress && id[0] != NodeIDPubKey {
+		return id, errors.New("invalid node id: unknown type")
+	}
+	return id, nil
+}
+
+// Kind is NodeIDAddress or NodeIDPubKey.
+func (id NodeID) Kind() byte {
+	return id[0]
+}
+
+// Address returns the host:port of a Core Node's NodeID.
+func (id NodeID) Address() (Address, bool) {
+	if id[0] != NodeIDAddress {
+		return Address{}, false
+	}
+	return Address{Host: net.IP(id[1:17]), Port: binary.BigEndian.Uint16(id[17:])}, true
+}
+
 // NodeID creates a NodeID from a public key.
 func NodeIDFromKey(key PubKey) NodeID {
 	var id NodeID
@@ -40,6 +69,15 @@ func NodeIDFromKey(key PubKey) NodeID {
 	return id
 }
 
+// ParsePubKey parses a 32-byte public key in hex.
+func ParsePubKey(s string) (PubKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("invalid pubkey: expecting 64 hex digits")
+	}
+	return (*[32]byte)(b), nil
+}
+
 // BindTo binds to either a Unix socket or a TCP interface
 type BindTo struct {
 	Network string // "unix" or "tcp"
@@ -0,0 +1,27 @@
+package spec
+
+// Census rollup intervals, in seconds (see Store.AddCensus)
+var CensusIntervals = map[string]int64{
+	"hour": 60 * 60,
+	"day":  SecondsPerDay,
+}
+
+// Census dimensions: each snapshot counts nodes grouped by every dimension.
+const (
+	CensusTotal        = "total"        // all nodes (label "")
+	CensusType         = "type"         // "core" or "dogenet"
+	CensusCountry      = "country"      // ISO 3166-1 alpha-2 code ("" if unknown)
+	CensusVersion      = "version"      // user agent, e.g. "/Shibetoshi:1.14.7/" ("" if never probed)
+	CensusServices     = "services"     // service bits, in decimal
+	CensusReachability = "reachability" // "reachable" or "gossiped"
+)
+
+var CensusDimensions = []string{CensusTotal, CensusType, CensusCountry, CensusVersion, CensusServices, CensusReachability}
+
+// CensusCount is the number of nodes with a label, in one snapshot.
+type CensusCount struct {
+	Time      int64  `json:"time"` // start of the interval
+	Dimension string `json:"dimension"`
+	Label     string `json:"label"`
+	Count     int64  `json:"count"`
+}
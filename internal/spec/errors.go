@@ -12,6 +12,7 @@ const (
 	AlreadyExists ErrorCode = "already-exists" // Store must return this when a record already exists
 	DBConflict    ErrorCode = "db-conflict"    // Store must return this when a DB Txn Conflict occurs (caller must retry Txn)
 	DBProblem     ErrorCode = "db-problem"     // Store must return this when the DB returns unexpected errors
+	ReadOnly      ErrorCode = "read-only"      // Store must return this when writing to a read-only Store
 )
 
 type ErrorInfo struct {
@@ -43,6 +44,7 @@ var NotFoundError = NewErr(NotFound, "not-found")
 var AlreadyExistsError = NewErr(AlreadyExists, "already-exists")
 var DBConflictError = NewErr(DBConflict, "db-conflict")
 var DBProblemError = NewErr(DBProblem, "db-problem")
+var ReadOnlyError = NewErr(ReadOnly, "read-only")
 
 func NewErr(code ErrorCode, format string, args ...any) error {
 	return &ErrorInfo{Code: code, Message: fmt.Sprintf(format, args...)}
@@ -67,3 +69,7 @@ func IsDBConflictError(err error) bool {
 func IsDBProblemError(err error) bool {
 	return errors.Is(err, DBProblemError)
 }
+
+func IsReadOnlyError(err error) bool {
+	return errors.Is(err, ReadOnlyError)
+}
@@ -0,0 +1,115 @@
+package spec
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Mean Earth radius, for great-circle distances (see DistanceKm)
+const EarthRadiusKm = 6371.0088
+
+// GeoBox is a bounding box in degrees (see Store.CoreNodesInBox).
+// It crosses the antimeridian if MinLon > MaxLon.
+type GeoBox struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+// ParseGeoBox parses "minLon,minLat,maxLon,maxLat"
+func ParseGeoBox(s string) (GeoBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return GeoBox{}, errors.New("invalid bbox: expecting minLon,minLat,maxLon,maxLat")
+	}
+	var val [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(f) {
+			return GeoBox{}, errors.New("invalid bbox: expecting minLon,minLat,maxLon,maxLat")
+		}
+		val[i] = f
+	}
+	box := GeoBox{MinLon: val[0], MinLat: val[1], MaxLon: val[2], MaxLat: val[3]}
+	if !ValidLatLon(box.MinLat, box.MinLon) || !ValidLatLon(box.MaxLat, box.MaxLon) {
+		return GeoBox{}, errors.New("invalid bbox: expecting longitude -180..180 and latitude -90..90")
+	}
+	if box.MinLat > box.MaxLat {
+		return GeoBox{}, errors.New("invalid bbox: minLat is greater than maxLat")
+	}
+	return box, nil
+}
+
+// ValidLatLon reports whether lat and lon are in range.
+func ValidLatLon(lat float64, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// Contains reports whether the box contains a location.
+func (b GeoBox) Contains(lat float64, lon float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon > b.MaxLon {
+		return lon >= b.MinLon || lon <= b.MaxLon
+	}
+	return lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// Split splits a box that crosses the antimeridian into two boxes
+// that do not (for index queries)
+func (b GeoBox) Split() []GeoBox {
+	if b.MinLon > b.MaxLon {
+		east, west := b, b
+		east.MaxLon = 180
+		west.MinLon = -180
+		return []GeoBox{east, west}
+	}
+	return []GeoBox{b}
+}
+
+// Center returns the middle of the box.
+func (b GeoBox) Center() (lat float64, lon float64) {
+	lat = (b.MinLat + b.MaxLat) / 2
+	if b.MinLon > b.MaxLon {
+		lon = (b.MinLon + b.MaxLon + 360) / 2
+		if lon > 180 {
+			lon -= 360
+		}
+		return lat, lon
+	}
+	return lat, (b.MinLon + b.MaxLon) / 2
+}
+
+// BoxAround returns the smallest GeoBox containing every location within
+// `radiusKm` of lat, lon (see DistanceKm)
+func BoxAround(lat float64, lon float64, radiusKm float64) GeoBox {
+	dist := radiusKm / EarthRadiusKm // radians
+	dLat := dist * 180 / math.Pi
+	if lat+dLat >= 90 || lat-dLat <= -90 || dist >= math.Pi/2 {
+		// includes a pole: every longitude.
+		return GeoBox{MinLon: -180, MinLat: math.Max(lat-dLat, -90), MaxLon: 180, MaxLat: math.Min(lat+dLat, 90)}
+	}
+	dLon := math.Asin(math.Sin(dist)/math.Cos(lat*math.Pi/180)) * 180 / math.Pi
+	minLon, maxLon := lon-dLon, lon+dLon
+	if minLon < -180 {
+		minLon += 360 // crosses the antimeridian
+	}
+	if maxLon > 180 {
+		maxLon -= 360
+	}
+	return GeoBox{MinLon: minLon, MinLat: lat - dLat, MaxLon: maxLon, MaxLat: lat + dLat}
+}
+
+// DistanceKm returns the great-circle distance between two locations
+// (haversine formula)
+func DistanceKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Sqrt(math.Min(h, 1)))
+}
@@ -0,0 +1,29 @@
+package spec
+
+// Sighting is an interval during which a node was present.
+type Sighting struct {
+	First int64 `json:"first"` // unix time the node appeared
+	Last  int64 `json:"last"`  // unix time the node was last seen
+	Gone  int64 `json:"gone"`  // unix time the node disappeared (0 if still present)
+}
+
+// NodeHistory is the sighting history of a node (see Store.NodeHistory)
+type NodeHistory struct {
+	Node      string     `json:"node"`      // NodeID hex
+	FirstSeen int64      `json:"firstSeen"` // first appearance
+	LastSeen  int64      `json:"lastSeen"`  // most recent sighting
+	Present   bool       `json:"present"`   // false if the node has disappeared
+	Intervals []Sighting `json:"intervals"` // oldest first
+}
+
+// MakeNodeHistory summarises a node's sightings (oldest first)
+func MakeNodeHistory(id NodeID, intervals []Sighting) NodeHistory {
+	h := NodeHistory{Node: id.String(), Intervals: intervals}
+	if len(intervals) > 0 {
+		last := intervals[len(intervals)-1]
+		h.FirstSeen = intervals[0].First
+		h.LastSeen = last.Last
+		h.Present = last.Gone == 0
+	}
+	return h
+}
@@ -0,0 +1,45 @@
+package spec
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Location.Source of a DogeBox located by its owner's profile
+// (see IdentityProfile.Location)
+const IdentityLocSource = "identity"
+
+// IdentityProfile is a DogeBox owner's profile, cached from the identity
+// service (see Store.UpdateIdentities). Lat, Lon, Country and City are
+// the location the owner published (as strings, as returned by the
+// identity service); Profile is the whole profile.
+type IdentityProfile struct {
+	Identity string          `json:"identity"` // identity pubkey hex
+	Lat      string          `json:"lat"`
+	Lon      string          `json:"lon"`
+	Country  string          `json:"country"`
+	City     string          `json:"city"`
+	Profile  json.RawMessage `json:"profile"`
+	Fetched  int64           `json:"fetched"` // when last fetched (set by the Store)
+	Changed  int64           `json:"changed"` // when the profile last changed (set by the Store)
+}
+
+// IdentityChange is an entry in an identity's profile history
+// (see Store.IdentityHistory)
+type IdentityChange struct {
+	Time    int64           `json:"time"`
+	Profile json.RawMessage `json:"profile"` // null when the profile was removed (see Store.TrimIdentities)
+}
+
+// Location returns the location the owner published, if it is valid.
+func (p IdentityProfile) Location() (Location, bool) {
+	lat, err := strconv.ParseFloat(p.Lat, 64)
+	if err != nil {
+		return Location{}, false
+	}
+	lon, err := strconv.ParseFloat(p.Lon, 64)
+	if err != nil || !ValidLatLon(lat, lon) {
+		return Location{}, false
+	}
+	return Location{Lat: lat, Lon: lon, Country: p.Country, City: p.City, Source: IdentityLocSource, Found: true}, true
+}
@@ -6,15 +6,50 @@ type NodeListRes struct {
 }
 
 type CoreNode struct {
-	Address  string `json:"address"`
-	Time     int64  `json:"time"`
-	Services uint64 `json:"services"`
+	Address   string  `json:"address"`
+	Time      int64   `json:"time"`
+	Services  uint64  `json:"services"`
+	Caps      uint32  `json:"caps"`      // capability bitmap (see CoreProbe)
+	Skew      int64   `json:"skew"`      // clock offset in seconds (see CoreProbe)
+	RTT       int64   `json:"rtt"`       // round-trip time in milliseconds
+	Probed    int64   `json:"probed"`    // when Caps, Skew and RTT were measured (0 if never)
+	Version   int32   `json:"version"`   // protocol version (see CoreProbe)
+	Agent     string  `json:"agent"`     // user agent (see CoreProbe)
+	Reachable bool    `json:"reachable"` // we have connected to this node
+	Lat       float64 `json:"lat"`       // location (see Location)
+	Lon       float64 `json:"lon"`
+	Country   string  `json:"country"`
+	City      string  `json:"city"`
+	LocSource string  `json:"locsrc"`  // "" if not yet located
+	Located   bool    `json:"located"` // LocSource found a location (see Location.Found)
 }
 
 type NetNode struct {
-	PubKey   string   `json:"pubkey"`
-	Address  string   `json:"address"`
-	Time     int64    `json:"time"`
-	Channels []string `json:"channels"`
-	Identity string   `json:"identity"`
+	PubKey    string   `json:"pubkey"`
+	Address   string   `json:"address"`
+	Time      int64    `json:"time"`
+	Channels  []string `json:"channels"`
+	Identity  string   `json:"identity"`
+	Seen      int64    `json:"seen"` // when we last fetched it from dogenet (set by the Store)
+	Lat       float64  `json:"lat"`  // map location (see collector.NetCollector)
+	Lon       float64  `json:"lon"`
+	Country   string   `json:"country"`
+	City      string   `json:"city"`
+	LocSource string   `json:"locsrc"`  // IdentityLocSource, or the Locator's Source ("" if not yet located)
+	Located   bool     `json:"located"` // LocSource found a location (see Location.Found)
+}
+
+// SetLocation stores `loc` as the node's map location.
+func (n *NetNode) SetLocation(loc Location) {
+	n.Lat, n.Lon, n.Country, n.City = loc.Lat, loc.Lon, loc.Country, loc.City
+	n.LocSource, n.Located = loc.Source, loc.Found
+}
+
+// NodeID returns the NodeID of a dogenet node (see ParsePubKey)
+func (n NetNode) NodeID() (NodeID, error) {
+	key, err := ParsePubKey(n.PubKey)
+	if err != nil {
+		return NodeID{}, err
+	}
+	return NodeIDFromKey(key), nil
 }
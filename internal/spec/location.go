@@ -0,0 +1,28 @@
+package spec
+
+import "net"
+
+// Location is where a node is, and where we got that from.
+type Location struct {
+	Lat     float64 // WGS84 degrees
+	Lon     float64 // WGS84 degrees
+	Country string  // ISO 3166-1 alpha-2 code ("" if unknown)
+	City    string  // city name ("" if unknown)
+	Source  string  // e.g. the GeoIP database version ("" if never resolved)
+	Found   bool    // false if Source has no location for the address (Lat, Lon, Country and City are empty)
+}
+
+// Locator resolves IP addresses to locations (e.g. a GeoIP database)
+type Locator interface {
+	// Source identifies the locator's data; nodes located with a
+	// different Source are re-resolved (see store.NewRelocator)
+	Source() string
+	// Locate always sets Location.Source, so a miss is not retried.
+	Locate(ip net.IP) Location
+}
+
+// CoreLocation is a resolved location for a Core Node.
+type CoreLocation struct {
+	Address  Address
+	Location Location
+}
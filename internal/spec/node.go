@@ -0,0 +1,19 @@
+package spec
+
+// NodeLink links a DogeBox (pubkey NodeID) to a Core Node it runs
+// (address NodeID): a Core Node on the same host as the DogeBox.
+// A DogeBox keeps its links when its address changes, until the
+// linked Core Node expires.
+type NodeLink struct {
+	Node NodeID // DogeBox: NodeIDPubKey
+	Core NodeID // Core Node: NodeIDAddress
+	Seen int64  // unix time the link was last observed
+}
+
+// NodeInfo is a single node (see Store.NodeInfo)
+type NodeInfo struct {
+	ID     string    `json:"id"`             // NodeID hex
+	Core   *CoreNode `json:"core,omitempty"` // set for a Core Node
+	Net    *NetNode  `json:"net,omitempty"`  // set for a DogeBox (dogenet node)
+	Linked []string  `json:"linked"`         // NodeIDs: the Core Nodes a DogeBox runs, or the DogeBoxes running a Core Node
+}
@@ -0,0 +1,33 @@
+package spec
+
+// Protocol capabilities observed on a Core Node (bit flags)
+const (
+	CapSendHeaders uint32 = 1 << iota // peer sent 'sendheaders' (BIP 130)
+	CapSendCmpct                      // peer sent 'sendcmpct' (BIP 152)
+	CapFeeFilter                      // peer sent 'feefilter' (BIP 133)
+	CapSendAddrV2                     // peer sent 'sendaddrv2' (BIP 155)
+	CapWtxidRelay                     // peer sent 'wtxidrelay' (BIP 339)
+	CapHeaders                        // peer answered our 'getheaders' with 'headers'
+)
+
+// CapNames maps each capability bit to its name, in bit order.
+var CapNames = []struct {
+	Bit  uint32
+	Name string
+}{
+	{CapSendHeaders, "sendheaders"},
+	{CapSendCmpct, "sendcmpct"},
+	{CapFeeFilter, "feefilter"},
+	{CapSendAddrV2, "sendaddrv2"},
+	{CapWtxidRelay, "wtxidrelay"},
+	{CapHeaders, "headers"},
+}
+
+// CoreProbe is what we learned about a Core Node by connecting to it.
+type CoreProbe struct {
+	Caps    uint32 // capability bitmap (Cap* flags)
+	Skew    int64  // node's clock minus ours, in seconds (corrected for RTT)
+	RTT     int64  // round-trip time of the version exchange, in milliseconds
+	Version int32  // protocol version from the node's 'version' message
+	Agent   string // user agent (subversion) from the node's 'version' message
+}
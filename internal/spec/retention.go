@@ -0,0 +1,71 @@
+package spec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Retention is how long TrimNodes keeps each class of node, in
+// days of the store's day counter (see SQLiteStore.TrimNodes)
+type Retention struct {
+	Gossiped  int64 // nodes we have only heard about in 'addr' gossip
+	Reachable int64 // nodes we have connected to
+	Identity  int64 // nodes backed by a DogeBox identity (DogeNet nodes)
+	Archive   bool  // move expired core nodes into core_archive instead of deleting them (DogeNet nodes are always deleted)
+}
+
+// DefaultRetention keeps every node for MaxCoreNodeDays.
+var DefaultRetention = Retention{
+	Gossiped:  MaxCoreNodeDays,
+	Reachable: MaxCoreNodeDays,
+	Identity:  MaxCoreNodeDays,
+}
+
+// String formats the policy as accepted by Set.
+func (r *Retention) String() string {
+	s := fmt.Sprintf("gossiped=%d,reachable=%d,identity=%d", r.Gossiped, r.Reachable, r.Identity)
+	if r.Archive {
+		s += ",archive"
+	}
+	return s
+}
+
+// Set parses a comma-separated list of `gossiped=N`, `reachable=N`,
+// `identity=N` (days) and `archive[=true|false]` that override the
+// current values; it implements flag.Value.
+func (r *Retention) Set(value string) error {
+	res := *r
+	for _, item := range strings.Split(value, ",") {
+		key, val, hasVal := strings.Cut(strings.TrimSpace(item), "=")
+		if key == "archive" {
+			res.Archive = true
+			if hasVal {
+				b, err := strconv.ParseBool(val)
+				if err != nil {
+					return fmt.Errorf("retention: bad value for archive: %v", val)
+				}
+				res.Archive = b
+			}
+			continue
+		}
+		var days *int64
+		switch key {
+		case "gossiped":
+			days = &res.Gossiped
+		case "reachable":
+			days = &res.Reachable
+		case "identity":
+			days = &res.Identity
+		default:
+			return fmt.Errorf("retention: unknown setting: %v", key)
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(val, "d"), 10, 64)
+		if !hasVal || err != nil || n < 0 {
+			return fmt.Errorf("retention: expecting %v=<days>", key)
+		}
+		*days = n
+	}
+	*r = res
+	return nil
+}
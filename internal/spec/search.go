@@ -0,0 +1,149 @@
+package spec
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// Kinds of search documents (see SearchDoc)
+const (
+	SearchCore     = "core"     // a Core Node: ID is its NodeID hex
+	SearchNet      = "net"      // a DogeBox: ID is its NodeID hex
+	SearchIdentity = "identity" // an identity profile: ID is the identity pubkey hex
+)
+
+// SearchDoc is a node or profile in the search index (see Store.SetSearchIndex)
+// Text holds lower-case tokens separated by spaces (see SearchTerms)
+type SearchDoc struct {
+	ID   string
+	Kind string
+	Text string
+}
+
+// SearchHit is a ranked search result (see Store.Search)
+type SearchHit struct {
+	ID    string  `json:"id"`
+	Kind  string  `json:"kind"`
+	Score float64 `json:"score"` // higher is better
+}
+
+// SearchTerms splits text into lower-case letter and digit tokens,
+// e.g. "1.2.3.4:22556" into 1 2 3 4 22556. Documents and queries are
+// tokenized the same way, so every backend matches the same terms.
+func SearchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// MakeSearchDocs builds the search index: Core Nodes by address, user
+// agent, city and country; DogeBoxes by pubkey, address and owner's
+// profile; and identity profiles by pubkey, name, city and country.
+// Countries are indexed by code and name (see CountryNames).
+func MakeSearchDocs(core []CoreNode, net []NetNode, profiles []IdentityProfile) []SearchDoc {
+	docs := make([]SearchDoc, 0, len(core)+len(net)+len(profiles))
+	for _, n := range core {
+		addr, err := dnet.ParseAddress(n.Address)
+		if err != nil {
+			continue
+		}
+		docs = append(docs, makeSearchDoc(NodeIDFromAddress(addr).String(), SearchCore, n.Address, n.Agent, n.City, n.Country, countryName(n.Country)))
+	}
+	byIdentity := make(map[string]IdentityProfile, len(profiles))
+	for _, p := range profiles {
+		byIdentity[p.Identity] = p
+		docs = append(docs, makeSearchDoc(p.Identity, SearchIdentity, p.Identity, p.Name(), p.City, p.Country, countryName(p.Country)))
+	}
+	for _, n := range net {
+		id, err := n.NodeID()
+		if err != nil {
+			continue
+		}
+		p := byIdentity[n.Identity]
+		docs = append(docs, makeSearchDoc(id.String(), SearchNet, n.PubKey, n.Address, n.Identity, p.Name(), p.City, p.Country, countryName(p.Country)))
+	}
+	return docs
+}
+
+// countryName returns the name of an ISO country code, or "" (profiles
+// may publish anything as their country)
+func countryName(code string) string {
+	return CountryNames[strings.ToUpper(code)]
+}
+
+func makeSearchDoc(id string, kind string, fields ...string) SearchDoc {
+	return SearchDoc{ID: id, Kind: kind, Text: strings.Join(SearchTerms(strings.Join(fields, " ")), " ")}
+}
+
+// MatchSearch reports whether every term is a prefix of a token in the
+// document (the match used by the search index)
+func MatchSearch(terms []string, doc SearchDoc) bool {
+	tokens := strings.Fields(doc.Text)
+	for _, term := range terms {
+		found := false
+		for _, tok := range tokens {
+			if strings.HasPrefix(tok, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// RankSearch scores and sorts matching documents, best first, keeping
+// at most `limit`. Whole-token matches score higher than prefixes, and
+// shorter documents higher than long ones.
+func RankSearch(terms []string, docs []SearchDoc, limit int) []SearchHit {
+	hits := make([]SearchHit, 0, len(docs))
+	for _, doc := range docs {
+		tokens := strings.Fields(doc.Text)
+		score := 0.0
+		for _, term := range terms {
+			best := 0.0
+			for _, tok := range tokens {
+				if tok == term {
+					best = 2
+					break
+				}
+				if strings.HasPrefix(tok, term) {
+					// longer prefixes are better
+					if prefix := 1 + float64(len(term))/float64(len(tok)+1); prefix > best {
+						best = prefix
+					}
+				}
+			}
+			score += best
+		}
+		score /= 1 + 0.05*float64(len(tokens))
+		hits = append(hits, SearchHit{ID: doc.ID, Kind: doc.Kind, Score: score})
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// Name returns the name in the identity's profile, if any.
+func (p IdentityProfile) Name() string {
+	var profile struct {
+		Name string `json:"name"`
+	}
+	if len(p.Profile) == 0 || json.Unmarshal(p.Profile, &profile) != nil {
+		return ""
+	}
+	return profile.Name
+}
@@ -2,25 +2,81 @@ package spec
 
 import (
 	"context"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
 )
 
 const SecondsPerDay = 24 * 60 * 60
 
-// Keep core nodes with timestamp in the last 2 days.
+// Keep core nodes with timestamp in the last 2 days (by default,
+// see Retention) and ignore older gossiped addresses.
 // We're relying on the local Core Node's database, which updates
 // slowly as other nodes gossip addresses (about 1 per minute)
 const MaxCoreNodeDays = 2
 
 // Store is the top-level interface (e.g. SQLiteStore)
-// It is bound to a cancellable Context.
+// It is bound to a cancellable Context and a Clock (default clock.System)
 type Store interface {
 	WithCtx(ctx context.Context) Store
+	WithClock(clock clock.Clock) Store
 	// common
 	CoreStats() (mapSize int, newNodes int, err error)
 	NodeList() (res []CoreNode, err error)
-	TrimNodes() (advanced bool, remCore int64, err error)
+	TrimNodes(retain Retention) (advanced bool, remCore int64, remNet int64, err error)
 	// core nodes
 	AddCoreNode(address Address, time int64, services uint64) error
+	AddCoreNodes(nodes []CoreAddr) (added int, updated int, err error) // in one transaction
 	UpdateCoreTime(address Address) error
+	UpdateCoreProbe(address Address, probe CoreProbe) error
 	ChooseCoreNode() (Address, error)
+	// core nodes not located by `source` (at most `limit`)
+	StaleLocations(source string, limit int) ([]Address, error)
+	UpdateCoreLocations(locs []CoreLocation) error
+	// located core nodes inside `box`, using a spatial index (nodes not
+	// yet looked up, see StaleLocations, or whose lookup failed are not
+	// included)
+	CoreNodesInBox(box GeoBox) ([]CoreNode, error)
+	// dogenet nodes, keyed by NetNode.PubKey
+	AddNetNodes(nodes []NetNode) (added int, updated int, err error) // in one transaction
+	NetNodeList() ([]NetNode, error)
+	NetNodeCount() (int, error)
+	// located dogenet nodes inside `box`, using a spatial index (see
+	// NetNode.Located)
+	NetNodesInBox(box GeoBox) ([]NetNode, error)
+	// bulk import (see `dogemap import`): nodes are stored as given,
+	// replacing existing nodes, without recording sightings.
+	ImportCoreNodes(nodes []CoreNode) error
+	ImportNetNodes(nodes []NetNode) error
+	// nodes by NodeID (NotFound if not present); DogeBoxes are linked
+	// to Core Nodes on the same host by AddNetNodes.
+	NodeInfo(id NodeID) (NodeInfo, error)
+	NodeLinks() ([]NodeLink, error)
+	// links to located DogeBoxes from the nodes inside `box` (see
+	// CoreNodesInBox and NetNodesInBox), using the spatial indexes
+	NodeLinksInBox(box GeoBox) ([]NodeLink, error)
+	// sighting history (NotFound if never seen)
+	NodeHistory(id NodeID) (NodeHistory, error)
+	// identity profiles (see collector.IdentityCache); UpdateIdentities
+	// records new and changed profiles in the identity's history.
+	IdentityProfiles() ([]IdentityProfile, error)
+	UpdateIdentities(profiles []IdentityProfile) (changed int, err error) // in one transaction
+	TrimIdentities(before int64) (removed int64, err error)               // profiles not fetched since `before`, and the history of identities removed before `before`
+	IdentityHistory(identity string) ([]IdentityChange, error)            // oldest first (NotFound if never seen)
+	// search index (see MakeSearchDocs): SetSearchIndex replaces the whole
+	// index, writing only the documents that changed; Search matches every
+	// term as a token prefix (see MatchSearch) and returns the best `limit`
+	// matches (see RankSearch)
+	SetSearchIndex(docs []SearchDoc) error
+	Search(query string, limit int) ([]SearchHit, error)
+	// census: AddCensus replaces the snapshot for the interval starting at `time`
+	AddCensus(interval string, time int64, counts []CensusCount) error
+	CensusSeries(interval string, dimension string, from int64, to int64) ([]CensusCount, error)
+}
+
+// CoreAddr is a Core Node address received in an 'addr' message.
+type CoreAddr struct {
+	Address  Address
+	Time     int64 // unix time the node was last seen (by the sender)
+	Services uint64
+	Location Location // optional: stored if Location.Source is set
 }
@@ -0,0 +1,1106 @@
+// Package storetest is a conformance test suite for spec.Store implementations.
+//
+// Run it from a _test.go file in the implementation's package:
+//
+//	func TestSQLiteStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) spec.Store {
+//			db, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"), context.Background())
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return db
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// Open must return a new, empty store for each call.
+type Open func(t *testing.T) spec.Store
+
+// Run runs the whole suite; each test gets a fresh store.
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s spec.Store)
+	}{
+		{"Empty", testEmpty},
+		{"AddCoreNode", testAddCoreNode},
+		{"AddCoreNodeUpdates", testAddCoreNodeUpdates},
+		{"AddCoreNodes", testAddCoreNodes},
+		{"Locations", testLocations},
+		{"CoreNodesInBox", testCoreNodesInBox},
+		{"UpdateCoreTime", testUpdateCoreTime},
+		{"UpdateCoreProbe", testUpdateCoreProbe},
+		{"UnknownNodeUpdates", testUnknownNodeUpdates},
+		{"ChooseCoreNode", testChooseCoreNode},
+		{"TrimNodes", testTrimNodes},
+		{"TrimRetention", testTrimRetention},
+		{"CoreHistory", testCoreHistory},
+		{"AddNetNodes", testAddNetNodes},
+		{"NetHistory", testNetHistory},
+		{"NodeLinks", testNodeLinks},
+		{"NetNodesInBox", testNetNodesInBox},
+		{"Import", testImport},
+		{"Identities", testIdentities},
+		{"Search", testSearch},
+		{"Census", testCensus},
+		{"CancelledContext", testCancelledContext},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, open(t).WithCtx(context.Background()))
+		})
+	}
+}
+
+// Addr returns a distinct test address for each n.
+func Addr(n int) spec.Address {
+	return spec.Address{Host: net.IPv4(10, 1, byte(n>>8), byte(n)).To16(), Port: 22556}
+}
+
+// CheckErr fails the test unless err is a spec.ErrorInfo with the given code.
+// Every error returned by a Store must carry a spec.ErrorCode.
+func CheckErr(t *testing.T, err error, code spec.ErrorCode) {
+	t.Helper()
+	var info *spec.ErrorInfo
+	if !errors.As(err, &info) {
+		t.Fatalf("expected a spec.ErrorInfo with code %q, got: %v", code, err)
+	}
+	if info.Code != code {
+		t.Fatalf("expected error code %q, got %q: %v", code, info.Code, err)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nodeMap(t *testing.T, s spec.Store) map[string]spec.CoreNode {
+	t.Helper()
+	nodes, err := s.NodeList()
+	must(t, err)
+	res := make(map[string]spec.CoreNode, len(nodes))
+	for _, n := range nodes {
+		if _, dup := res[n.Address]; dup {
+			t.Fatalf("NodeList: duplicate node %v", n.Address)
+		}
+		res[n.Address] = n
+	}
+	return res
+}
+
+func checkStats(t *testing.T, s spec.Store, mapSize int, newNodes int) {
+	t.Helper()
+	size, isnew, err := s.CoreStats()
+	must(t, err)
+	if size != mapSize || isnew != newNodes {
+		t.Fatalf("CoreStats: expected (%d, %d), got (%d, %d)", mapSize, newNodes, size, isnew)
+	}
+}
+
+func testEmpty(t *testing.T, s spec.Store) {
+	checkStats(t, s, 0, 0)
+	if nodes := nodeMap(t, s); len(nodes) != 0 {
+		t.Fatalf("NodeList: expected no nodes, got %d", len(nodes))
+	}
+	_, err := s.ChooseCoreNode()
+	CheckErr(t, err, spec.NotFound)
+	if !spec.IsNotFoundError(err) {
+		t.Fatalf("ChooseCoreNode: IsNotFoundError should be true")
+	}
+	_, remCore, remNet, err := s.TrimNodes(spec.DefaultRetention)
+	must(t, err)
+	if remCore != 0 || remNet != 0 {
+		t.Fatalf("TrimNodes: expected 0 removed, got %d, %d", remCore, remNet)
+	}
+	if count, err := s.NetNodeCount(); err != nil || count != 0 {
+		t.Fatalf("NetNodeCount: expected 0, got %d (%v)", count, err)
+	}
+}
+
+func testAddCoreNode(t *testing.T, s spec.Store) {
+	now := time.Now().Unix()
+	for i := 0; i < 10; i++ {
+		must(t, s.AddCoreNode(Addr(i), now-int64(i), uint64(i)))
+	}
+	checkStats(t, s, 10, 10)
+	nodes := nodeMap(t, s)
+	for i := 0; i < 10; i++ {
+		n, found := nodes[Addr(i).String()]
+		if !found {
+			t.Fatalf("NodeList: missing %v", Addr(i))
+		}
+		if n.Time != now-int64(i) || n.Services != uint64(i) {
+			t.Fatalf("NodeList: %v: expected time %d services %d, got %+v", Addr(i), now-int64(i), i, n)
+		}
+		if n.Probed != 0 {
+			t.Fatalf("NodeList: %v: new node should not be probed: %+v", Addr(i), n)
+		}
+	}
+}
+
+func testAddCoreNodeUpdates(t *testing.T, s spec.Store) {
+	now := time.Now().Unix()
+	must(t, s.AddCoreNode(Addr(1), now-100, 1))
+	must(t, s.AddCoreNode(Addr(1), now, 5)) // same address: must not fail with AlreadyExists
+	checkStats(t, s, 1, 1)
+	n := nodeMap(t, s)[Addr(1).String()]
+	if n.Time != now || n.Services != 5 {
+		t.Fatalf("AddCoreNode did not update: %+v", n)
+	}
+}
+
+func testAddCoreNodes(t *testing.T, s spec.Store) {
+	now := time.Now().Unix()
+	must(t, s.AddCoreNode(Addr(1), now-100, 1))
+	batch := make([]spec.CoreAddr, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		batch = append(batch, spec.CoreAddr{Address: Addr(i), Time: now, Services: 5})
+	}
+	added, updated, err := s.AddCoreNodes(batch)
+	must(t, err)
+	if added != 999 || updated != 1 {
+		t.Fatalf("AddCoreNodes: expected 999 added, 1 updated, got %d, %d", added, updated)
+	}
+	checkStats(t, s, 1000, 1000)
+	if n := nodeMap(t, s)[Addr(1).String()]; n.Time != now || n.Services != 5 {
+		t.Fatalf("AddCoreNodes did not update: %+v", n)
+	}
+	added, updated, err = s.AddCoreNodes(nil)
+	must(t, err)
+	if added != 0 || updated != 0 {
+		t.Fatalf("AddCoreNodes: expected no changes, got %d, %d", added, updated)
+	}
+}
+
+func testLocations(t *testing.T, s spec.Store) {
+	now := time.Now().Unix()
+	sydney := spec.Location{Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", Source: "geoip:1", Found: true}
+	_, _, err := s.AddCoreNodes([]spec.CoreAddr{
+		{Address: Addr(1), Time: now, Services: 1, Location: sydney},
+		{Address: Addr(2), Time: now, Services: 1},
+		{Address: Addr(3), Time: now, Services: 1},
+	})
+	must(t, err)
+	n := nodeMap(t, s)[Addr(1).String()]
+	if n.Lat != sydney.Lat || n.Lon != sydney.Lon || n.Country != "AU" || n.City != "Sydney" || n.LocSource != "geoip:1" {
+		t.Fatalf("AddCoreNodes: location not stored: %+v", n)
+	}
+	if !n.Located {
+		t.Fatalf("AddCoreNodes: location not marked found: %+v", n)
+	}
+	// gossip without a location keeps the stored location.
+	must(t, s.AddCoreNode(Addr(1), now, 1))
+	if n := nodeMap(t, s)[Addr(1).String()]; n.LocSource != "geoip:1" {
+		t.Fatalf("AddCoreNode erased location: %+v", n)
+	}
+	stale, err := s.StaleLocations("geoip:1", 10)
+	must(t, err)
+	if len(stale) != 2 {
+		t.Fatalf("StaleLocations: expected 2 unlocated nodes, got %v", stale)
+	}
+	stale, err = s.StaleLocations("geoip:2", 2)
+	must(t, err)
+	if len(stale) != 2 {
+		t.Fatalf("StaleLocations: expected limit of 2, got %v", stale)
+	}
+	berlin := spec.Location{Lat: 52.52, Lon: 13.405, Country: "DE", City: "Berlin", Source: "geoip:2", Found: true}
+	locs := []spec.CoreLocation{}
+	for i := 1; i <= 3; i++ {
+		locs = append(locs, spec.CoreLocation{Address: Addr(i), Location: berlin})
+	}
+	must(t, s.UpdateCoreLocations(locs))
+	stale, err = s.StaleLocations("geoip:2", 10)
+	must(t, err)
+	if len(stale) != 0 {
+		t.Fatalf("StaleLocations: expected none after UpdateCoreLocations, got %v", stale)
+	}
+	if n := nodeMap(t, s)[Addr(3).String()]; n.Country != "DE" || n.Lat != berlin.Lat || !n.Located {
+		t.Fatalf("UpdateCoreLocations: location not stored: %+v", n)
+	}
+
+	// a failed lookup is stored, so it is not retried, but not Located.
+	_, _, err = s.AddCoreNodes([]spec.CoreAddr{{Address: Addr(4), Time: now, Services: 1, Location: spec.Location{Source: "geoip:2"}}})
+	must(t, err)
+	must(t, s.UpdateCoreLocations([]spec.CoreLocation{{Address: Addr(3), Location: spec.Location{Source: "geoip:2"}}}))
+	stale, err = s.StaleLocations("geoip:2", 10)
+	must(t, err)
+	if len(stale) != 0 {
+		t.Fatalf("StaleLocations: expected failed lookups to be kept, got %v", stale)
+	}
+	nodes := nodeMap(t, s)
+	for _, addr := range []spec.Address{Addr(3), Addr(4)} {
+		if n := nodes[addr.String()]; n.Located || n.LocSource != "geoip:2" || n.Country != "" || n.Lat != 0 || n.Lon != 0 {
+			t.Fatalf("failed lookup: expected an unlocated node with locsrc geoip:2, got %+v", n)
+		}
+	}
+}
+
+func boxAddrs(t *testing.T, s spec.Store, box spec.GeoBox) map[string]bool {
+	t.Helper()
+	nodes, err := s.CoreNodesInBox(box)
+	must(t, err)
+	res := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		res[n.Address] = true
+	}
+	return res
+}
+
+func testCoreNodesInBox(t *testing.T, s spec.Store) {
+	now := time.Now().Unix()
+	sydney := spec.Location{Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", Source: "geoip:1", Found: true}
+	auckland := spec.Location{Lat: -36.8485, Lon: 174.7633, Country: "NZ", City: "Auckland", Source: "geoip:1", Found: true}
+	fiji := spec.Location{Lat: -17.7134, Lon: -178.065, Country: "FJ", City: "Lau", Source: "geoip:1", Found: true}
+	_, _, err := s.AddCoreNodes([]spec.CoreAddr{
+		{Address: Addr(1), Time: now, Services: 1, Location: sydney},
+		{Address: Addr(2), Time: now, Services: 1, Location: auckland},
+		{Address: Addr(3), Time: now, Services: 1, Location: fiji},
+		{Address: Addr(4), Time: now, Services: 1},                                             // not located
+		{Address: Addr(5), Time: now, Services: 1, Location: spec.Location{Source: "geoip:1"}}, // lookup failed
+	})
+	must(t, err)
+	a1, a2, a3 := Addr(1).String(), Addr(2).String(), Addr(3).String()
+	if got := boxAddrs(t, s, spec.GeoBox{MinLon: 150, MinLat: -35, MaxLon: 152, MaxLat: -33}); len(got) != 1 || !got[a1] {
+		t.Fatalf("CoreNodesInBox: expected only %v, got %v", a1, got)
+	}
+	// crossing the antimeridian.
+	if got := boxAddrs(t, s, spec.GeoBox{MinLon: 170, MinLat: -40, MaxLon: -170, MaxLat: -10}); len(got) != 2 || !got[a2] || !got[a3] {
+		t.Fatalf("CoreNodesInBox: expected %v and %v, got %v", a2, a3, got)
+	}
+	if got := boxAddrs(t, s, spec.GeoBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}); len(got) != 3 {
+		t.Fatalf("CoreNodesInBox: expected the 3 located nodes, got %v", got)
+	}
+	if got := boxAddrs(t, s, spec.GeoBox{MinLon: 0, MinLat: 0, MaxLon: 10, MaxLat: 10}); len(got) != 0 {
+		t.Fatalf("CoreNodesInBox: expected none, got %v", got)
+	}
+	// failed lookups are stored at 0,0 but are not located.
+	if got := boxAddrs(t, s, spec.GeoBox{MinLon: -1, MinLat: -1, MaxLon: 1, MaxLat: 1}); len(got) != 0 {
+		t.Fatalf("CoreNodesInBox: expected no unlocated nodes, got %v", got)
+	}
+
+	// moved and newly located nodes are re-indexed, and nodes that can
+	// no longer be located are removed.
+	berlin := spec.Location{Lat: 52.52, Lon: 13.405, Country: "DE", City: "Berlin", Source: "geoip:2", Found: true}
+	must(t, s.UpdateCoreLocations([]spec.CoreLocation{{Address: Addr(1), Location: berlin}, {Address: Addr(4), Location: berlin},
+		{Address: Addr(3), Location: spec.Location{Source: "geoip:2"}}}))
+	if got := boxAddrs(t, s, spec.GeoBox{MinLon: 170, MinLat: -40, MaxLon: -170, MaxLat: -10}); len(got) != 1 || !got[a2] {
+		t.Fatalf("CoreNodesInBox: expected only %v after %v failed to locate, got %v", a2, a3, got)
+	}
+	if got := boxAddrs(t, s, spec.GeoBox{MinLon: 150, MinLat: -35, MaxLon: 152, MaxLat: -33}); len(got) != 0 {
+		t.Fatalf("CoreNodesInBox: expected %v to have moved, got %v", a1, got)
+	}
+	if got := boxAddrs(t, s, spec.BoxAround(berlin.Lat, berlin.Lon, 10)); len(got) != 2 || !got[a1] || !got[Addr(4).String()] {
+		t.Fatalf("CoreNodesInBox: expected 2 nodes near Berlin, got %v", got)
+	}
+
+	// expired nodes are removed from the index.
+	fake := clock.NewFake(time.Now())
+	s = s.WithClock(fake)
+	for day := 0; day < 5; day++ {
+		fake.Advance(time.Duration(spec.SecondsPerDay) * time.Second)
+		_, _, _, err = s.TrimNodes(spec.DefaultRetention)
+		must(t, err)
+	}
+	if got := boxAddrs(t, s, spec.GeoBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}); len(got) != 0 {
+		t.Fatalf("CoreNodesInBox: expected expired nodes to be removed, got %v", got)
+	}
+}
+
+func testUpdateCoreTime(t *testing.T, s spec.Store) {
+	old := time.Now().Unix() - 3600
+	must(t, s.AddCoreNode(Addr(1), old, 1))
+	before := time.Now().Unix()
+	must(t, s.UpdateCoreTime(Addr(1)))
+	n := nodeMap(t, s)[Addr(1).String()]
+	if n.Time < before || n.Time > time.Now().Unix() {
+		t.Fatalf("UpdateCoreTime: expected time ~%d, got %d", before, n.Time)
+	}
+	if n.Services != 1 {
+		t.Fatalf("UpdateCoreTime changed services: %+v", n)
+	}
+	if !n.Reachable {
+		t.Fatalf("UpdateCoreTime: node should be reachable: %+v", n)
+	}
+}
+
+func testUpdateCoreProbe(t *testing.T, s spec.Store) {
+	must(t, s.AddCoreNode(Addr(1), time.Now().Unix(), 1))
+	probe := spec.CoreProbe{Caps: spec.CapSendHeaders | spec.CapHeaders, Skew: -42, RTT: 123, Version: 70015, Agent: "/Shibetoshi:1.14.7/"}
+	before := time.Now().Unix()
+	must(t, s.UpdateCoreProbe(Addr(1), probe))
+	n := nodeMap(t, s)[Addr(1).String()]
+	if n.Caps != probe.Caps || n.Skew != probe.Skew || n.RTT != probe.RTT || n.Version != probe.Version || n.Agent != probe.Agent {
+		t.Fatalf("UpdateCoreProbe: expected %+v, got %+v", probe, n)
+	}
+	if n.Probed < before {
+		t.Fatalf("UpdateCoreProbe: probed time not set: %+v", n)
+	}
+	// a later AddCoreNode (gossip) must not erase the probe.
+	must(t, s.AddCoreNode(Addr(1), time.Now().Unix(), 1))
+	if n := nodeMap(t, s)[Addr(1).String()]; n.Caps != probe.Caps {
+		t.Fatalf("AddCoreNode erased probe: %+v", n)
+	}
+}
+
+func testUnknownNodeUpdates(t *testing.T, s spec.Store) {
+	// updates to unknown nodes are ignored (the node may have been trimmed)
+	must(t, s.UpdateCoreTime(Addr(1)))
+	must(t, s.UpdateCoreProbe(Addr(1), spec.CoreProbe{Caps: 1}))
+	checkStats(t, s, 0, 0)
+}
+
+func testChooseCoreNode(t *testing.T, s spec.Store) {
+	now := time.Now().Unix()
+	added := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		must(t, s.AddCoreNode(Addr(i), now, 1))
+		added[Addr(i).String()] = true
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		addr, err := s.ChooseCoreNode()
+		must(t, err)
+		if !addr.IsValid() {
+			t.Fatalf("ChooseCoreNode: invalid address %v", addr)
+		}
+		if !added[addr.String()] {
+			t.Fatalf("ChooseCoreNode: unknown address %v", addr)
+		}
+		seen[addr.String()] = true
+	}
+	// random choice: 200 picks from 5 nodes should see them all.
+	if len(seen) != len(added) {
+		t.Fatalf("ChooseCoreNode: not random: saw %d of %d nodes", len(seen), len(added))
+	}
+}
+
+func testTrimNodes(t *testing.T, s spec.Store) {
+	fake := clock.NewFake(time.Now())
+	s = s.WithClock(fake)
+	day := time.Duration(spec.SecondsPerDay) * time.Second
+	now := fake.Now().Unix()
+	trim := func(expectAdvanced bool, expectRemoved int64) {
+		t.Helper()
+		advanced, remCore, _, err := s.TrimNodes(spec.DefaultRetention)
+		must(t, err)
+		if advanced != expectAdvanced || remCore != expectRemoved {
+			t.Fatalf("TrimNodes: expected advanced=%v removed=%d, got advanced=%v removed=%d",
+				expectAdvanced, expectRemoved, advanced, remCore)
+		}
+	}
+	must(t, s.AddCoreNode(Addr(1), now, 1))
+	must(t, s.AddCoreNode(Addr(2), now, 1))
+	trim(false, 0) // same day: no change
+	fake.Advance(day)
+	trim(true, 0)
+	trim(false, 0) // at most once per day
+	must(t, s.UpdateCoreTime(Addr(1)))
+	// offline for many days: the counter only advances by one,
+	// so nothing expires immediately.
+	fake.Advance(10 * day)
+	trim(true, 0)
+	checkStats(t, s, 2, 2)
+	fake.Advance(day)
+	trim(true, 1)
+	nodes := nodeMap(t, s)
+	if _, found := nodes[Addr(1).String()]; !found || len(nodes) != 1 {
+		t.Fatalf("TrimNodes: expected only %v to remain, got %v", Addr(1), nodes)
+	}
+	fake.Advance(day)
+	trim(true, 1)
+	checkStats(t, s, 0, 0)
+}
+
+func testTrimRetention(t *testing.T, s spec.Store) {
+	fake := clock.NewFake(time.Now())
+	s = s.WithClock(fake)
+	day := time.Duration(spec.SecondsPerDay) * time.Second
+	retain := spec.Retention{Gossiped: 1, Reachable: 3, Identity: 3, Archive: true}
+	trim := func(expectRemoved int64) {
+		t.Helper()
+		_, remCore, _, err := s.TrimNodes(retain)
+		must(t, err)
+		if remCore != expectRemoved {
+			t.Fatalf("TrimNodes: expected %d removed, got %d", expectRemoved, remCore)
+		}
+	}
+	now := fake.Now().Unix()
+	must(t, s.AddCoreNode(Addr(1), now, 1)) // gossiped only
+	must(t, s.AddCoreNode(Addr(2), now, 1))
+	must(t, s.UpdateCoreTime(Addr(2))) // reachable
+	fake.Advance(day)
+	trim(0)
+	fake.Advance(day)
+	trim(1) // gossiped: kept for 1 day
+	if _, found := nodeMap(t, s)[Addr(2).String()]; !found {
+		t.Fatalf("TrimNodes: expired a reachable node early")
+	}
+	fake.Advance(day)
+	trim(0)
+	fake.Advance(day)
+	trim(1) // reachable: kept for 3 days
+	checkStats(t, s, 0, 0)
+}
+
+// Key returns a distinct test pubkey NodeID for each n.
+func Key(n int) spec.NodeID {
+	var key [32]byte
+	key[0], key[1] = byte(n>>8), byte(n)
+	return spec.NodeIDFromKey(&key)
+}
+
+func history(t *testing.T, s spec.Store, id spec.NodeID) spec.NodeHistory {
+	t.Helper()
+	h, err := s.NodeHistory(id)
+	must(t, err)
+	if h.Node != id.String() {
+		t.Fatalf("NodeHistory: expected node %v, got %v", id, h.Node)
+	}
+	return h
+}
+
+func testCoreHistory(t *testing.T, s spec.Store) {
+	fake := clock.NewFake(time.Now())
+	s = s.WithClock(fake)
+	day := time.Duration(spec.SecondsPerDay) * time.Second
+	id := spec.NodeIDFromAddress(Addr(1))
+	_, err := s.NodeHistory(id)
+	CheckErr(t, err, spec.NotFound)
+
+	start := fake.Now().Unix()
+	must(t, s.AddCoreNode(Addr(1), start, 1))
+	fake.Advance(day)
+	must(t, s.AddCoreNode(Addr(1), fake.Now().Unix(), 1))
+	h := history(t, s, id)
+	if len(h.Intervals) != 1 || !h.Present || h.FirstSeen != start || h.LastSeen != fake.Now().Unix() {
+		t.Fatalf("NodeHistory: expected one open interval, got %+v", h)
+	}
+
+	// expire the node: the interval is closed.
+	for i := 0; i < spec.MaxCoreNodeDays+1; i++ {
+		fake.Advance(day)
+		_, _, _, err := s.TrimNodes(spec.DefaultRetention)
+		must(t, err)
+	}
+	gone := fake.Now().Unix()
+	h = history(t, s, id)
+	if len(h.Intervals) != 1 || h.Present || h.Intervals[0].Gone != gone {
+		t.Fatalf("NodeHistory: expected a closed interval, got %+v", h)
+	}
+
+	// seen again: a new interval.
+	fake.Advance(day)
+	must(t, s.AddCoreNode(Addr(1), fake.Now().Unix(), 1))
+	h = history(t, s, id)
+	if len(h.Intervals) != 2 || !h.Present || h.FirstSeen != start || h.Intervals[1].First != fake.Now().Unix() {
+		t.Fatalf("NodeHistory: expected a second interval, got %+v", h)
+	}
+}
+
+// Net returns a distinct test dogenet node for each n (see Key)
+func Net(n int) spec.NetNode {
+	id := Key(n)
+	return spec.NetNode{
+		PubKey:   hex.EncodeToString(id[1:]),
+		Address:  Addr(n).String(),
+		Time:     time.Now().Unix(),
+		Channels: []string{"Core", "Iden"},
+		Identity: "",
+	}
+}
+
+func netNodeMap(t *testing.T, s spec.Store) map[string]spec.NetNode {
+	t.Helper()
+	nodes, err := s.NetNodeList()
+	must(t, err)
+	res := make(map[string]spec.NetNode, len(nodes))
+	for _, n := range nodes {
+		if _, dup := res[n.PubKey]; dup {
+			t.Fatalf("NetNodeList: duplicate node %v", n.PubKey)
+		}
+		res[n.PubKey] = n
+	}
+	if count, err := s.NetNodeCount(); err != nil || count != len(nodes) {
+		t.Fatalf("NetNodeCount: expected %d, got %d (%v)", len(nodes), count, err)
+	}
+	return res
+}
+
+func testAddNetNodes(t *testing.T, s spec.Store) {
+	fake := clock.NewFake(time.Now())
+	s = s.WithClock(fake)
+	n1, n2 := Net(1), Net(2)
+	n2.Channels = nil
+	added, updated, err := s.AddNetNodes([]spec.NetNode{n1, n2})
+	must(t, err)
+	if added != 2 || updated != 0 {
+		t.Fatalf("AddNetNodes: expected 2 added, got %d added %d updated", added, updated)
+	}
+	nodes := netNodeMap(t, s)
+	got := nodes[n1.PubKey]
+	if len(nodes) != 2 || got.Address != n1.Address || got.Time != n1.Time || got.Seen != fake.Now().Unix() ||
+		len(got.Channels) != 2 || got.Channels[0] != "Core" || got.Channels[1] != "Iden" {
+		t.Fatalf("NetNodeList: expected %+v, got %+v", n1, got)
+	}
+	if got := nodes[n2.PubKey]; got.Channels == nil || len(got.Channels) != 0 {
+		t.Fatalf("NetNodeList: expected empty channels, got %#v", got.Channels)
+	}
+	if got.Located || got.LocSource != "" {
+		t.Fatalf("NetNodeList: expected no location, got %+v", got)
+	}
+
+	// updates replace every field.
+	fake.Advance(time.Hour)
+	n1.Address = Addr(3).String()
+	n1.Identity = hex.EncodeToString(make([]byte, 32))
+	n1.Channels = []string{"Shop"}
+	n1.SetLocation(spec.Location{Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", Source: spec.IdentityLocSource, Found: true})
+	added, updated, err = s.AddNetNodes([]spec.NetNode{n1})
+	must(t, err)
+	if added != 0 || updated != 1 {
+		t.Fatalf("AddNetNodes: expected 1 updated, got %d added %d updated", added, updated)
+	}
+	got = netNodeMap(t, s)[n1.PubKey]
+	if got.Address != n1.Address || got.Identity != n1.Identity || got.Seen != fake.Now().Unix() ||
+		len(got.Channels) != 1 || got.Channels[0] != "Shop" {
+		t.Fatalf("NetNodeList: expected %+v, got %+v", n1, got)
+	}
+	if got.Lat != n1.Lat || got.Lon != n1.Lon || got.Country != "AU" || got.City != "Sydney" ||
+		got.LocSource != spec.IdentityLocSource || !got.Located {
+		t.Fatalf("NetNodeList: expected the stored location, got %+v", got)
+	}
+
+	// invalid pubkeys are rejected.
+	bad := Net(4)
+	bad.PubKey = "abcd"
+	_, _, err = s.AddNetNodes([]spec.NetNode{bad})
+	CheckErr(t, err, spec.DBProblem)
+}
+
+func testNetHistory(t *testing.T, s spec.Store) {
+	fake := clock.NewFake(time.Now())
+	s = s.WithClock(fake)
+	day := time.Duration(spec.SecondsPerDay) * time.Second
+	retain := spec.Retention{Gossiped: 5, Reachable: 5, Identity: 1}
+	trim := func(expectRemoved int64) {
+		t.Helper()
+		_, _, remNet, err := s.TrimNodes(retain)
+		must(t, err)
+		if remNet != expectRemoved {
+			t.Fatalf("TrimNodes: expected %d dogenet nodes removed, got %d", expectRemoved, remNet)
+		}
+	}
+	start := fake.Now().Unix()
+	must(t, s.AddCoreNode(Addr(1), start, 1))
+	_, _, err := s.AddNetNodes([]spec.NetNode{Net(1), Net(2)})
+	must(t, err)
+	fake.Advance(day)
+	trim(0)
+	_, _, err = s.AddNetNodes([]spec.NetNode{Net(1)})
+	must(t, err)
+	h := history(t, s, Key(1))
+	if len(h.Intervals) != 1 || !h.Present || h.FirstSeen != start || h.LastSeen != fake.Now().Unix() {
+		t.Fatalf("NodeHistory: expected %v present, got %+v", Key(1), h)
+	}
+	// missing from dogenet: kept until it expires.
+	if h := history(t, s, Key(2)); !h.Present {
+		t.Fatalf("NodeHistory: expected %v present, got %+v", Key(2), h)
+	}
+	fake.Advance(day)
+	trim(1) // identity: kept for 1 day
+	h = history(t, s, Key(2))
+	if h.Present || h.LastSeen != start || h.Intervals[0].Gone != fake.Now().Unix() {
+		t.Fatalf("NodeHistory: expected %v gone, got %+v", Key(2), h)
+	}
+	nodes := netNodeMap(t, s)
+	if _, found := nodes[Net(1).PubKey]; !found || len(nodes) != 1 {
+		t.Fatalf("TrimNodes: expected only %v to remain, got %v", Key(1), nodes)
+	}
+	// core nodes are not affected by dogenet expiry.
+	if h := history(t, s, spec.NodeIDFromAddress(Addr(1))); !h.Present {
+		t.Fatalf("TrimNodes: closed a core node interval: %+v", h)
+	}
+	fake.Advance(day)
+	trim(1)
+	if h := history(t, s, Key(1)); h.Present {
+		t.Fatalf("NodeHistory: expected %v gone, got %+v", Key(1), h)
+	}
+}
+
+func linkSet(t *testing.T, s spec.Store) map[spec.NodeLink]bool {
+	t.Helper()
+	links, err := s.NodeLinks()
+	must(t, err)
+	res := make(map[spec.NodeLink]bool, len(links))
+	for _, l := range links {
+		l.Seen = 0
+		res[l] = true
+	}
+	return res
+}
+
+func testNodeLinks(t *testing.T, s spec.Store) {
+	fake := clock.NewFake(time.Now())
+	s = s.WithClock(fake)
+	day := time.Duration(spec.SecondsPerDay) * time.Second
+	now := fake.Now().Unix()
+	core1, core2 := spec.NodeIDFromAddress(Addr(1)), spec.NodeIDFromAddress(Addr(2))
+	otherPort := Addr(1)
+	otherPort.Port = 1
+	core1b := spec.NodeIDFromAddress(otherPort)
+	for _, id := range []spec.NodeID{Key(1), core1} {
+		_, err := s.NodeInfo(id)
+		CheckErr(t, err, spec.NotFound)
+	}
+	must(t, s.AddCoreNode(Addr(1), now, 1))
+	must(t, s.AddCoreNode(otherPort, now, 1))
+	must(t, s.AddCoreNode(Addr(2), now, 1))
+
+	// a dogebox is linked to every core node on its host.
+	_, _, err := s.AddNetNodes([]spec.NetNode{Net(1)})
+	must(t, err)
+	links := linkSet(t, s)
+	if len(links) != 2 || !links[spec.NodeLink{Node: Key(1), Core: core1}] || !links[spec.NodeLink{Node: Key(1), Core: core1b}] {
+		t.Fatalf("NodeLinks: expected %v linked to %v and %v, got %v", Key(1), core1, core1b, links)
+	}
+	info, err := s.NodeInfo(Key(1))
+	must(t, err)
+	if info.ID != Key(1).String() || info.Net == nil || info.Core != nil || info.Net.PubKey != Net(1).PubKey || len(info.Linked) != 2 {
+		t.Fatalf("NodeInfo: unexpected dogebox: %+v", info)
+	}
+	info, err = s.NodeInfo(core1)
+	must(t, err)
+	if info.ID != core1.String() || info.Core == nil || info.Net != nil || info.Core.Address != Addr(1).String() ||
+		len(info.Linked) != 1 || info.Linked[0] != Key(1).String() {
+		t.Fatalf("NodeInfo: unexpected core node: %+v", info)
+	}
+	info, err = s.NodeInfo(core2)
+	must(t, err)
+	if info.Linked == nil || len(info.Linked) != 0 {
+		t.Fatalf("NodeInfo: expected no links, got %#v", info.Linked)
+	}
+
+	// the dogebox changes address: still one node, now linked to both hosts.
+	moved := Net(1)
+	moved.Address = Addr(2).String()
+	_, _, err = s.AddNetNodes([]spec.NetNode{moved})
+	must(t, err)
+	if links := linkSet(t, s); len(links) != 3 || !links[spec.NodeLink{Node: Key(1), Core: core2}] {
+		t.Fatalf("NodeLinks: expected %v linked to %v, got %v", Key(1), core2, links)
+	}
+	if nodes := netNodeMap(t, s); len(nodes) != 1 || nodes[moved.PubKey].Address != moved.Address {
+		t.Fatalf("NetNodeList: expected one moved node, got %v", nodes)
+	}
+
+	// links are removed when the core nodes expire.
+	for i := 0; i < spec.MaxCoreNodeDays+2; i++ {
+		fake.Advance(day)
+		_, _, err = s.AddNetNodes([]spec.NetNode{moved})
+		must(t, err)
+		_, _, _, err = s.TrimNodes(spec.DefaultRetention)
+		must(t, err)
+	}
+	if links := linkSet(t, s); len(links) != 0 {
+		t.Fatalf("TrimNodes: expected no links, got %v", links)
+	}
+}
+
+func testNetNodesInBox(t *testing.T, s spec.Store) {
+	now := time.Now().Unix()
+	sydney := spec.Location{Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", Source: "geoip:1", Found: true}
+	fiji := spec.Location{Lat: -17.7134, Lon: -178.065, Country: "FJ", City: "Lau", Source: "geoip:1", Found: true}
+	berlin := spec.Location{Lat: 52.52, Lon: 13.405, Country: "DE", City: "Berlin", Source: spec.IdentityLocSource, Found: true}
+	auckland := spec.Location{Lat: -36.8485, Lon: 174.7633, Country: "NZ", City: "Auckland", Source: "geoip:1", Found: true}
+	_, _, err := s.AddCoreNodes([]spec.CoreAddr{
+		{Address: Addr(1), Time: now, Services: 1, Location: sydney},
+		{Address: Addr(2), Time: now, Services: 1, Location: fiji},
+		{Address: Addr(3), Time: now, Services: 1, Location: sydney},
+	})
+	must(t, err)
+	// each dogebox is linked to the core node on its host.
+	n1, n2, n3 := Net(1), Net(2), Net(3)
+	n1.SetLocation(berlin)
+	n2.SetLocation(auckland)
+	n3.SetLocation(spec.Location{Source: "geoip:1"}) // lookup failed
+	_, _, err = s.AddNetNodes([]spec.NetNode{n1, n2, n3})
+	must(t, err)
+	core1, core2 := spec.NodeIDFromAddress(Addr(1)), spec.NodeIDFromAddress(Addr(2))
+
+	inBox := func(box spec.GeoBox) (map[string]bool, map[spec.NodeLink]bool) {
+		t.Helper()
+		nodes, err := s.NetNodesInBox(box)
+		must(t, err)
+		keys := make(map[string]bool, len(nodes))
+		for _, n := range nodes {
+			keys[n.PubKey] = true
+		}
+		links, err := s.NodeLinksInBox(box)
+		must(t, err)
+		set := make(map[spec.NodeLink]bool, len(links))
+		for _, l := range links {
+			l.Seen = 0
+			if set[l] {
+				t.Fatalf("NodeLinksInBox: duplicate link %v", l)
+			}
+			set[l] = true
+		}
+		return keys, set
+	}
+	world := spec.GeoBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}
+	if nodes, links := inBox(world); len(nodes) != 2 || !nodes[n1.PubKey] || !nodes[n2.PubKey] ||
+		len(links) != 2 || !links[spec.NodeLink{Node: Key(1), Core: core1}] || !links[spec.NodeLink{Node: Key(2), Core: core2}] {
+		t.Fatalf("expected the 2 located dogeboxes and their links, got %v %v", nodes, links)
+	}
+	// a core node in the box brings its link to a dogebox outside it.
+	if nodes, links := inBox(spec.BoxAround(sydney.Lat, sydney.Lon, 10)); len(nodes) != 0 ||
+		len(links) != 1 || !links[spec.NodeLink{Node: Key(1), Core: core1}] {
+		t.Fatalf("Sydney: expected only the link to %v, got %v %v", Key(1), nodes, links)
+	}
+	if nodes, links := inBox(spec.BoxAround(berlin.Lat, berlin.Lon, 10)); len(nodes) != 1 || !nodes[n1.PubKey] ||
+		len(links) != 1 || !links[spec.NodeLink{Node: Key(1), Core: core1}] {
+		t.Fatalf("Berlin: expected %v and its link, got %v %v", Key(1), nodes, links)
+	}
+	// crossing the antimeridian: the dogebox and its core node are in
+	// different halves, and the link is returned once.
+	pacific := spec.GeoBox{MinLon: 170, MinLat: -40, MaxLon: -170, MaxLat: -10}
+	if nodes, links := inBox(pacific); len(nodes) != 1 || !nodes[n2.PubKey] ||
+		len(links) != 1 || !links[spec.NodeLink{Node: Key(2), Core: core2}] {
+		t.Fatalf("Pacific: expected %v and its link, got %v %v", Key(2), nodes, links)
+	}
+
+	// a dogebox that can no longer be located is removed, with its links.
+	n2.SetLocation(spec.Location{Source: "geoip:2"})
+	_, _, err = s.AddNetNodes([]spec.NetNode{n2})
+	must(t, err)
+	if nodes, links := inBox(pacific); len(nodes) != 0 || len(links) != 0 {
+		t.Fatalf("Pacific: expected nothing after %v failed to locate, got %v %v", Key(2), nodes, links)
+	}
+	if nodes, _ := inBox(spec.GeoBox{MinLon: -1, MinLat: -1, MaxLon: 1, MaxLat: 1}); len(nodes) != 0 {
+		t.Fatalf("expected no unlocated dogeboxes at 0,0, got %v", nodes)
+	}
+}
+
+func testImport(t *testing.T, s spec.Store) {
+	core := spec.CoreNode{
+		Address: Addr(1).String(), Time: 1700000000, Services: 1<<10 | 5, Caps: 3, Skew: -7, RTT: 120, Probed: 1700000100,
+		Version: 70015, Agent: "/Shibetoshi:1.14.9/", Reachable: true,
+		Lat: -33.5, Lon: 151.25, Country: "AU", City: "Sydney", LocSource: "test", Located: true,
+	}
+	net := Net(1)
+	net.Identity = hex.EncodeToString(make([]byte, 32))
+	net.Seen = 1700000200
+	must(t, s.ImportCoreNodes([]spec.CoreNode{core}))
+	must(t, s.ImportNetNodes([]spec.NetNode{net}))
+
+	// every field round-trips.
+	if got := nodeMap(t, s)[core.Address]; got != core {
+		t.Fatalf("ImportCoreNodes: expected %+v, got %+v", core, got)
+	}
+	got := netNodeMap(t, s)[net.PubKey]
+	if got.Address != net.Address || got.Time != net.Time || got.Identity != net.Identity || got.Seen != net.Seen || len(got.Channels) != 2 {
+		t.Fatalf("ImportNetNodes: expected %+v, got %+v", net, got)
+	}
+	checkStats(t, s, 1, 1)
+	if links := linkSet(t, s); len(links) != 1 {
+		t.Fatalf("ImportNetNodes: expected a link, got %v", links)
+	}
+	// import is not a sighting.
+	_, err := s.NodeHistory(Key(1))
+	CheckErr(t, err, spec.NotFound)
+
+	// importing again replaces the nodes.
+	core.Reachable = false
+	core.Agent = "/Other:1.0/"
+	must(t, s.ImportCoreNodes([]spec.CoreNode{core}))
+	if got := nodeMap(t, s)[core.Address]; got != core {
+		t.Fatalf("ImportCoreNodes: expected %+v, got %+v", core, got)
+	}
+	checkStats(t, s, 1, 1)
+
+	bad := core
+	bad.Address = "nowhere"
+	CheckErr(t, s.ImportCoreNodes([]spec.CoreNode{bad}), spec.DBProblem)
+}
+
+func identityMap(t *testing.T, s spec.Store) map[string]spec.IdentityProfile {
+	t.Helper()
+	profiles, err := s.IdentityProfiles()
+	must(t, err)
+	res := make(map[string]spec.IdentityProfile, len(profiles))
+	for _, p := range profiles {
+		res[p.Identity] = p
+	}
+	return res
+}
+
+func testIdentities(t *testing.T, s spec.Store) {
+	fake := clock.NewFake(time.Now())
+	s = s.WithClock(fake)
+	alice := spec.IdentityProfile{Identity: Net(1).PubKey, Lat: "-33.5", Lon: "151.25", Country: "AU", City: "Sydney",
+		Profile: json.RawMessage(`{"name":"Alice","lat":"-33.5","lon":"151.25","country":"AU","city":"Sydney"}`)}
+	bob := spec.IdentityProfile{Identity: Net(2).PubKey, Lat: "40.7", Lon: "-73.9",
+		Profile: json.RawMessage(`{"name":"Bob","lat":"40.7","lon":"-73.9"}`)}
+	start := fake.Now().Unix()
+	changed, err := s.UpdateIdentities([]spec.IdentityProfile{alice, bob})
+	must(t, err)
+	if changed != 2 {
+		t.Fatalf("UpdateIdentities: expected 2 changed, got %d", changed)
+	}
+	got := identityMap(t, s)[alice.Identity]
+	if got.Lat != alice.Lat || got.Lon != alice.Lon || got.Country != alice.Country || got.City != alice.City ||
+		string(got.Profile) != string(alice.Profile) || got.Fetched != start || got.Changed != start {
+		t.Fatalf("IdentityProfiles: expected %+v, got %+v", alice, got)
+	}
+
+	// fetching an unchanged profile only updates Fetched.
+	fake.Advance(time.Hour)
+	changed, err = s.UpdateIdentities([]spec.IdentityProfile{alice})
+	must(t, err)
+	if changed != 0 {
+		t.Fatalf("UpdateIdentities: expected 0 changed, got %d", changed)
+	}
+	got = identityMap(t, s)[alice.Identity]
+	if got.Fetched != fake.Now().Unix() || got.Changed != start {
+		t.Fatalf("UpdateIdentities: expected fetched %d changed %d, got %+v", fake.Now().Unix(), start, got)
+	}
+
+	// a changed profile is recorded in the history.
+	fake.Advance(time.Hour)
+	moved := alice
+	moved.City, moved.Profile = "Melbourne", json.RawMessage(`{"name":"Alice","city":"Melbourne"}`)
+	changed, err = s.UpdateIdentities([]spec.IdentityProfile{moved})
+	must(t, err)
+	if changed != 1 {
+		t.Fatalf("UpdateIdentities: expected 1 changed, got %d", changed)
+	}
+	if got := identityMap(t, s)[alice.Identity]; got.City != "Melbourne" || got.Changed != fake.Now().Unix() {
+		t.Fatalf("UpdateIdentities: expected the new profile, got %+v", got)
+	}
+	hist, err := s.IdentityHistory(alice.Identity)
+	must(t, err)
+	if len(hist) != 2 || hist[0].Time != start || string(hist[0].Profile) != string(alice.Profile) ||
+		hist[1].Time != fake.Now().Unix() || string(hist[1].Profile) != string(moved.Profile) {
+		t.Fatalf("IdentityHistory: unexpected history: %+v", hist)
+	}
+	_, err = s.IdentityHistory(Net(3).PubKey)
+	CheckErr(t, err, spec.NotFound)
+
+	// profiles not fetched since `before` are removed, and the removal
+	// is recorded in the history.
+	removed, err := s.TrimIdentities(start + 1)
+	must(t, err)
+	if removed != 1 {
+		t.Fatalf("TrimIdentities: expected 1 removed, got %d", removed)
+	}
+	if profiles := identityMap(t, s); len(profiles) != 1 || profiles[bob.Identity].Identity != "" {
+		t.Fatalf("TrimIdentities: expected only %v to remain, got %v", alice.Identity, profiles)
+	}
+	hist, err = s.IdentityHistory(bob.Identity)
+	must(t, err)
+	if len(hist) != 2 || hist[0].Time != start || string(hist[0].Profile) != string(bob.Profile) ||
+		hist[1].Time != fake.Now().Unix() || hist[1].Profile != nil {
+		t.Fatalf("TrimIdentities: expected the removal in the history, got %+v", hist)
+	}
+
+	// the history of removed identities is trimmed in turn.
+	removedAt := fake.Now().Unix()
+	fake.Advance(time.Hour)
+	removed, err = s.TrimIdentities(removedAt + 1)
+	must(t, err)
+	if removed != 1 {
+		t.Fatalf("TrimIdentities: expected 1 removed, got %d", removed)
+	}
+	_, err = s.IdentityHistory(bob.Identity)
+	CheckErr(t, err, spec.NotFound)
+	hist, err = s.IdentityHistory(alice.Identity)
+	must(t, err)
+	if len(hist) != 3 || hist[2].Profile != nil {
+		t.Fatalf("TrimIdentities: expected alice's history to be kept, got %+v", hist)
+	}
+}
+
+func searchIDs(t *testing.T, s spec.Store, query string) []string {
+	t.Helper()
+	hits, err := s.Search(query, 10)
+	must(t, err)
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.Kind+":"+hit.ID)
+	}
+	return ids
+}
+
+func testSearch(t *testing.T, s spec.Store) {
+	// nothing indexed yet.
+	if ids := searchIDs(t, s, "sydney"); len(ids) != 0 {
+		t.Fatalf("Search: expected no results before indexing, got %v", ids)
+	}
+	core := []spec.CoreNode{
+		{Address: Addr(1).String(), Agent: "/Shibetoshi:1.14.9/", City: "Sydney", Country: "AU"},
+		{Address: Addr(2).String(), Agent: "/Shibetoshi:1.14.6/", City: "Sydneyville", Country: "US"},
+	}
+	alice := spec.IdentityProfile{Identity: Net(3).PubKey, City: "Sydney", Country: "AU",
+		Profile: json.RawMessage(`{"name":"Alice Smith"}`)}
+	box := Net(1)
+	box.Identity = alice.Identity
+	must(t, s.SetSearchIndex(spec.MakeSearchDocs(core, []spec.NetNode{box}, []spec.IdentityProfile{alice})))
+	coreID := func(n int) string { return spec.SearchCore + ":" + spec.NodeIDFromAddress(Addr(n)).String() }
+	boxKey, err := box.NodeID()
+	must(t, err)
+	boxID := spec.SearchNet + ":" + boxKey.String()
+	aliceID := spec.SearchIdentity + ":" + alice.Identity
+
+	// whole-token matches rank above prefixes; every term must match.
+	ids := searchIDs(t, s, "Sydney")
+	if len(ids) != 4 || ids[len(ids)-1] != coreID(2) {
+		t.Fatalf("Search: expected 4 results with %v last, got %v", coreID(2), ids)
+	}
+	if ids := searchIDs(t, s, "shibetoshi 1.14.9"); len(ids) != 1 || ids[0] != coreID(1) {
+		t.Fatalf("Search: expected %v, got %v", coreID(1), ids)
+	}
+	if ids := searchIDs(t, s, "ALI smi"); len(ids) != 2 || ids[0] != aliceID || ids[1] != boxID {
+		t.Fatalf("Search: expected %v and its DogeBox, got %v", aliceID, ids)
+	}
+	if ids := searchIDs(t, s, alice.Identity[:8]); len(ids) != 2 {
+		t.Fatalf("Search: expected the identity and its DogeBox by pubkey prefix, got %v", ids)
+	}
+	if ids := searchIDs(t, s, "sydney nowhere"); len(ids) != 0 {
+		t.Fatalf("Search: expected no results, got %v", ids)
+	}
+	if ids := searchIDs(t, s, " .:/ "); len(ids) != 0 {
+		t.Fatalf("Search: expected no results without terms, got %v", ids)
+	}
+	if hits, err := s.Search("sydney", 2); err != nil || len(hits) != 2 {
+		t.Fatalf("Search: expected the limit to apply, got %v %v", hits, err)
+	}
+
+	// countries match by code and name.
+	if ids := searchIDs(t, s, "austral"); len(ids) != 3 {
+		t.Fatalf("Search: expected 3 results in Australia, got %v", ids)
+	}
+	if ids := searchIDs(t, s, "united states"); len(ids) != 1 || ids[0] != coreID(2) {
+		t.Fatalf("Search: expected %v, got %v", coreID(2), ids)
+	}
+
+	// the index is replaced: changed documents are updated and missing
+	// documents removed.
+	core[1].City = "Melbourne"
+	must(t, s.SetSearchIndex(spec.MakeSearchDocs(core[1:], nil, nil)))
+	if ids := searchIDs(t, s, "sydney"); len(ids) != 0 {
+		t.Fatalf("Search: expected no results after re-indexing, got %v", ids)
+	}
+	if ids := searchIDs(t, s, "melb"); len(ids) != 1 || ids[0] != coreID(2) {
+		t.Fatalf("Search: expected only %v after re-indexing, got %v", coreID(2), ids)
+	}
+
+	// every match is ranked, however many there are.
+	docs := make([]spec.SearchDoc, 0, 2001)
+	for i := 0; i < 2000; i++ {
+		docs = append(docs, spec.SearchDoc{ID: fmt.Sprintf("%04d", i), Kind: spec.SearchCore, Text: "sydneyville"})
+	}
+	docs = append(docs, spec.SearchDoc{ID: "best", Kind: spec.SearchCore, Text: "sydney"})
+	must(t, s.SetSearchIndex(docs))
+	if hits, err := s.Search("sydney", 1); err != nil || len(hits) != 1 || hits[0].ID != "best" {
+		t.Fatalf("Search: expected the whole-token match first, got %v %v", hits, err)
+	}
+}
+
+func testCensus(t *testing.T, s spec.Store) {
+	count := func(dim, label string, n int64) spec.CensusCount {
+		return spec.CensusCount{Dimension: dim, Label: label, Count: n}
+	}
+	must(t, s.AddCensus("hour", 3600, []spec.CensusCount{count(spec.CensusTotal, "", 5), count(spec.CensusCountry, "US", 3), count(spec.CensusCountry, "AU", 2)}))
+	must(t, s.AddCensus("hour", 7200, []spec.CensusCount{count(spec.CensusTotal, "", 9)}))
+	// replaces the previous snapshot for the same interval.
+	must(t, s.AddCensus("hour", 7200, []spec.CensusCount{count(spec.CensusTotal, "", 7)}))
+	must(t, s.AddCensus("day", 0, []spec.CensusCount{count(spec.CensusTotal, "", 1)}))
+
+	res, err := s.CensusSeries("hour", spec.CensusTotal, 0, 7200)
+	must(t, err)
+	if len(res) != 2 || res[0].Time != 3600 || res[0].Count != 5 || res[1].Time != 7200 || res[1].Count != 7 {
+		t.Fatalf("CensusSeries: unexpected totals: %+v", res)
+	}
+	res, err = s.CensusSeries("hour", spec.CensusCountry, 0, 3600)
+	must(t, err)
+	if len(res) != 2 || res[0].Label != "AU" || res[0].Count != 2 || res[1].Label != "US" || res[1].Count != 3 {
+		t.Fatalf("CensusSeries: unexpected countries: %+v", res)
+	}
+	res, err = s.CensusSeries("hour", spec.CensusTotal, 4000, 8000)
+	must(t, err)
+	if len(res) != 1 || res[0].Time != 7200 {
+		t.Fatalf("CensusSeries: range not applied: %+v", res)
+	}
+	res, err = s.CensusSeries("day", spec.CensusTotal, 0, 0)
+	must(t, err)
+	if len(res) != 1 || res[0].Count != 1 {
+		t.Fatalf("CensusSeries: unexpected daily totals: %+v", res)
+	}
+	if err := s.AddCensus("week", 0, nil); err == nil {
+		t.Fatalf("AddCensus: expected an error for an unknown interval")
+	}
+}
+
+func testCancelledContext(t *testing.T, s spec.Store) {
+	must(t, s.AddCoreNode(Addr(1), time.Now().Unix(), 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cs := s.WithCtx(ctx)
+	check := func(name string, err error) {
+		t.Helper()
+		if err == nil {
+			t.Fatalf("%s: expected an error with a cancelled context", name)
+		}
+		var info *spec.ErrorInfo
+		if !errors.As(err, &info) {
+			t.Fatalf("%s: expected a spec.ErrorInfo, got: %v", name, err)
+		}
+	}
+	_, _, err := cs.CoreStats()
+	check("CoreStats", err)
+	_, err = cs.NodeList()
+	check("NodeList", err)
+	_, err = cs.ChooseCoreNode()
+	check("ChooseCoreNode", err)
+	check("AddCoreNode", cs.AddCoreNode(Addr(2), time.Now().Unix(), 1))
+	_, err = cs.NodeHistory(spec.NodeIDFromAddress(Addr(1)))
+	check("NodeHistory", err)
+	_, _, err = cs.AddNetNodes([]spec.NetNode{Net(1)})
+	check("AddNetNodes", err)
+	_, err = cs.NetNodeList()
+	check("NetNodeList", err)
+	_, err = cs.NodeInfo(spec.NodeIDFromAddress(Addr(1)))
+	check("NodeInfo", err)
+	_, err = cs.IdentityProfiles()
+	check("IdentityProfiles", err)
+	_, err = cs.UpdateIdentities(nil)
+	check("UpdateIdentities", err)
+	_, err = cs.CoreNodesInBox(spec.GeoBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90})
+	check("CoreNodesInBox", err)
+	_, err = cs.NetNodesInBox(spec.GeoBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90})
+	check("NetNodesInBox", err)
+	_, err = cs.NodeLinksInBox(spec.GeoBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90})
+	check("NodeLinksInBox", err)
+	check("SetSearchIndex", cs.SetSearchIndex(nil))
+	_, err = cs.Search("node", 10)
+	check("Search", err)
+	// the original store is unaffected.
+	checkStats(t, s, 1, 1)
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.dogecoin.org/governor"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+)
+
+// Copying the database file while DogeMap is running can produce a
+// corrupt copy: recent commits live in the WAL file until checkpoint.
+// Backups use VACUUM INTO instead, which writes a consistent snapshot
+// from a read transaction, so crawlers keep running during a backup.
+
+// Backuper is implemented by stores that can write an online backup
+// (SQLiteStore; use pg_dump for PostgreSQL)
+type Backuper interface {
+	Backup(toFile string) error
+}
+
+var _ Backuper = &SQLiteStore{}
+
+// Backup writes a consistent snapshot of the database to `toFile`,
+// replacing it if it exists.
+func (s SQLiteStore) Backup(toFile string) error {
+	return vacuumInto(s.ctx, s.rdb, toFile)
+}
+
+// BackupSQLite writes a snapshot of the SQLite database `fileName` to
+// `toFile`. It is safe to run while DogeMap is using the database.
+func BackupSQLite(fileName string, toFile string) error {
+	if _, err := os.Stat(fileName); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", sqliteDSN(fileName, true))
+	if err != nil {
+		return dbErr(err, "opening database")
+	}
+	defer db.Close()
+	return vacuumInto(context.Background(), db, toFile)
+}
+
+// vacuumInto writes a snapshot to a temporary file, then renames it to
+// `toFile`, so `toFile` is never a partial backup.
+func vacuumInto(ctx context.Context, db *sql.DB, toFile string) error {
+	tmp := toFile + ".tmp"
+	os.Remove(tmp) // VACUUM INTO requires a new file
+	_, err := db.ExecContext(ctx, "VACUUM INTO ?", tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return dbErr(err, "backup")
+	}
+	if err := os.Rename(tmp, toFile); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+// RestoreSQLite replaces the SQLite database `fileName` with the backup
+// `fromFile`, after checking the backup's integrity. The existing
+// database (if any) is first saved as `fileName`.pre-restore.
+// The restored database is migrated and its spatial index rebuilt.
+// DogeMap MUST NOT be running: the restored database is not visible
+// to open connections, and their writes would be lost.
+func RestoreSQLite(fromFile string, fileName string) error {
+	if err := checkBackup(fromFile); err != nil {
+		return err
+	}
+	if _, err := os.Stat(fileName); err == nil {
+		if err := BackupSQLite(fileName, fileName+".pre-restore"); err != nil {
+			return fmt.Errorf("restore: saving the current database: %w", err)
+		}
+	}
+	// copy alongside the database, then rename into place.
+	tmp := fileName + ".restore"
+	if err := copyFile(fromFile, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("restore: %w", err)
+	}
+	// a WAL left by the old database must not be applied to the new one.
+	for _, ext := range []string{"-wal", "-shm"} {
+		if err := os.Remove(fileName + ext); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmp)
+			return fmt.Errorf("restore: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, fileName); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("restore: %w", err)
+	}
+	// VACUUM INTO can renumber core rowids, which key the spatial index.
+	db, err := NewSQLiteStore(fileName, context.Background())
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	defer db.(*SQLiteStore).Close()
+	if err := db.(*SQLiteStore).reindexGeo(); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	return nil
+}
+
+// checkBackup verifies that `fileName` is an intact DogeMap database.
+func checkBackup(fileName string) error {
+	if _, err := os.Stat(fileName); err != nil {
+		return err
+	}
+	path := (&url.URL{Path: fileName}).EscapedPath()
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return dbErr(err, "opening backup")
+	}
+	defer db.Close()
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("backup is not a SQLite database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup is corrupt: %v", result)
+	}
+	var applied int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		return fmt.Errorf("backup is not a DogeMap database: %w", err)
+	}
+	return nil
+}
+
+func copyFile(from string, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// Scheduled backups are named dogemap-<UTC time>.db
+const BackupPrefix = "dogemap-"
+const BackupExt = ".db"
+const backupTimeFormat = "20060102T150405Z"
+
+// NewBackupService writes a backup into `dir` every `every`, keeping
+// the newest `keep` backups.
+func NewBackupService(db Backuper, dir string, every time.Duration, keep int) *BackupService {
+	return &BackupService{
+		db:    db,
+		dir:   dir,
+		every: every,
+		keep:  keep,
+		clock: clock.System,
+	}
+}
+
+type BackupService struct {
+	governor.ServiceCtx
+	db    Backuper
+	dir   string
+	every time.Duration
+	keep  int
+	clock clock.Clock
+}
+
+// WithClock replaces clock.System, for backup names and the schedule.
+func (sv *BackupService) WithClock(clk clock.Clock) *BackupService {
+	sv.clock = clk
+	return sv
+}
+
+// goroutine
+func (sv *BackupService) Run() {
+	if err := os.MkdirAll(sv.dir, 0o755); err != nil {
+		log.Printf("[backup] %v", err)
+		return
+	}
+	// continue the schedule across restarts.
+	files := sv.backups()
+	if len(files) > 0 {
+		if last, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(files[len(files)-1], BackupPrefix), BackupExt)); err == nil {
+			sv.clock.Sleep(sv.Context, last.Add(sv.every).Sub(sv.clock.Now()))
+		}
+	}
+	for !sv.Stopping() {
+		name := BackupPrefix + sv.clock.Now().UTC().Format(backupTimeFormat) + BackupExt
+		start := time.Now()
+		if err := sv.db.Backup(filepath.Join(sv.dir, name)); err != nil {
+			log.Printf("[backup] %v", err)
+		} else {
+			log.Printf("[backup] wrote %v in %v", name, time.Since(start).Round(time.Millisecond))
+			sv.prune()
+		}
+		sv.clock.Sleep(sv.Context, sv.every)
+	}
+}
+
+// backups lists backup file names, oldest first.
+func (sv *BackupService) backups() []string {
+	ents, err := os.ReadDir(sv.dir)
+	if err != nil {
+		log.Printf("[backup] cannot list directory: %v", err)
+		return nil
+	}
+	var files []string
+	for _, ent := range ents {
+		if !ent.IsDir() && strings.HasPrefix(ent.Name(), BackupPrefix) && strings.HasSuffix(ent.Name(), BackupExt) {
+			files = append(files, ent.Name())
+		}
+	}
+	// file names contain a UTC timestamp, so they sort oldest-first.
+	sort.Strings(files)
+	return files
+}
+
+// prune removes the oldest backups beyond `keep`.
+func (sv *BackupService) prune() {
+	files := sv.backups()
+	for len(files) > sv.keep {
+		err := os.Remove(filepath.Join(sv.dir, files[0]))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[backup] cannot remove old backup: %v", err)
+		}
+		files = files[1:]
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/governor"
+)
+
+// NewCensus snapshots aggregate node counts every hour into the
+// census rollup tables (see spec.CensusDimensions)
+func NewCensus(store spec.Store) *Census {
+	return &Census{
+		store: store,
+		clock: clock.System,
+	}
+}
+
+type Census struct {
+	governor.ServiceCtx
+	store spec.Store
+	clock clock.Clock
+}
+
+// WithClock replaces clock.System, for the store and the schedule.
+func (sv *Census) WithClock(clk clock.Clock) *Census {
+	sv.clock = clk
+	return sv
+}
+
+// goroutine
+func (sv *Census) Run() {
+	store := sv.store.WithCtx(sv.Context).WithClock(sv.clock)
+	sv.clock.Sleep(sv.Context, 1*time.Minute)
+	for !sv.Stopping() {
+		err := sv.snapshot(store, sv.clock.Now().Unix())
+		if err != nil {
+			log.Printf("[census] snapshot: %v", err)
+		}
+		// next snapshot at the start of the next hour.
+		hour := spec.CensusIntervals["hour"]
+		wait := hour - sv.clock.Now().Unix()%hour
+		sv.clock.Sleep(sv.Context, time.Duration(wait)*time.Second)
+	}
+}
+
+func (sv *Census) snapshot(store spec.Store, now int64) error {
+	coreNodes, err := store.NodeList()
+	if err != nil {
+		return err
+	}
+	netNodes, err := store.NetNodeCount()
+	if err != nil {
+		return err
+	}
+	counts := CountNodes(coreNodes, netNodes)
+	// each snapshot replaces the previous one in the same interval,
+	// so the daily rollup holds the last snapshot of each day.
+	for interval, secs := range spec.CensusIntervals {
+		err = store.AddCensus(interval, now-now%secs, counts)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("[census] %d core nodes, %d dogenet nodes", len(coreNodes), netNodes)
+	return nil
+}
+
+// CountNodes groups nodes by every census dimension, using each node's
+// stored location. DogeNet nodes only contribute to the "total" and
+// "type" dimensions.
+func CountNodes(coreNodes []spec.CoreNode, netNodes int) []spec.CensusCount {
+	dims := make(map[string]map[string]int64, len(spec.CensusDimensions))
+	for _, dim := range spec.CensusDimensions {
+		dims[dim] = make(map[string]int64)
+	}
+	dims[spec.CensusTotal][""] = int64(len(coreNodes) + netNodes)
+	dims[spec.CensusType]["core"] = int64(len(coreNodes))
+	dims[spec.CensusType]["dogenet"] = int64(netNodes)
+	for _, node := range coreNodes {
+		if node.Located {
+			dims[spec.CensusCountry][node.Country]++
+		} else {
+			dims[spec.CensusCountry][""]++ // unknown (see spec.Location.Found)
+		}
+		dims[spec.CensusVersion][node.Agent]++
+		dims[spec.CensusServices][strconv.FormatUint(node.Services, 10)]++
+		if node.Reachable {
+			dims[spec.CensusReachability]["reachable"]++
+		} else {
+			dims[spec.CensusReachability]["gossiped"]++
+		}
+	}
+	var res []spec.CensusCount
+	for _, dim := range spec.CensusDimensions {
+		for label, count := range dims[dim] {
+			res = append(res, spec.CensusCount{Dimension: dim, Label: label, Count: count})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Dimension != res[j].Dimension {
+			return res[i].Dimension < res[j].Dimension
+		}
+		return res[i].Label < res[j].Label
+	})
+	return res
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/spec/storetest"
+)
+
+func TestCountNodes(t *testing.T) {
+	nodes := []spec.CoreNode{
+		{Address: "10.1.0.1:22556", Country: "AU", LocSource: "geoip:1", Located: true, Agent: "/Shibetoshi:1.14.7/", Services: 5, Reachable: true},
+		{Address: "10.1.0.2:22556", Country: "AU", LocSource: "geoip:1", Located: true, Agent: "/Shibetoshi:1.14.7/", Services: 5},
+		{Address: "[2001:db8::1]:22556", LocSource: "geoip:1"}, // lookup failed
+		{Address: "10.1.0.3:22556"}, // not yet located
+	}
+	counts := make(map[string]int64)
+	for _, c := range CountNodes(nodes, 2) {
+		counts[c.Dimension+"/"+c.Label] = c.Count
+	}
+	expect := map[string]int64{
+		"total/":                      6,
+		"type/core":                   4,
+		"type/dogenet":                2,
+		"country/AU":                  2,
+		"country/":                    2,
+		"version//Shibetoshi:1.14.7/": 2,
+		"version/":                    2,
+		"services/5":                  2,
+		"services/0":                  2,
+		"reachability/reachable":      1,
+		"reachability/gossiped":       3,
+	}
+	if len(counts) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, counts)
+	}
+	for key, n := range expect {
+		if counts[key] != n {
+			t.Errorf("%s: expected %d, got %d", key, n, counts[key])
+		}
+	}
+}
+
+func TestCensus(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	db := NewMemoryStore(context.Background()).WithClock(clk)
+	if err := db.AddCoreNode(storetest.Addr(1), start.Unix(), 1); err != nil {
+		t.Fatal(err)
+	}
+	sv := NewCensus(db).WithClock(clk)
+	runService(t, &sv.Context, sv.Run)
+
+	totals := func() []spec.CensusCount {
+		t.Helper()
+		res, err := db.CensusSeries("hour", "total", start.Unix()-3600, start.Unix()+3*3600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	// the first snapshot is a minute after starting, then one at the
+	// start of every hour.
+	waitSleeping(t, clk)
+	if res := totals(); len(res) != 0 {
+		t.Fatalf("expected no snapshot before the first minute, got %+v", res)
+	}
+	clk.Advance(time.Minute)
+	waitSleeping(t, clk)
+	if err := db.AddCoreNode(storetest.Addr(2), start.Unix(), 1); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(28 * time.Minute) // 00:59
+	if res := totals(); len(res) != 1 || res[0].Time != start.Unix()-30*60 || res[0].Count != 1 {
+		t.Fatalf("expected one node at 00:00, got %+v", res)
+	}
+	clk.Advance(time.Minute) // 01:00
+	waitSleeping(t, clk)
+	res := totals()
+	if len(res) != 2 || res[1].Time != start.Unix()+30*60 || res[1].Count != 2 {
+		t.Fatalf("expected two nodes at 01:00, got %+v", res)
+	}
+	day, err := db.CensusSeries("day", "total", start.Unix()-3600, start.Unix()+3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(day) != 1 || day[0].Count != 2 {
+		t.Fatalf("expected the daily rollup to hold the last snapshot, got %+v", day)
+	}
+}
@@ -0,0 +1,736 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// MemoryDSN selects the in-memory store (see NewMemoryStore)
+const MemoryDSN = ":memory:"
+
+// MemoryStore is a pure-Go spec.Store with the same semantics as
+// SQLiteStore. Nothing is persisted: it is intended for tests and
+// ephemeral (demo) runs.
+type MemoryStore struct {
+	mem   *memoryDB
+	ctx   context.Context
+	clock clock.Clock
+}
+
+var _ spec.Store = &MemoryStore{}
+
+type memoryDB struct {
+	mutex   sync.Mutex // protects all of the following:
+	core    map[string]*memCore
+	keys    [2][]string // for random choice: [old, new] core keys in no particular order
+	rand    *rand.Rand
+	dayc    int64 // day counter (see SQLiteStore.TrimNodes)
+	day     int64 // unix day on which dayc last advanced
+	archive []memArchived
+	net     map[NodeID]*memNet
+	ident   map[string]*spec.IdentityProfile
+	idhist  map[string][]spec.IdentityChange // oldest first
+	links   map[NodeID]map[string]int64      // dogenet node -> core key -> seen (see spec.NodeLink)
+	sighted map[NodeID][]spec.Sighting       // oldest first
+	census  map[string]map[int64][]spec.CensusCount
+	search  []spec.SearchDoc
+}
+
+type memCore struct {
+	key       string // address.ToBytes()
+	index     int    // position in memoryDB.keys[isnew]
+	time      int64
+	services  uint64
+	isnew     bool
+	reachable bool
+	dayc      int64
+	probe     spec.CoreProbe
+	probed    int64
+	loc       spec.Location
+}
+
+type memNet struct {
+	node spec.NetNode
+	dayc int64
+}
+
+// memArchived is an expired core node (see spec.Retention)
+type memArchived struct {
+	memCore
+	expired int64
+}
+
+// NewMemoryStore returns a spec.Store implementation that keeps all
+// records in memory.
+func NewMemoryStore(ctx context.Context) spec.Store {
+	return &MemoryStore{
+		mem: &memoryDB{
+			core:    make(map[string]*memCore),
+			net:     make(map[NodeID]*memNet),
+			ident:   make(map[string]*spec.IdentityProfile),
+			idhist:  make(map[string][]spec.IdentityChange),
+			links:   make(map[NodeID]map[string]int64),
+			sighted: make(map[NodeID][]spec.Sighting),
+			census:  make(map[string]map[int64][]spec.CensusCount),
+			rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+			day:     unixDayStamp(clock.System),
+		},
+		ctx:   ctx,
+		clock: clock.System,
+	}
+}
+
+func (s *MemoryStore) WithCtx(ctx context.Context) spec.Store {
+	return &MemoryStore{
+		mem:   s.mem,
+		ctx:   ctx,
+		clock: s.clock,
+	}
+}
+
+func (s *MemoryStore) WithClock(clock clock.Clock) spec.Store {
+	return &MemoryStore{
+		mem:   s.mem,
+		ctx:   s.ctx,
+		clock: clock,
+	}
+}
+
+// lock acquires the database mutex, unless the context has been cancelled.
+func (s *MemoryStore) lock(name string) error {
+	if err := s.ctx.Err(); err != nil {
+		return spec.WrapErr(spec.DBProblem, "MemoryStore: db-problem: "+name, err)
+	}
+	s.mem.mutex.Lock()
+	return nil
+}
+
+func (s *MemoryStore) unlock() {
+	s.mem.mutex.Unlock()
+}
+
+func (c *memCore) nodeID() NodeID {
+	var id NodeID
+	id[0] = spec.NodeIDAddress
+	copy(id[1:], c.key)
+	return id
+}
+
+// sight extends the node's open sighting interval, or opens a new one.
+func (m *memoryDB) sight(id NodeID, now int64) {
+	hist := m.sighted[id]
+	if n := len(hist); n > 0 && hist[n-1].Gone == 0 {
+		hist[n-1].Last = now
+		return
+	}
+	m.sighted[id] = append(hist, spec.Sighting{First: now, Last: now})
+}
+
+// gone closes the node's open sighting interval.
+func (m *memoryDB) gone(id NodeID, now int64) {
+	hist := m.sighted[id]
+	if n := len(hist); n > 0 && hist[n-1].Gone == 0 {
+		hist[n-1].Gone = now
+	}
+}
+
+func keySet(isnew bool) int {
+	if isnew {
+		return 1
+	}
+	return 0
+}
+
+func (m *memoryDB) insertCore(c *memCore) {
+	set := keySet(c.isnew)
+	c.index = len(m.keys[set])
+	m.keys[set] = append(m.keys[set], c.key)
+	m.core[c.key] = c
+}
+
+func (m *memoryDB) removeCore(c *memCore) {
+	// swap-remove from keys.
+	set := keySet(c.isnew)
+	keys := m.keys[set]
+	last := len(keys) - 1
+	if c.index != last {
+		moved := m.core[keys[last]]
+		moved.index = c.index
+		keys[c.index] = moved.key
+	}
+	m.keys[set] = keys[:last]
+	delete(m.core, c.key)
+}
+
+// STORE INTERFACE
+
+func (s *MemoryStore) CoreStats() (mapSize int, newNodes int, err error) {
+	if err = s.lock("CoreStats"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for _, c := range s.mem.core {
+		if c.isnew {
+			newNodes++
+		}
+	}
+	return len(s.mem.core), newNodes, nil
+}
+
+func (s *MemoryStore) NodeList() (res []spec.CoreNode, err error) {
+	if err = s.lock("NodeList"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for _, c := range s.mem.core {
+		addr, err := dnet.AddressFromBytes([]byte(c.key))
+		if err != nil {
+			continue
+		}
+		res = append(res, c.coreNode(addr))
+	}
+	return res, nil
+}
+
+func (c *memCore) coreNode(addr Address) spec.CoreNode {
+	return spec.CoreNode{
+		Address:   addr.String(),
+		Time:      c.time,
+		Services:  c.services,
+		Caps:      c.probe.Caps,
+		Skew:      c.probe.Skew,
+		RTT:       c.probe.RTT,
+		Probed:    c.probed,
+		Version:   c.probe.Version,
+		Agent:     c.probe.Agent,
+		Reachable: c.reachable,
+		Lat:       c.loc.Lat,
+		Lon:       c.loc.Lon,
+		Country:   c.loc.Country,
+		City:      c.loc.City,
+		LocSource: c.loc.Source,
+		Located:   c.loc.Found,
+	}
+}
+
+// TrimNodes expires records after N days (see SQLiteStore.TrimNodes)
+func (s *MemoryStore) TrimNodes(retain spec.Retention) (advanced bool, remCore int64, remNet int64, err error) {
+	if err = s.lock("TrimNodes"); err != nil {
+		return
+	}
+	defer s.unlock()
+	// advance the day counter, at most once per day.
+	today := unixDayStamp(s.clock)
+	if today > s.mem.day {
+		s.mem.dayc++
+		s.mem.day = today
+		advanced = true
+	}
+	now := s.clock.Now().Unix()
+	for _, c := range s.mem.core {
+		keep := retain.Gossiped
+		if c.reachable {
+			keep = retain.Reachable
+		}
+		if c.dayc+keep < s.mem.dayc {
+			if retain.Archive {
+				s.mem.archive = append(s.mem.archive, memArchived{memCore: *c, expired: now})
+			}
+			s.mem.gone(c.nodeID(), now)
+			s.mem.removeCore(c)
+			remCore++
+		}
+	}
+	for id, n := range s.mem.net {
+		if n.dayc+retain.Identity < s.mem.dayc {
+			s.mem.gone(id, now)
+			delete(s.mem.net, id)
+			remNet++
+		}
+	}
+	// remove links to expired nodes
+	for id, cores := range s.mem.links {
+		if s.mem.net[id] == nil {
+			delete(s.mem.links, id)
+			continue
+		}
+		for key := range cores {
+			if s.mem.core[key] == nil {
+				delete(cores, key)
+			}
+		}
+	}
+	return advanced, remCore, remNet, nil
+}
+
+func (s *MemoryStore) AddCoreNode(address Address, unixTimeSec int64, services uint64) error {
+	_, _, err := s.AddCoreNodes([]spec.CoreAddr{{Address: address, Time: unixTimeSec, Services: services}})
+	return err
+}
+
+func (s *MemoryStore) AddCoreNodes(nodes []spec.CoreAddr) (added int, updated int, err error) {
+	if err = s.lock("AddCoreNodes"); err != nil {
+		return
+	}
+	defer s.unlock()
+	dayc := s.mem.dayc
+	now := s.clock.Now().Unix()
+	for _, node := range nodes {
+		key := string(node.Address.ToBytes())
+		c, found := s.mem.core[key]
+		if found {
+			c.time = node.Time
+			c.services = node.Services
+			c.dayc = dayc
+			updated++
+		} else {
+			c = &memCore{key: key, time: node.Time, services: node.Services, isnew: true, dayc: dayc}
+			s.mem.insertCore(c)
+			added++
+		}
+		if node.Location.Source != "" {
+			c.loc = node.Location
+		}
+		s.mem.sight(c.nodeID(), now)
+	}
+	return added, updated, nil
+}
+
+func (s *MemoryStore) ImportCoreNodes(nodes []spec.CoreNode) error {
+	if err := s.lock("ImportCoreNodes"); err != nil {
+		return err
+	}
+	defer s.unlock()
+	for _, node := range nodes {
+		addr, err := dnet.ParseAddress(node.Address)
+		if err != nil {
+			return spec.NewErr(spec.DBProblem, "MemoryStore: db-problem: ImportCoreNodes: invalid address: %v", node.Address)
+		}
+		key := string(addr.ToBytes())
+		c, found := s.mem.core[key]
+		if !found {
+			c = &memCore{key: key, isnew: true}
+			s.mem.insertCore(c)
+		}
+		c.time = node.Time
+		c.services = node.Services
+		c.reachable = node.Reachable
+		c.dayc = s.mem.dayc
+		c.probe = spec.CoreProbe{Caps: node.Caps, Skew: node.Skew, RTT: node.RTT, Version: node.Version, Agent: node.Agent}
+		c.probed = node.Probed
+		c.loc = spec.Location{Lat: node.Lat, Lon: node.Lon, Country: node.Country, City: node.City, Source: node.LocSource, Found: node.Located}
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateCoreTime(address Address) error {
+	if err := s.lock("UpdateCoreTime"); err != nil {
+		return err
+	}
+	defer s.unlock()
+	if c, found := s.mem.core[string(address.ToBytes())]; found {
+		c.time = s.clock.Now().Unix()
+		c.reachable = true
+		c.dayc = s.mem.dayc
+		s.mem.sight(c.nodeID(), c.time)
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateCoreProbe(address Address, probe spec.CoreProbe) error {
+	if err := s.lock("UpdateCoreProbe"); err != nil {
+		return err
+	}
+	defer s.unlock()
+	if c, found := s.mem.core[string(address.ToBytes())]; found {
+		c.probe = probe
+		c.probed = s.clock.Now().Unix()
+	}
+	return nil
+}
+
+func (s *MemoryStore) StaleLocations(source string, limit int) (res []Address, err error) {
+	if err = s.lock("StaleLocations"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for _, c := range s.mem.core {
+		if len(res) >= limit {
+			break
+		}
+		if c.loc.Source != source {
+			if addr, err := dnet.AddressFromBytes([]byte(c.key)); err == nil {
+				res = append(res, addr)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) UpdateCoreLocations(locs []spec.CoreLocation) error {
+	if err := s.lock("UpdateCoreLocations"); err != nil {
+		return err
+	}
+	defer s.unlock()
+	for _, cl := range locs {
+		if c, found := s.mem.core[string(cl.Address.ToBytes())]; found {
+			c.loc = cl.Location
+		}
+	}
+	return nil
+}
+
+// CoreNodesInBox scans every node (see SQLiteStore.CoreNodesInBox)
+func (s *MemoryStore) CoreNodesInBox(box spec.GeoBox) (res []spec.CoreNode, err error) {
+	if err = s.lock("CoreNodesInBox"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for _, c := range s.mem.core {
+		if !c.loc.Found || !box.Contains(c.loc.Lat, c.loc.Lon) {
+			continue
+		}
+		addr, err := dnet.AddressFromBytes([]byte(c.key))
+		if err != nil {
+			continue
+		}
+		res = append(res, c.coreNode(addr))
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) ChooseCoreNode() (res Address, err error) {
+	if err = s.lock("ChooseCoreNode"); err != nil {
+		return
+	}
+	defer s.unlock()
+	// prefer new nodes, then any node.
+	keys := s.mem.keys[keySet(true)]
+	if len(keys) == 0 {
+		keys = s.mem.keys[keySet(false)]
+		if len(keys) == 0 {
+			return res, spec.NotFoundError
+		}
+	}
+	return dnet.AddressFromBytes([]byte(keys[s.mem.rand.Intn(len(keys))]))
+}
+
+func (s *MemoryStore) AddNetNodes(nodes []spec.NetNode) (added int, updated int, err error) {
+	return s.putNetNodes("AddNetNodes", nodes, false)
+}
+
+func (s *MemoryStore) ImportNetNodes(nodes []spec.NetNode) error {
+	_, _, err := s.putNetNodes("ImportNetNodes", nodes, true)
+	return err
+}
+
+// putNetNodes stores dogenet nodes (see SQLiteStore.putNetNodes)
+func (s *MemoryStore) putNetNodes(name string, nodes []spec.NetNode, imported bool) (added int, updated int, err error) {
+	if err = s.lock(name); err != nil {
+		return
+	}
+	defer s.unlock()
+	now := s.clock.Now().Unix()
+	var hosts map[string][]string // core keys by host
+	for _, node := range nodes {
+		id, err := node.NodeID()
+		if err != nil {
+			return added, updated, spec.WrapErr(spec.DBProblem, "MemoryStore: db-problem: "+name, err)
+		}
+		node.Channels = append([]string{}, node.Channels...)
+		if !imported {
+			node.Seen = now
+		}
+		if n, found := s.mem.net[id]; found {
+			n.node = node
+			n.dayc = s.mem.dayc
+			updated++
+		} else {
+			s.mem.net[id] = &memNet{node: node, dayc: s.mem.dayc}
+			added++
+		}
+		if lo, _, ok := hostRange(node.Address); ok {
+			if hosts == nil {
+				hosts = make(map[string][]string)
+				for key := range s.mem.core {
+					hosts[key[:16]] = append(hosts[key[:16]], key)
+				}
+			}
+			for _, key := range hosts[string(lo[:16])] {
+				if s.mem.links[id] == nil {
+					s.mem.links[id] = make(map[string]int64)
+				}
+				s.mem.links[id][key] = now
+			}
+		}
+		if !imported {
+			s.mem.sight(id, now)
+		}
+	}
+	return added, updated, nil
+}
+
+func (s *MemoryStore) NetNodeList() (res []spec.NetNode, err error) {
+	if err = s.lock("NetNodeList"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for _, n := range s.mem.net {
+		node := n.node
+		node.Channels = append([]string{}, node.Channels...)
+		res = append(res, node)
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) NetNodesInBox(box spec.GeoBox) (res []spec.NetNode, err error) {
+	if err = s.lock("NetNodesInBox"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for _, n := range s.mem.net {
+		if n.node.Located && box.Contains(n.node.Lat, n.node.Lon) {
+			node := n.node
+			node.Channels = append([]string{}, node.Channels...)
+			res = append(res, node)
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) NodeInfo(id NodeID) (res spec.NodeInfo, err error) {
+	if err = s.lock("NodeInfo"); err != nil {
+		return
+	}
+	defer s.unlock()
+	res = spec.NodeInfo{ID: id.String(), Linked: []string{}}
+	if addr, isCore := id.Address(); isCore {
+		key := string(addr.ToBytes())
+		c, found := s.mem.core[key]
+		if !found {
+			return res, spec.NotFoundError
+		}
+		node := c.coreNode(addr)
+		res.Core = &node
+		for net, cores := range s.mem.links {
+			if _, linked := cores[key]; linked {
+				res.Linked = append(res.Linked, net.String())
+			}
+		}
+		return res, nil
+	}
+	n, found := s.mem.net[id]
+	if !found {
+		return res, spec.NotFoundError
+	}
+	node := n.node
+	node.Channels = append([]string{}, node.Channels...)
+	res.Net = &node
+	for key := range s.mem.links[id] {
+		res.Linked = append(res.Linked, s.mem.core[key].nodeID().String())
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) NodeLinks() (res []spec.NodeLink, err error) {
+	if err = s.lock("NodeLinks"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for net, cores := range s.mem.links {
+		for key, seen := range cores {
+			res = append(res, spec.NodeLink{Node: net, Core: s.mem.core[key].nodeID(), Seen: seen})
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) NodeLinksInBox(box spec.GeoBox) (res []spec.NodeLink, err error) {
+	if err = s.lock("NodeLinksInBox"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for net, cores := range s.mem.links {
+		n, found := s.mem.net[net]
+		if !found || !n.node.Located {
+			continue
+		}
+		inBox := box.Contains(n.node.Lat, n.node.Lon)
+		for key, seen := range cores {
+			c := s.mem.core[key]
+			if inBox || (c.loc.Found && box.Contains(c.loc.Lat, c.loc.Lon)) {
+				res = append(res, spec.NodeLink{Node: net, Core: c.nodeID(), Seen: seen})
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) NodeHistory(id NodeID) (res NodeHistory, err error) {
+	if err = s.lock("NodeHistory"); err != nil {
+		return
+	}
+	defer s.unlock()
+	hist := s.mem.sighted[id]
+	if len(hist) == 0 {
+		return res, spec.NotFoundError
+	}
+	return spec.MakeNodeHistory(id, append([]spec.Sighting(nil), hist...)), nil
+}
+
+func (s *MemoryStore) NetNodeCount() (count int, err error) {
+	if err = s.lock("NetNodeCount"); err != nil {
+		return
+	}
+	defer s.unlock()
+	return len(s.mem.net), nil
+}
+
+func (s *MemoryStore) IdentityProfiles() (res []spec.IdentityProfile, err error) {
+	if err = s.lock("IdentityProfiles"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for _, p := range s.mem.ident {
+		res = append(res, *p)
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) UpdateIdentities(profiles []spec.IdentityProfile) (changed int, err error) {
+	if err = s.lock("UpdateIdentities"); err != nil {
+		return
+	}
+	defer s.unlock()
+	now := s.clock.Now().Unix()
+	for _, p := range profiles {
+		if old, found := s.mem.ident[p.Identity]; found && string(old.Profile) == string(p.Profile) {
+			old.Fetched = now
+			continue
+		}
+		stored := p
+		stored.Profile = append(json.RawMessage(nil), p.Profile...)
+		stored.Fetched, stored.Changed = now, now
+		s.mem.ident[p.Identity] = &stored
+		s.mem.idhist[p.Identity] = append(s.mem.idhist[p.Identity], spec.IdentityChange{Time: now, Profile: stored.Profile})
+		changed++
+	}
+	return changed, nil
+}
+
+func (s *MemoryStore) TrimIdentities(before int64) (removed int64, err error) {
+	if err = s.lock("TrimIdentities"); err != nil {
+		return
+	}
+	defer s.unlock()
+	now := s.clock.Now().Unix()
+	for key, p := range s.mem.ident {
+		if p.Fetched < before {
+			delete(s.mem.ident, key)
+			s.mem.idhist[key] = append(s.mem.idhist[key], spec.IdentityChange{Time: now})
+			removed++
+		}
+	}
+	// the history of identities removed before `before`
+	for key, hist := range s.mem.idhist {
+		if _, found := s.mem.ident[key]; !found && hist[len(hist)-1].Time < before {
+			delete(s.mem.idhist, key)
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryStore) IdentityHistory(identity string) (res []spec.IdentityChange, err error) {
+	if err = s.lock("IdentityHistory"); err != nil {
+		return
+	}
+	defer s.unlock()
+	hist := s.mem.idhist[identity]
+	if len(hist) == 0 {
+		return nil, spec.NotFoundError
+	}
+	return append([]spec.IdentityChange(nil), hist...), nil
+}
+
+func (s *MemoryStore) SetSearchIndex(docs []spec.SearchDoc) error {
+	if err := s.lock("SetSearchIndex"); err != nil {
+		return err
+	}
+	defer s.unlock()
+	s.mem.search = append([]spec.SearchDoc(nil), docs...)
+	return nil
+}
+
+func (s *MemoryStore) Search(query string, limit int) (res []spec.SearchHit, err error) {
+	terms := spec.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if err = s.lock("Search"); err != nil {
+		return
+	}
+	defer s.unlock()
+	var docs []spec.SearchDoc
+	for _, doc := range s.mem.search {
+		if spec.MatchSearch(terms, doc) {
+			docs = append(docs, doc)
+		}
+	}
+	return spec.RankSearch(terms, docs, limit), nil
+}
+
+func (s *MemoryStore) AddCensus(interval string, time int64, counts []spec.CensusCount) error {
+	if _, err := censusTable(interval); err != nil {
+		return err
+	}
+	if err := s.lock("AddCensus"); err != nil {
+		return err
+	}
+	defer s.unlock()
+	snaps := s.mem.census[interval]
+	if snaps == nil {
+		snaps = make(map[int64][]spec.CensusCount)
+		s.mem.census[interval] = snaps
+	}
+	snap := make([]spec.CensusCount, len(counts))
+	for i, c := range counts {
+		c.Time = time
+		snap[i] = c
+	}
+	snaps[time] = snap
+	return nil
+}
+
+func (s *MemoryStore) CensusSeries(interval string, dimension string, from int64, to int64) (res []spec.CensusCount, err error) {
+	if _, err = censusTable(interval); err != nil {
+		return
+	}
+	if err = s.lock("CensusSeries"); err != nil {
+		return
+	}
+	defer s.unlock()
+	for time, snap := range s.mem.census[interval] {
+		if time >= from && time <= to {
+			for _, c := range snap {
+				if c.Dimension == dimension {
+					res = append(res, c)
+				}
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Time != res[j].Time {
+			return res[i].Time < res[j].Time
+		}
+		return res[i].Label < res[j].Label
+	})
+	return res, nil
+}
@@ -0,0 +1,15 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/spec/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) spec.Store {
+		return NewMemoryStore(context.Background())
+	})
+}
@@ -0,0 +1,418 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// Numbered migrations for each SQL dialect, embedded in the binary.
+// File names are <version>_<name>.up.sql and <version>_<name>.down.sql;
+// each migration is applied in its own transaction.
+//
+// NEVER edit a migration once released: add a new one instead.
+// Applied migrations are checksummed, and the Migrator refuses to run
+// if an applied migration has been edited.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const (
+	DialectSQLite   = "sqlite"
+	DialectPostgres = "postgres"
+)
+
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string // empty if the migration cannot be rolled back
+	Checksum string // sha256 of Up
+}
+
+type MigrationState string
+
+const (
+	MigrationApplied  MigrationState = "applied"
+	MigrationPending  MigrationState = "pending"
+	MigrationModified MigrationState = "modified" // applied, but the file has changed since
+	MigrationMissing  MigrationState = "missing"  // applied, but not in this binary (newer version?)
+)
+
+type MigrationStatus struct {
+	Version int
+	Name    string
+	State   MigrationState
+	Applied int64 // unix time, if applied
+}
+
+// Migrator applies and rolls back the embedded migrations.
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+// LoadMigrations returns the embedded migrations for a dialect, in order.
+func LoadMigrations(dialect string) ([]Migration, error) {
+	dir := "migrations/" + dialect
+	ents, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: unknown dialect: %v", dialect)
+	}
+	byVer := map[int]*Migration{}
+	for _, ent := range ents {
+		match := migrationName.FindStringSubmatch(ent.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: bad file name: %v/%v", dir, ent.Name())
+		}
+		ver, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(migrationFiles, dir+"/"+ent.Name())
+		if err != nil {
+			return nil, fmt.Errorf("migrations: %v", err)
+		}
+		m := byVer[ver]
+		if m == nil {
+			m = &Migration{Version: ver, Name: match[2]}
+			byVer[ver] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d has two names: %v, %v", ver, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+			sum := sha256.Sum256(body)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(body)
+		}
+	}
+	res := make([]Migration, 0, len(byVer))
+	for _, m := range byVer {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrations: version %d has no .up.sql", m.Version)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+func NewMigrator(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := LoadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// OpenMigrator opens a database for the `dogemap db` commands without
+// applying any migrations. `dsn` is a postgres:// DSN or a SQLite file.
+func OpenMigrator(dsn string) (*Migrator, error) {
+	backend, dialect := "sqlite3", DialectSQLite
+	if IsPostgresDSN(dsn) {
+		backend, dialect = "postgres", DialectPostgres
+	} else {
+		dsn = sqliteDSN(dsn, false)
+	}
+	db, err := sql.Open(backend, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == DialectSQLite {
+		db.SetMaxOpenConns(1)
+	}
+	m, err := NewMigrator(db, dialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Migrator) Close() {
+	m.db.Close()
+}
+
+// q converts `?` placeholders to the dialect's style.
+func (m *Migrator) q(query string) string {
+	if m.dialect != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// txn runs `work` in a transaction; on PostgreSQL it also takes a lock
+// so that only one instance migrates the database at a time.
+func (m *Migrator) txn(work func(tx *sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if m.dialect == DialectPostgres {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(7105321)"); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied BIGINT NOT NULL
+)`); err != nil {
+		return err
+	}
+	if err := m.adoptLegacy(tx); err != nil {
+		return err
+	}
+	if err := work(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptLegacy converts the old single-row `migration` table (which held
+// the schema version) into schema_migrations records.
+func (m *Migrator) adoptLegacy(tx *sql.Tx) error {
+	var exists bool
+	var err error
+	if m.dialect == DialectPostgres {
+		err = tx.QueryRow("SELECT to_regclass('migration') IS NOT NULL").Scan(&exists)
+	} else {
+		err = tx.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type='table' AND name='migration'").Scan(&exists)
+	}
+	if err != nil || !exists {
+		return err
+	}
+	var version int
+	err = tx.QueryRow("SELECT version FROM migration LIMIT 1").Scan(&version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, mig := range m.migrations {
+		if mig.Version <= version {
+			_, err = tx.Exec(m.q("INSERT INTO schema_migrations (version, name, checksum, applied) VALUES (?,?,?,?)"),
+				mig.Version, mig.Name, mig.Checksum, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = tx.Exec("DROP TABLE migration")
+	return err
+}
+
+type appliedMigration struct {
+	name     string
+	checksum string
+	applied  int64
+}
+
+func (m *Migrator) applied(tx *sql.Tx) (map[int]appliedMigration, error) {
+	rows, err := tx.Query("SELECT version, name, checksum, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := map[int]appliedMigration{}
+	for rows.Next() {
+		var ver int
+		var a appliedMigration
+		if err := rows.Scan(&ver, &a.name, &a.checksum, &a.applied); err != nil {
+			return nil, err
+		}
+		res[ver] = a
+	}
+	return res, rows.Err()
+}
+
+// Status lists every known migration and any applied migrations
+// that are not in this binary.
+func (m *Migrator) Status() (res []MigrationStatus, err error) {
+	err = m.txn(func(tx *sql.Tx) error {
+		applied, err := m.applied(tx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			st := MigrationStatus{Version: mig.Version, Name: mig.Name, State: MigrationPending}
+			if a, found := applied[mig.Version]; found {
+				st.Applied = a.applied
+				st.State = MigrationApplied
+				if a.checksum != mig.Checksum {
+					st.State = MigrationModified
+				}
+				delete(applied, mig.Version)
+			}
+			res = append(res, st)
+		}
+		for ver, a := range applied {
+			res = append(res, MigrationStatus{Version: ver, Name: a.name, State: MigrationMissing, Applied: a.applied})
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+		return nil
+	})
+	return
+}
+
+// Migrate applies all pending migrations, each in its own transaction.
+func (m *Migrator) Migrate() (count int, err error) {
+	for _, mig := range m.migrations {
+		done := false
+		err = m.txn(func(tx *sql.Tx) error {
+			applied, err := m.applied(tx)
+			if err != nil {
+				return err
+			}
+			if err := m.verify(applied); err != nil {
+				return err
+			}
+			if _, found := applied[mig.Version]; found {
+				return nil
+			}
+			if _, err := tx.Exec(mig.Up); err != nil {
+				return fmt.Errorf("applying migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			_, err = tx.Exec(m.q("INSERT INTO schema_migrations (version, name, checksum, applied) VALUES (?,?,?,?)"),
+				mig.Version, mig.Name, mig.Checksum, time.Now().Unix())
+			done = err == nil
+			return err
+		})
+		if err != nil {
+			return count, err
+		}
+		if done {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// verify refuses to continue if an applied migration has been edited,
+// or the database has migrations this binary does not know about.
+func (m *Migrator) verify(applied map[int]appliedMigration) error {
+	known := map[int]bool{}
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		if a, found := applied[mig.Version]; found && a.checksum != mig.Checksum {
+			return fmt.Errorf("migration %d_%s has been edited since it was applied (checksum mismatch)", mig.Version, mig.Name)
+		}
+	}
+	for ver, a := range applied {
+		if !known[ver] {
+			return fmt.Errorf("database has migration %d_%s which this version does not know (downgrade?)", ver, a.name)
+		}
+	}
+	return nil
+}
+
+// Rollback reverts the last `steps` applied migrations, newest first.
+func (m *Migrator) Rollback(steps int) (count int, err error) {
+	for count < steps {
+		done := false
+		err = m.txn(func(tx *sql.Tx) error {
+			applied, err := m.applied(tx)
+			if err != nil {
+				return err
+			}
+			if err := m.verify(applied); err != nil {
+				return err
+			}
+			var last *Migration
+			for i := range m.migrations {
+				if _, found := applied[m.migrations[i].Version]; found {
+					last = &m.migrations[i]
+				}
+			}
+			if last == nil {
+				return nil // nothing to roll back
+			}
+			if last.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be rolled back (no .down.sql)", last.Version, last.Name)
+			}
+			if _, err := tx.Exec(last.Down); err != nil {
+				return fmt.Errorf("rolling back migration %d_%s: %w", last.Version, last.Name, err)
+			}
+			_, err = tx.Exec(m.q("DELETE FROM schema_migrations WHERE version=?"), last.Version)
+			done = err == nil
+			return err
+		})
+		if err != nil || !done {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Check verifies that every migration has been applied, without writing
+// to the database (see NewSQLiteStoreReadOnly)
+func (m *Migrator) Check() error {
+	tx, err := m.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	applied, err := m.applied(tx)
+	if err != nil {
+		return fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	if err := m.verify(applied); err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		if _, found := applied[mig.Version]; !found {
+			return fmt.Errorf("migration %d_%s has not been applied (start a crawl instance, or run `dogemap db migrate`)", mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+// checkSchema refuses to open a read-only store with an outdated schema.
+func checkSchema(db *sql.DB, dialect string) error {
+	m, err := NewMigrator(db, dialect)
+	if err != nil {
+		return spec.WrapErr(spec.DBProblem, "loading migrations", err)
+	}
+	if err := m.Check(); err != nil {
+		return spec.WrapErr(spec.DBProblem, "checking schema", err)
+	}
+	return nil
+}
+
+// migrateSchema brings a store's schema up to date on open.
+func migrateSchema(db *sql.DB, dialect string) error {
+	m, err := NewMigrator(db, dialect)
+	if err != nil {
+		return spec.WrapErr(spec.DBProblem, "loading migrations", err)
+	}
+	if _, err := m.Migrate(); err != nil {
+		return spec.WrapErr(spec.DBProblem, "migrating schema", err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS core (
+	address BYTEA NOT NULL PRIMARY KEY,
+	time BIGINT NOT NULL,
+	services BIGINT NOT NULL,
+	isnew BOOLEAN NOT NULL,
+	dayc INTEGER NOT NULL,
+	caps INTEGER NOT NULL DEFAULT 0,
+	probed BIGINT NOT NULL DEFAULT 0,
+	skew BIGINT NOT NULL DEFAULT 0,
+	rtt BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS core_time_i ON core (time);
+CREATE INDEX IF NOT EXISTS core_isnew_i ON core (isnew);
@@ -0,0 +1,2 @@
+DROP INDEX core_dayc_i;
+DROP TABLE daycount;
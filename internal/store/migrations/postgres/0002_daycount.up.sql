@@ -0,0 +1,11 @@
+-- day counter for expiry: advances once per day while DogeMap is running
+-- (see SQLiteStore.TrimNodes)
+CREATE TABLE daycount (
+	id INTEGER NOT NULL PRIMARY KEY CHECK (id = 1),
+	dayc BIGINT NOT NULL,
+	day BIGINT NOT NULL
+);
+INSERT INTO daycount (id, dayc, day) VALUES (1, 0, EXTRACT(EPOCH FROM now())::BIGINT / 86400);
+-- convert wall-clock expiry (2 days after `time`) into day-count expiry.
+UPDATE core SET dayc = time / 86400 - EXTRACT(EPOCH FROM now())::BIGINT / 86400 + 2;
+CREATE INDEX core_dayc_i ON core (dayc);
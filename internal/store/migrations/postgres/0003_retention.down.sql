@@ -0,0 +1,3 @@
+DROP TABLE core_archive;
+ALTER TABLE core DROP COLUMN reachable;
+UPDATE core SET dayc = dayc + 2;
@@ -0,0 +1,21 @@
+-- `dayc` now holds the day counter when the node was last seen
+-- (it held the expiry day: last seen + 2) so that each class of node
+-- can have its own retention window (see spec.Retention)
+UPDATE core SET dayc = dayc - 2;
+-- nodes we have connected to, as opposed to only heard about in gossip.
+ALTER TABLE core ADD COLUMN reachable BOOLEAN NOT NULL DEFAULT false;
+UPDATE core SET reachable = true WHERE probed > 0;
+-- expired core nodes, when archival is enabled.
+CREATE TABLE core_archive (
+	address BYTEA NOT NULL,
+	time BIGINT NOT NULL,
+	services BIGINT NOT NULL,
+	reachable BOOLEAN NOT NULL,
+	caps INTEGER NOT NULL,
+	skew BIGINT NOT NULL,
+	rtt BIGINT NOT NULL,
+	probed BIGINT NOT NULL,
+	expired BIGINT NOT NULL
+);
+CREATE INDEX core_archive_address_i ON core_archive (address);
+CREATE INDEX core_archive_expired_i ON core_archive (expired);
@@ -0,0 +1,13 @@
+-- sighting history: one row per interval during which a node was present
+-- (gone = 0 while it is still present). Nodes are keyed by spec.NodeID;
+-- kind is the NodeID type (1 = core address, 2 = dogenet pubkey).
+-- Existing nodes get their first interval when they are next seen.
+CREATE TABLE sighting (
+	node BYTEA NOT NULL,
+	kind INTEGER NOT NULL,
+	first_seen BIGINT NOT NULL,
+	last_seen BIGINT NOT NULL,
+	gone BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX sighting_node_i ON sighting (node, first_seen);
+CREATE INDEX sighting_open_i ON sighting (kind, gone);
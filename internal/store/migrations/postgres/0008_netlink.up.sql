@@ -0,0 +1,10 @@
+-- links a dogenet node (netnode.node, a pubkey NodeID) to the core nodes
+-- on the same host (core.address), see spec.NodeLink. seen is when the
+-- link was last observed. Links are removed when either node expires.
+CREATE TABLE netlink (
+	node BYTEA NOT NULL,
+	address BYTEA NOT NULL,
+	seen BIGINT NOT NULL,
+	PRIMARY KEY (node, address)
+);
+CREATE INDEX netlink_address_i ON netlink (address);
@@ -0,0 +1,2 @@
+DROP TABLE identity_history;
+DROP TABLE identity;
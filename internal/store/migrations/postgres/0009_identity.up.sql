@@ -0,0 +1,22 @@
+-- identity profiles cached from the identity service (see spec.IdentityProfile),
+-- keyed by identity pubkey hex. lat, lon, country and city are as published
+-- (IdentChit); profile is the JSON returned by the identity service.
+-- fetched is when the profile was last fetched, changed when it last changed.
+CREATE TABLE identity (
+	identity TEXT NOT NULL PRIMARY KEY,
+	lat TEXT NOT NULL,
+	lon TEXT NOT NULL,
+	country TEXT NOT NULL,
+	city TEXT NOT NULL,
+	profile TEXT NOT NULL,
+	fetched BIGINT NOT NULL,
+	changed BIGINT NOT NULL
+);
+CREATE INDEX identity_fetched_i ON identity (fetched);
+-- every profile change: profile is '' when the profile was removed.
+CREATE TABLE identity_history (
+	identity TEXT NOT NULL,
+	time BIGINT NOT NULL,
+	profile TEXT NOT NULL
+);
+CREATE INDEX identity_history_i ON identity_history (identity, time);
@@ -0,0 +1,10 @@
+-- search index (see spec.MakeSearchDocs), replaced by SetSearchIndex.
+-- text is already tokenized (see spec.SearchTerms), so the 'simple'
+-- configuration indexes the same terms as the other backends.
+CREATE TABLE search (
+	id TEXT NOT NULL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	text TEXT NOT NULL,
+	doc TSVECTOR NOT NULL
+);
+CREATE INDEX search_doc_i ON search USING GIN (doc);
@@ -0,0 +1 @@
+DROP INDEX core_geo_i;
@@ -0,0 +1,3 @@
+-- spatial index of located core nodes (see spec.GeoBox); nodes that are
+-- not yet located (locsrc = '') are not indexed.
+CREATE INDEX core_geo_i ON core USING GIST (point(lon, lat)) WHERE locsrc != '';
@@ -0,0 +1,7 @@
+-- located is false if the source could not locate the node's address
+-- (e.g. an IPv6 address missing from the GeoIP database): locsrc is set,
+-- so the lookup is not retried, but lat and lon are meaningless.
+ALTER TABLE core ADD COLUMN located BOOLEAN NOT NULL DEFAULT false;
+UPDATE core SET located = true WHERE locsrc != '' AND NOT (lat = 0 AND lon = 0 AND country = '');
+ALTER TABLE core_archive ADD COLUMN located BOOLEAN NOT NULL DEFAULT false;
+UPDATE core_archive SET located = true WHERE locsrc != '' AND NOT (lat = 0 AND lon = 0 AND country = '');
@@ -0,0 +1,2 @@
+DROP INDEX core_geo_i;
+CREATE INDEX core_geo_i ON core USING GIST (point(lon, lat)) WHERE locsrc != '';
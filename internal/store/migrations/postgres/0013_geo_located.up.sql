@@ -0,0 +1,4 @@
+-- index only located nodes (see 0012_located.up.sql): a failed lookup
+-- sets locsrc, but lat and lon are meaningless.
+DROP INDEX core_geo_i;
+CREATE INDEX core_geo_i ON core USING GIST (point(lon, lat)) WHERE located;
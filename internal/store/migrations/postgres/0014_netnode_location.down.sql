@@ -0,0 +1,6 @@
+ALTER TABLE netnode DROP COLUMN located;
+ALTER TABLE netnode DROP COLUMN locsrc;
+ALTER TABLE netnode DROP COLUMN city;
+ALTER TABLE netnode DROP COLUMN country;
+ALTER TABLE netnode DROP COLUMN lon;
+ALTER TABLE netnode DROP COLUMN lat;
@@ -0,0 +1,9 @@
+-- map location of each DogeBox, stored when it is fetched from dogenet
+-- (see collector.NetCollector): the owner's published location (locsrc
+-- 'identity') or the GeoIP location of its address; located as for core.
+ALTER TABLE netnode ADD COLUMN lat DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE netnode ADD COLUMN lon DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE netnode ADD COLUMN country TEXT NOT NULL DEFAULT '';
+ALTER TABLE netnode ADD COLUMN city TEXT NOT NULL DEFAULT '';
+ALTER TABLE netnode ADD COLUMN locsrc TEXT NOT NULL DEFAULT '';
+ALTER TABLE netnode ADD COLUMN located BOOLEAN NOT NULL DEFAULT false;
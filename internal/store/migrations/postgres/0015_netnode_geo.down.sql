@@ -0,0 +1 @@
+DROP INDEX netnode_geo_i;
@@ -0,0 +1,2 @@
+-- spatial index of located dogenet nodes (see spec.GeoBox), like core_geo_i.
+CREATE INDEX netnode_geo_i ON netnode USING GIST (point(lon, lat)) WHERE located;
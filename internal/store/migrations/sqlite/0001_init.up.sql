@@ -0,0 +1,10 @@
+-- WITHOUT ROWID: SQLite version 3.8.2 (2013-12-06) or later
+CREATE TABLE IF NOT EXISTS core (
+	address BLOB NOT NULL PRIMARY KEY,
+	time INTEGER NOT NULL,
+	services INTEGER NOT NULL,
+	isnew BOOLEAN NOT NULL,
+	dayc INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS core_time_i ON core (time);
+CREATE INDEX IF NOT EXISTS core_isnew_i ON core (isnew);
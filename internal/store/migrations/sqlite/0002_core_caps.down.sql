@@ -0,0 +1,2 @@
+ALTER TABLE core DROP COLUMN probed;
+ALTER TABLE core DROP COLUMN caps;
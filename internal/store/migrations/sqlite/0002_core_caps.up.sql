@@ -0,0 +1,2 @@
+ALTER TABLE core ADD COLUMN caps INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE core ADD COLUMN probed INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE core DROP COLUMN rtt;
+ALTER TABLE core DROP COLUMN skew;
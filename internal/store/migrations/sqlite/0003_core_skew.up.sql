@@ -0,0 +1,2 @@
+ALTER TABLE core ADD COLUMN skew INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE core ADD COLUMN rtt INTEGER NOT NULL DEFAULT 0;
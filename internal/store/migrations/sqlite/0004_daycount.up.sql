@@ -0,0 +1,11 @@
+-- day counter for expiry: advances once per day while DogeMap is running
+-- (see SQLiteStore.TrimNodes)
+CREATE TABLE daycount (
+	id INTEGER NOT NULL PRIMARY KEY CHECK (id = 1),
+	dayc INTEGER NOT NULL,
+	day INTEGER NOT NULL
+);
+INSERT INTO daycount (id, dayc, day) VALUES (1, 0, CAST(strftime('%s','now') AS INTEGER) / 86400);
+-- convert wall-clock expiry (2 days after `time`) into day-count expiry.
+UPDATE core SET dayc = CAST(time AS INTEGER) / 86400 - CAST(strftime('%s','now') AS INTEGER) / 86400 + 2;
+CREATE INDEX core_dayc_i ON core (dayc);
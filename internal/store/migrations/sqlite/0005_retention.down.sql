@@ -0,0 +1,5 @@
+DROP INDEX core_archive_expired_i;
+DROP INDEX core_archive_address_i;
+DROP TABLE core_archive;
+ALTER TABLE core DROP COLUMN reachable;
+UPDATE core SET dayc = dayc + 2;
@@ -0,0 +1,21 @@
+-- `dayc` now holds the day counter when the node was last seen
+-- (it held the expiry day: last seen + 2) so that each class of node
+-- can have its own retention window (see spec.Retention)
+UPDATE core SET dayc = dayc - 2;
+-- nodes we have connected to, as opposed to only heard about in gossip.
+ALTER TABLE core ADD COLUMN reachable BOOLEAN NOT NULL DEFAULT false;
+UPDATE core SET reachable = true WHERE probed > 0;
+-- expired core nodes, when archival is enabled.
+CREATE TABLE core_archive (
+	address BLOB NOT NULL,
+	time INTEGER NOT NULL,
+	services INTEGER NOT NULL,
+	reachable BOOLEAN NOT NULL,
+	caps INTEGER NOT NULL,
+	skew INTEGER NOT NULL,
+	rtt INTEGER NOT NULL,
+	probed INTEGER NOT NULL,
+	expired INTEGER NOT NULL
+);
+CREATE INDEX core_archive_address_i ON core_archive (address);
+CREATE INDEX core_archive_expired_i ON core_archive (expired);
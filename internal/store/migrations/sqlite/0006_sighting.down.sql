@@ -0,0 +1,3 @@
+DROP INDEX sighting_open_i;
+DROP INDEX sighting_node_i;
+DROP TABLE sighting;
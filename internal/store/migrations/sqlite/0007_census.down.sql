@@ -0,0 +1,4 @@
+DROP TABLE census_day;
+DROP TABLE census_hour;
+ALTER TABLE core DROP COLUMN agent;
+ALTER TABLE core DROP COLUMN version;
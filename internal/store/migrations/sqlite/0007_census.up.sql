@@ -0,0 +1,19 @@
+-- protocol version and user agent from the node's 'version' message.
+ALTER TABLE core ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE core ADD COLUMN agent TEXT NOT NULL DEFAULT '';
+-- network census rollups (see census.Census): node counts per bucket
+-- start time, for each dimension (e.g. country) and label (e.g. US)
+CREATE TABLE census_hour (
+	time INTEGER NOT NULL,
+	dimension TEXT NOT NULL,
+	label TEXT NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (time, dimension, label)
+);
+CREATE TABLE census_day (
+	time INTEGER NOT NULL,
+	dimension TEXT NOT NULL,
+	label TEXT NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (time, dimension, label)
+);
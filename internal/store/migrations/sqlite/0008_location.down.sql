@@ -0,0 +1,11 @@
+ALTER TABLE core_archive DROP COLUMN locsrc;
+ALTER TABLE core_archive DROP COLUMN city;
+ALTER TABLE core_archive DROP COLUMN country;
+ALTER TABLE core_archive DROP COLUMN lon;
+ALTER TABLE core_archive DROP COLUMN lat;
+DROP INDEX core_locsrc_i;
+ALTER TABLE core DROP COLUMN locsrc;
+ALTER TABLE core DROP COLUMN city;
+ALTER TABLE core DROP COLUMN country;
+ALTER TABLE core DROP COLUMN lon;
+ALTER TABLE core DROP COLUMN lat;
@@ -0,0 +1,14 @@
+-- geolocation resolved at ingestion (see spec.Location); locsrc is the
+-- source (e.g. GeoIP database version), '' if not yet resolved.
+ALTER TABLE core ADD COLUMN lat REAL NOT NULL DEFAULT 0;
+ALTER TABLE core ADD COLUMN lon REAL NOT NULL DEFAULT 0;
+ALTER TABLE core ADD COLUMN country TEXT NOT NULL DEFAULT '';
+ALTER TABLE core ADD COLUMN city TEXT NOT NULL DEFAULT '';
+ALTER TABLE core ADD COLUMN locsrc TEXT NOT NULL DEFAULT '';
+CREATE INDEX core_locsrc_i ON core (locsrc);
+-- archived nodes keep the location they had when they expired.
+ALTER TABLE core_archive ADD COLUMN lat REAL NOT NULL DEFAULT 0;
+ALTER TABLE core_archive ADD COLUMN lon REAL NOT NULL DEFAULT 0;
+ALTER TABLE core_archive ADD COLUMN country TEXT NOT NULL DEFAULT '';
+ALTER TABLE core_archive ADD COLUMN city TEXT NOT NULL DEFAULT '';
+ALTER TABLE core_archive ADD COLUMN locsrc TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP INDEX netnode_dayc_i;
+DROP TABLE netnode;
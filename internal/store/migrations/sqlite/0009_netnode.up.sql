@@ -0,0 +1,14 @@
+-- dogenet nodes (see spec.NetNode), keyed by spec.NodeID (pubkey).
+-- time is the node's timestamp reported by dogenet; seen is when we last
+-- fetched it from dogenet. channels is a comma-separated list.
+-- Expired by TrimNodes like core nodes (see spec.Retention.Identity)
+CREATE TABLE netnode (
+	node BLOB NOT NULL PRIMARY KEY,
+	address TEXT NOT NULL,
+	channels TEXT NOT NULL,
+	identity TEXT NOT NULL,
+	time INTEGER NOT NULL,
+	seen INTEGER NOT NULL,
+	dayc INTEGER NOT NULL
+);
+CREATE INDEX netnode_dayc_i ON netnode (dayc);
@@ -0,0 +1,2 @@
+DROP INDEX netlink_address_i;
+DROP TABLE netlink;
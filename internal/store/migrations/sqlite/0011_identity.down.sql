@@ -0,0 +1,4 @@
+DROP INDEX identity_history_i;
+DROP TABLE identity_history;
+DROP INDEX identity_fetched_i;
+DROP TABLE identity;
@@ -0,0 +1,4 @@
+DROP TRIGGER core_geo_delete;
+DROP TRIGGER core_geo_update;
+DROP TRIGGER core_geo_insert;
+DROP TABLE core_geo;
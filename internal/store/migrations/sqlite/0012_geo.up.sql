@@ -0,0 +1,16 @@
+-- spatial index of located core nodes (see spec.GeoBox), keyed by core.rowid.
+-- Kept in step with core.lat, core.lon and core.locsrc by triggers; nodes
+-- that are not yet located (locsrc = '') are not indexed. R*Tree stores
+-- 32-bit floats, so queries also compare core.lat and core.lon.
+CREATE VIRTUAL TABLE core_geo USING rtree(id, minLat, maxLat, minLon, maxLon);
+INSERT INTO core_geo (id, minLat, maxLat, minLon, maxLon) SELECT rowid, lat, lat, lon, lon FROM core WHERE locsrc != '';
+CREATE TRIGGER core_geo_insert AFTER INSERT ON core WHEN new.locsrc != '' BEGIN
+	INSERT INTO core_geo (id, minLat, maxLat, minLon, maxLon) VALUES (new.rowid, new.lat, new.lat, new.lon, new.lon);
+END;
+CREATE TRIGGER core_geo_update AFTER UPDATE OF lat, lon, locsrc ON core BEGIN
+	DELETE FROM core_geo WHERE id = old.rowid;
+	INSERT INTO core_geo (id, minLat, maxLat, minLon, maxLon) SELECT new.rowid, new.lat, new.lat, new.lon, new.lon WHERE new.locsrc != '';
+END;
+CREATE TRIGGER core_geo_delete AFTER DELETE ON core BEGIN
+	DELETE FROM core_geo WHERE id = old.rowid;
+END;
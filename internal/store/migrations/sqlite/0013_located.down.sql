@@ -0,0 +1,2 @@
+ALTER TABLE core_archive DROP COLUMN located;
+ALTER TABLE core DROP COLUMN located;
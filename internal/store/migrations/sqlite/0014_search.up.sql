@@ -0,0 +1,6 @@
+-- search index (see spec.MakeSearchDocs), updated by SetSearchIndex.
+-- text is already tokenized (see spec.SearchTerms), so the default FTS4
+-- tokenizer indexes the same terms as the other backends.
+-- (replaces the table SetSearchIndex used to create at run time)
+DROP TABLE IF EXISTS search;
+CREATE VIRTUAL TABLE search USING fts4(id, kind, text, notindexed=id, notindexed=kind);
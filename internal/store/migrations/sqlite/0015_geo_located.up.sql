@@ -0,0 +1,13 @@
+-- index only located nodes (see 0013_located.up.sql): a failed lookup
+-- sets locsrc, but lat and lon are meaningless.
+DROP TRIGGER core_geo_insert;
+DROP TRIGGER core_geo_update;
+DELETE FROM core_geo;
+INSERT INTO core_geo (id, minLat, maxLat, minLon, maxLon) SELECT rowid, lat, lat, lon, lon FROM core WHERE located;
+CREATE TRIGGER core_geo_insert AFTER INSERT ON core WHEN new.located BEGIN
+	INSERT INTO core_geo (id, minLat, maxLat, minLon, maxLon) VALUES (new.rowid, new.lat, new.lat, new.lon, new.lon);
+END;
+CREATE TRIGGER core_geo_update AFTER UPDATE OF lat, lon, located ON core BEGIN
+	DELETE FROM core_geo WHERE id = old.rowid;
+	INSERT INTO core_geo (id, minLat, maxLat, minLon, maxLon) SELECT new.rowid, new.lat, new.lat, new.lon, new.lon WHERE new.located;
+END;
@@ -0,0 +1,4 @@
+DROP TRIGGER netnode_geo_delete;
+DROP TRIGGER netnode_geo_update;
+DROP TRIGGER netnode_geo_insert;
+DROP TABLE netnode_geo;
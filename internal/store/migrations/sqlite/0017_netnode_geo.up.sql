@@ -0,0 +1,14 @@
+-- spatial index of located dogenet nodes (see spec.GeoBox), keyed by
+-- netnode.rowid, like core_geo (see 0015_geo_located.up.sql).
+CREATE VIRTUAL TABLE netnode_geo USING rtree(id, minLat, maxLat, minLon, maxLon);
+INSERT INTO netnode_geo (id, minLat, maxLat, minLon, maxLon) SELECT rowid, lat, lat, lon, lon FROM netnode WHERE located;
+CREATE TRIGGER netnode_geo_insert AFTER INSERT ON netnode WHEN new.located BEGIN
+	INSERT INTO netnode_geo (id, minLat, maxLat, minLon, maxLon) VALUES (new.rowid, new.lat, new.lat, new.lon, new.lon);
+END;
+CREATE TRIGGER netnode_geo_update AFTER UPDATE OF lat, lon, located ON netnode BEGIN
+	DELETE FROM netnode_geo WHERE id = old.rowid;
+	INSERT INTO netnode_geo (id, minLat, maxLat, minLon, maxLon) SELECT new.rowid, new.lat, new.lat, new.lon, new.lon WHERE new.located;
+END;
+CREATE TRIGGER netnode_geo_delete AFTER DELETE ON netnode BEGIN
+	DELETE FROM netnode_geo WHERE id = old.rowid;
+END;
@@ -0,0 +1,1059 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/gossip/dnet"
+	"github.com/lib/pq"
+)
+
+// Concurrent connections per PostgresStore; several crawler and
+// web instances can share one database.
+const PostgresMaxOpenConns = 16
+
+type PostgresStore struct {
+	db    *sql.DB
+	ctx   context.Context
+	clock clock.Clock
+}
+
+var _ spec.Store = &PostgresStore{}
+
+// IsPostgresDSN reports whether `dsn` names a PostgreSQL database.
+func IsPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// NewPostgresStore returns a spec.Store implementation that uses PostgreSQL
+func NewPostgresStore(dsn string, ctx context.Context) (spec.Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	store := &PostgresStore{db: db, ctx: ctx, clock: clock.System}
+	if err != nil {
+		return store, pgErr(err, "opening database")
+	}
+	db.SetMaxOpenConns(PostgresMaxOpenConns)
+	db.SetMaxIdleConns(PostgresMaxOpenConns)
+	err = store.initSchema()
+	return store, err
+}
+
+// NewPostgresStoreReadOnly opens a PostgreSQL database with read-only
+// transactions, e.g. for a web instance while other instances crawl
+// (see --mode). The schema is checked, not migrated; writes return ReadOnly.
+func NewPostgresStoreReadOnly(dsn string, ctx context.Context) (spec.Store, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, pgErr(err, "parsing DSN")
+	}
+	q := u.Query()
+	q.Set("default_transaction_read_only", "on") // sent to the server as a run-time parameter
+	u.RawQuery = q.Encode()
+	db, err := sql.Open("postgres", u.String())
+	store := &PostgresStore{db: db, ctx: ctx, clock: clock.System}
+	if err != nil {
+		return store, pgErr(err, "opening database")
+	}
+	db.SetMaxOpenConns(PostgresMaxOpenConns)
+	db.SetMaxIdleConns(PostgresMaxOpenConns)
+	err = checkSchema(db, DialectPostgres)
+	return store, err
+}
+
+func (s *PostgresStore) Close() {
+	s.db.Close()
+}
+
+// initSchema applies any pending migrations (see migrate.go)
+func (s *PostgresStore) initSchema() error {
+	return migrateSchema(s.db, DialectPostgres)
+}
+
+func (s *PostgresStore) WithCtx(ctx context.Context) spec.Store {
+	return &PostgresStore{
+		db:    s.db,
+		ctx:   ctx,
+		clock: s.clock,
+	}
+}
+
+func (s *PostgresStore) WithClock(clock clock.Clock) spec.Store {
+	return &PostgresStore{
+		db:    s.db,
+		ctx:   s.ctx,
+		clock: clock,
+	}
+}
+
+func (s PostgresStore) doTxn(name string, work func(tx *sql.Tx) error) error {
+	limit := 20
+	for {
+		tx, err := s.db.BeginTx(s.ctx, nil)
+		if err != nil {
+			return pgErr(err, "cannot begin transaction: "+name)
+		}
+		err = work(tx)
+		if err == nil {
+			err = tx.Commit()
+			if err != nil {
+				err = pgErr(err, "cannot commit: "+name)
+			}
+		} else {
+			tx.Rollback()
+			err = pgErr(err, name)
+		}
+		if err != nil && isPgConflict(err) {
+			// serialization failure or deadlock: retry the whole transaction.
+			limit--
+			if limit != 0 {
+				s.Sleep(50 * time.Millisecond)
+				continue
+			}
+		}
+		return err
+	}
+}
+
+func (s PostgresStore) Sleep(dur time.Duration) {
+	select {
+	case <-s.ctx.Done():
+	case <-time.After(dur):
+	}
+}
+
+func isPgConflict(err error) bool {
+	if errors.Is(err, spec.DBConflictError) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return isPgConflictCode(pqErr.Code)
+	}
+	return false
+}
+
+func isPgConflictCode(code pq.ErrorCode) bool {
+	switch code {
+	case "40001", // serialization_failure
+		"40P01", // deadlock_detected
+		"55P03": // lock_not_available
+		return true
+	}
+	return false
+}
+
+func pgErr(err error, where string) error {
+	var info *spec.ErrorInfo
+	if errors.As(err, &info) {
+		return err // already mapped
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if pqErr.Code.Class() == "23" {
+			// MUST detect 'AlreadyExists' to fulfil the API contract!
+			// Integrity constraint violation, e.g. a duplicate key.
+			return spec.WrapErr(spec.AlreadyExists, "PostgresStore: already-exists", err)
+		}
+		if pqErr.Code == "25006" {
+			// read_only_sql_transaction (see NewPostgresStoreReadOnly)
+			return spec.WrapErr(spec.ReadOnly, "PostgresStore: read-only", err)
+		}
+		if isPgConflictCode(pqErr.Code) {
+			// Concurrent transactions conflicted; the caller should retry.
+			return spec.WrapErr(spec.DBConflict, "PostgresStore: db-conflict", err)
+		}
+	}
+	return spec.WrapErr(spec.DBProblem, fmt.Sprintf("PostgresStore: db-problem: %s", where), err)
+}
+
+// STORE INTERFACE
+
+func (s PostgresStore) CoreStats() (mapSize int, newNodes int, err error) {
+	err = s.doTxn("CoreStats", func(tx *sql.Tx) error {
+		row := tx.QueryRow("SELECT COUNT(address), COUNT(address) FILTER (WHERE isnew) FROM core")
+		err := row.Scan(&mapSize, &newNodes)
+		if err != nil {
+			// special case: always return nil (no stats) errors.
+			if err != sql.ErrNoRows {
+				log.Printf("[Store] CoreStats: %v", err)
+			}
+			return nil
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) NodeList() (res []spec.CoreNode, err error) {
+	err = s.doTxn("NodeList", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		rows, err := tx.Query("SELECT " + pgCoreColumns + " FROM core")
+		if err != nil {
+			return pgErr(err, "coreNodeList: query")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			node, err := scanPgCore(rows)
+			if err != nil {
+				log.Printf("[Store] coreNodeList: %v", err)
+				continue
+			}
+			res = append(res, node)
+		}
+		if err = rows.Err(); err != nil { // docs say this check is required!
+			return pgErr(err, "coreNodeList: rows")
+		}
+		return nil
+	})
+	return
+}
+
+const pgCoreColumns = "address,time,services,caps,skew,rtt,probed,version,agent,reachable,lat,lon,country,city,locsrc,located"
+
+// scanPgCore scans pgCoreColumns into a CoreNode.
+func scanPgCore(row rowScanner) (spec.CoreNode, error) {
+	var addr []byte
+	var services, caps int64
+	var node spec.CoreNode
+	err := row.Scan(&addr, &node.Time, &services, &caps, &node.Skew, &node.RTT, &node.Probed, &node.Version, &node.Agent, &node.Reachable,
+		&node.Lat, &node.Lon, &node.Country, &node.City, &node.LocSource, &node.Located)
+	if err != nil {
+		return node, err
+	}
+	s_adr, err := dnet.AddressFromBytes(addr)
+	if err != nil {
+		return node, fmt.Errorf("bad node address: %w", err)
+	}
+	node.Address = s_adr.String()
+	node.Services = uint64(services)
+	node.Caps = uint32(caps)
+	return node, nil
+}
+
+// TrimNodes expires records after N days (see SQLiteStore.TrimNodes)
+func (s PostgresStore) TrimNodes(retain spec.Retention) (advanced bool, remCore int64, remNet int64, err error) {
+	err = s.doTxn("TrimNodes", func(tx *sql.Tx) error {
+		// advance the day counter, at most once per day.
+		var dayc, day int64
+		err := tx.QueryRow("SELECT dayc, day FROM daycount WHERE id=1 FOR UPDATE").Scan(&dayc, &day)
+		if err != nil {
+			return pgErr(err, "TrimNodes: SELECT daycount")
+		}
+		advanced = false // in case of retry
+		today := unixDayStamp(s.clock)
+		if today > day {
+			dayc++
+			_, err = tx.Exec("UPDATE daycount SET dayc=$1, day=$2 WHERE id=1", dayc, today)
+			if err != nil {
+				return pgErr(err, "TrimNodes: UPDATE daycount")
+			}
+			advanced = true
+		}
+		// expire core nodes
+		expired := "(reachable AND dayc < $1) OR (NOT reachable AND dayc < $2)"
+		reachableBefore, gossipedBefore := dayc-retain.Reachable, dayc-retain.Gossiped
+		now := s.clock.Now().Unix()
+		err = pgGoneCoreNodes(tx, "SELECT address FROM core WHERE "+expired, now, reachableBefore, gossipedBefore)
+		if err != nil {
+			return pgErr(err, "TrimNodes")
+		}
+		if retain.Archive {
+			_, err = tx.Exec("INSERT INTO core_archive (address,time,services,reachable,caps,skew,rtt,probed,lat,lon,country,city,locsrc,located,expired) SELECT address,time,services,reachable,caps,skew,rtt,probed,lat,lon,country,city,locsrc,located,$3 FROM core WHERE "+expired,
+				reachableBefore, gossipedBefore, now)
+			if err != nil {
+				return pgErr(err, "TrimNodes: archive core")
+			}
+		}
+		res, err := tx.Exec("DELETE FROM core WHERE "+expired, reachableBefore, gossipedBefore)
+		if err != nil {
+			return pgErr(err, "TrimNodes: DELETE core")
+		}
+		remCore, err = res.RowsAffected()
+		if err != nil {
+			return pgErr(err, "TrimNodes: rows-affected")
+		}
+		// expire dogenet nodes
+		identityBefore := dayc - retain.Identity
+		_, err = tx.Exec("UPDATE sighting SET gone=$1 WHERE gone=0 AND node IN (SELECT node FROM netnode WHERE dayc < $2)", now, identityBefore)
+		if err != nil {
+			return pgErr(err, "TrimNodes: sighting")
+		}
+		res, err = tx.Exec("DELETE FROM netnode WHERE dayc < $1", identityBefore)
+		if err != nil {
+			return pgErr(err, "TrimNodes: DELETE netnode")
+		}
+		remNet, err = res.RowsAffected()
+		if err != nil {
+			return pgErr(err, "TrimNodes: rows-affected")
+		}
+		// remove links to expired nodes
+		_, err = tx.Exec("DELETE FROM netlink WHERE node NOT IN (SELECT node FROM netnode) OR address NOT IN (SELECT address FROM core)")
+		if err != nil {
+			return pgErr(err, "TrimNodes: DELETE netlink")
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) AddCoreNode(address Address, unixTimeSec int64, services uint64) error {
+	_, _, err := s.AddCoreNodes([]spec.CoreAddr{{Address: address, Time: unixTimeSec, Services: services}})
+	return err
+}
+
+func (s PostgresStore) AddCoreNodes(nodes []spec.CoreAddr) (added int, updated int, err error) {
+	err = s.doTxn("AddCoreNodes", func(tx *sql.Tx) error {
+		added, updated = 0, 0 // in case of retry
+		// xmax is zero for a newly inserted row.
+		upsert, err := tx.Prepare(`INSERT INTO core (address, time, services, isnew, dayc)
+VALUES ($1,$2,$3,true,(SELECT dayc FROM daycount WHERE id=1))
+ON CONFLICT (address) DO UPDATE SET time=EXCLUDED.time, services=EXCLUDED.services, dayc=EXCLUDED.dayc
+RETURNING (xmax = 0)`)
+		if err != nil {
+			return pgErr(err, "AddCoreNodes: prepare")
+		}
+		defer upsert.Close()
+		loc, err := tx.Prepare("UPDATE core SET lat=$1, lon=$2, country=$3, city=$4, locsrc=$5, located=$6 WHERE address=$7")
+		if err != nil {
+			return pgErr(err, "AddCoreNodes: prepare")
+		}
+		defer loc.Close()
+		now := s.clock.Now().Unix()
+		for _, node := range nodes {
+			var inserted bool
+			err := upsert.QueryRow(node.Address.ToBytes(), node.Time, int64(node.Services)).Scan(&inserted)
+			if err != nil {
+				return pgErr(err, "AddCoreNodes: upsert")
+			}
+			if inserted {
+				added++
+			} else {
+				updated++
+			}
+			if l := node.Location; l.Source != "" {
+				if _, err := loc.Exec(l.Lat, l.Lon, l.Country, l.City, l.Source, l.Found, node.Address.ToBytes()); err != nil {
+					return pgErr(err, "AddCoreNodes: location")
+				}
+			}
+			if err := pgSightNode(tx, spec.NodeIDFromAddress(node.Address), now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) ImportCoreNodes(nodes []spec.CoreNode) error {
+	return s.doTxn("ImportCoreNodes", func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO core (address, time, services, caps, skew, rtt, probed, version, agent, reachable, lat, lon, country, city, locsrc, located, isnew, dayc)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,true,(SELECT dayc FROM daycount WHERE id=1))
+ON CONFLICT (address) DO UPDATE SET time=EXCLUDED.time, services=EXCLUDED.services, caps=EXCLUDED.caps, skew=EXCLUDED.skew, rtt=EXCLUDED.rtt,
+probed=EXCLUDED.probed, version=EXCLUDED.version, agent=EXCLUDED.agent, reachable=EXCLUDED.reachable, lat=EXCLUDED.lat, lon=EXCLUDED.lon,
+country=EXCLUDED.country, city=EXCLUDED.city, locsrc=EXCLUDED.locsrc, located=EXCLUDED.located, dayc=EXCLUDED.dayc`)
+		if err != nil {
+			return pgErr(err, "ImportCoreNodes: prepare")
+		}
+		defer stmt.Close()
+		for _, node := range nodes {
+			addr, err := dnet.ParseAddress(node.Address)
+			if err != nil {
+				return spec.NewErr(spec.DBProblem, "ImportCoreNodes: invalid address: %v", node.Address)
+			}
+			_, err = stmt.Exec(addr.ToBytes(), node.Time, int64(node.Services), int64(node.Caps), node.Skew, node.RTT, node.Probed, node.Version, node.Agent,
+				node.Reachable, node.Lat, node.Lon, node.Country, node.City, node.LocSource, node.Located)
+			if err != nil {
+				return pgErr(err, "ImportCoreNodes: upsert")
+			}
+		}
+		return nil
+	})
+}
+
+func (s PostgresStore) UpdateCoreTime(address Address) (err error) {
+	return s.doTxn("UpdateCoreTime", func(tx *sql.Tx) error {
+		addrKey := address.ToBytes()
+		unixTimeSec := s.clock.Now().Unix()
+		res, err := tx.Exec("UPDATE core SET time=$1, reachable=true, dayc=(SELECT dayc FROM daycount WHERE id=1) WHERE address=$2",
+			unixTimeSec, addrKey)
+		if err != nil {
+			return pgErr(err, "UpdateCoreTime: update")
+		}
+		if num, err := res.RowsAffected(); err != nil || num == 0 {
+			return err // unknown node: no sighting
+		}
+		return pgSightNode(tx, spec.NodeIDFromAddress(address), unixTimeSec)
+	})
+}
+
+func (s PostgresStore) UpdateCoreProbe(address Address, probe spec.CoreProbe) error {
+	return s.doTxn("UpdateCoreProbe", func(tx *sql.Tx) error {
+		addrKey := address.ToBytes()
+		unixTimeSec := s.clock.Now().Unix()
+		_, err := tx.Exec("UPDATE core SET caps=$1, skew=$2, rtt=$3, version=$4, agent=$5, probed=$6 WHERE address=$7",
+			int64(probe.Caps), probe.Skew, probe.RTT, probe.Version, probe.Agent, unixTimeSec, addrKey)
+		if err != nil {
+			return pgErr(err, "UpdateCoreProbe: update")
+		}
+		return nil
+	})
+}
+
+func (s PostgresStore) StaleLocations(source string, limit int) (res []Address, err error) {
+	err = s.doTxn("StaleLocations", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		rows, err := tx.Query("SELECT address FROM core WHERE locsrc != $1 LIMIT $2", source, limit)
+		if err != nil {
+			return pgErr(err, "StaleLocations: query")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var addr []byte
+			if err := rows.Scan(&addr); err != nil {
+				return pgErr(err, "StaleLocations: scan")
+			}
+			a, err := dnet.AddressFromBytes(addr)
+			if err != nil {
+				log.Printf("[Store] bad node address: %v", err)
+				continue
+			}
+			res = append(res, a)
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "StaleLocations: rows")
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) UpdateCoreLocations(locs []spec.CoreLocation) error {
+	return s.doTxn("UpdateCoreLocations", func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("UPDATE core SET lat=$1, lon=$2, country=$3, city=$4, locsrc=$5, located=$6 WHERE address=$7")
+		if err != nil {
+			return pgErr(err, "UpdateCoreLocations: prepare")
+		}
+		defer stmt.Close()
+		for _, cl := range locs {
+			l := cl.Location
+			if _, err := stmt.Exec(l.Lat, l.Lon, l.Country, l.City, l.Source, l.Found, cl.Address.ToBytes()); err != nil {
+				return pgErr(err, "UpdateCoreLocations: update")
+			}
+		}
+		return nil
+	})
+}
+
+func (s PostgresStore) CoreNodesInBox(box spec.GeoBox) (res []spec.CoreNode, err error) {
+	err = s.doTxn("CoreNodesInBox", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		for _, b := range box.Split() {
+			nodes, err := pgCoreInBox(tx, b)
+			if err != nil {
+				return err
+			}
+			res = append(res, nodes...)
+		}
+		return nil
+	})
+	return
+}
+
+// pgInBox selects the located rows inside a box $1..$4 (minLon, minLat,
+// maxLon, maxLat) using the table's spatial index (core_geo_i, netnode_geo_i)
+const pgInBox = "point(lon, lat) <@ box(point($1,$2), point($3,$4)) AND located"
+
+// pgCoreInBox queries core_geo_i for a box that does not cross the antimeridian.
+func pgCoreInBox(tx *sql.Tx, b spec.GeoBox) (res []spec.CoreNode, err error) {
+	rows, err := tx.Query("SELECT "+pgCoreColumns+" FROM core WHERE "+pgInBox,
+		b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+	if err != nil {
+		return nil, pgErr(err, "CoreNodesInBox: query")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		node, err := scanPgCore(rows)
+		if err != nil {
+			log.Printf("[Store] CoreNodesInBox: %v", err)
+			continue
+		}
+		res = append(res, node)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, pgErr(err, "CoreNodesInBox: rows")
+	}
+	return res, nil
+}
+
+func (s PostgresStore) ChooseCoreNode() (res Address, err error) {
+	err = s.doTxn("ChooseCoreNode", func(tx *sql.Tx) error {
+		// prefer new nodes, then any node.
+		row := tx.QueryRow("SELECT address FROM core ORDER BY isnew DESC, RANDOM() LIMIT 1")
+		var addr []byte
+		err := row.Scan(&addr)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return spec.NotFoundError
+			}
+			return pgErr(err, "ChooseCoreNode: query")
+		}
+		res, err = dnet.AddressFromBytes(addr)
+		if err != nil {
+			return fmt.Errorf("invalid address: %v", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) AddNetNodes(nodes []spec.NetNode) (added int, updated int, err error) {
+	return s.putNetNodes("AddNetNodes", nodes, false)
+}
+
+func (s PostgresStore) ImportNetNodes(nodes []spec.NetNode) error {
+	_, _, err := s.putNetNodes("ImportNetNodes", nodes, true)
+	return err
+}
+
+// putNetNodes stores dogenet nodes (see SQLiteStore.putNetNodes)
+func (s PostgresStore) putNetNodes(name string, nodes []spec.NetNode, imported bool) (added int, updated int, err error) {
+	err = s.doTxn(name, func(tx *sql.Tx) error {
+		added, updated = 0, 0 // in case of retry
+		// xmax is zero for a newly inserted row.
+		upsert, err := tx.Prepare(`INSERT INTO netnode (node, address, channels, identity, time, seen, lat, lon, country, city, locsrc, located, dayc)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,(SELECT dayc FROM daycount WHERE id=1))
+ON CONFLICT (node) DO UPDATE SET address=EXCLUDED.address, channels=EXCLUDED.channels, identity=EXCLUDED.identity,
+time=EXCLUDED.time, seen=EXCLUDED.seen, lat=EXCLUDED.lat, lon=EXCLUDED.lon, country=EXCLUDED.country, city=EXCLUDED.city,
+locsrc=EXCLUDED.locsrc, located=EXCLUDED.located, dayc=EXCLUDED.dayc
+RETURNING (xmax = 0)`)
+		if err != nil {
+			return pgErr(err, name+": prepare")
+		}
+		defer upsert.Close()
+		link, err := tx.Prepare(`INSERT INTO netlink (node, address, seen) SELECT $1, address, $2 FROM core WHERE address BETWEEN $3 AND $4
+ON CONFLICT (node, address) DO UPDATE SET seen=EXCLUDED.seen`)
+		if err != nil {
+			return pgErr(err, name+": prepare")
+		}
+		defer link.Close()
+		now := s.clock.Now().Unix()
+		for _, node := range nodes {
+			id, err := node.NodeID()
+			if err != nil {
+				return pgErr(err, name)
+			}
+			seen := now
+			if imported {
+				seen = node.Seen
+			}
+			var inserted bool
+			err = upsert.QueryRow(id[:], node.Address, strings.Join(node.Channels, ","), node.Identity, node.Time, seen,
+				node.Lat, node.Lon, node.Country, node.City, node.LocSource, node.Located).Scan(&inserted)
+			if err != nil {
+				return pgErr(err, name+": upsert")
+			}
+			if inserted {
+				added++
+			} else {
+				updated++
+			}
+			if lo, hi, ok := hostRange(node.Address); ok {
+				if _, err := link.Exec(id[:], now, lo, hi); err != nil {
+					return pgErr(err, name+": link")
+				}
+			}
+			if imported {
+				continue
+			}
+			if err := pgSightNode(tx, id, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) NetNodeList() (res []spec.NetNode, err error) {
+	err = s.doTxn("NetNodeList", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		rows, err := tx.Query("SELECT " + netColumns + " FROM netnode")
+		if err != nil {
+			return pgErr(err, "NetNodeList: query")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			n, err := scanNetNode(rows)
+			if err != nil {
+				return pgErr(err, "NetNodeList: scan")
+			}
+			res = append(res, n)
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "NetNodeList: rows")
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) NetNodesInBox(box spec.GeoBox) (res []spec.NetNode, err error) {
+	err = s.doTxn("NetNodesInBox", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		for _, b := range box.Split() {
+			rows, err := tx.Query("SELECT "+netColumns+" FROM netnode WHERE "+pgInBox,
+				b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+			if err != nil {
+				return pgErr(err, "NetNodesInBox: query")
+			}
+			nodes, err := scanNetNodes(rows)
+			if err != nil {
+				return pgErr(err, "NetNodesInBox")
+			}
+			res = append(res, nodes...)
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) NodeInfo(id NodeID) (res spec.NodeInfo, err error) {
+	err = s.doTxn("NodeInfo", func(tx *sql.Tx) error {
+		res = spec.NodeInfo{ID: id.String(), Linked: []string{}}
+		var links *sql.Rows
+		if addr, isCore := id.Address(); isCore {
+			node, err := scanPgCore(tx.QueryRow("SELECT "+pgCoreColumns+" FROM core WHERE address=$1", addr.ToBytes()))
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return spec.NotFoundError
+				}
+				return pgErr(err, "NodeInfo: query")
+			}
+			res.Core = &node
+			links, err = tx.Query("SELECT node FROM netlink WHERE address=$1", addr.ToBytes())
+			if err != nil {
+				return pgErr(err, "NodeInfo: links")
+			}
+		} else {
+			node, err := scanNetNode(tx.QueryRow("SELECT "+netColumns+" FROM netnode WHERE node=$1", id[:]))
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return spec.NotFoundError
+				}
+				return pgErr(err, "NodeInfo: query")
+			}
+			res.Net = &node
+			links, err = tx.Query("SELECT address FROM netlink WHERE node=$1", id[:])
+			if err != nil {
+				return pgErr(err, "NodeInfo: links")
+			}
+		}
+		defer links.Close()
+		for links.Next() {
+			var linked []byte
+			if err := links.Scan(&linked); err != nil {
+				return pgErr(err, "NodeInfo: links: scan")
+			}
+			if res.Net != nil {
+				core, err := coreNodeID(linked)
+				if err != nil {
+					return pgErr(err, "NodeInfo: links")
+				}
+				linked = core[:]
+			}
+			res.Linked = append(res.Linked, hex.EncodeToString(linked))
+		}
+		if err := links.Err(); err != nil {
+			return pgErr(err, "NodeInfo: links: rows")
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) NodeLinks() (res []spec.NodeLink, err error) {
+	err = s.doTxn("NodeLinks", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		rows, err := tx.Query("SELECT node, address, seen FROM netlink")
+		if err != nil {
+			return pgErr(err, "NodeLinks: query")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var node, addr []byte
+			var l spec.NodeLink
+			if err := rows.Scan(&node, &addr, &l.Seen); err != nil {
+				return pgErr(err, "NodeLinks: scan")
+			}
+			copy(l.Node[:], node)
+			if l.Core, err = coreNodeID(addr); err != nil {
+				log.Printf("[Store] bad node address: %v", err)
+				continue
+			}
+			res = append(res, l)
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "NodeLinks: rows")
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) NodeLinksInBox(box spec.GeoBox) (res []spec.NodeLink, err error) {
+	err = s.doTxn("NodeLinksInBox", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		// a link can span both halves of a split box
+		seen := make(map[[2]NodeID]bool)
+		for _, b := range box.Split() {
+			rows, err := tx.Query("SELECT l.node, l.address, l.seen FROM netlink l JOIN netnode n ON n.node = l.node WHERE n.located AND "+
+				"(l.node IN (SELECT node FROM netnode WHERE "+pgInBox+") OR l.address IN (SELECT address FROM core WHERE "+pgInBox+"))",
+				b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+			if err != nil {
+				return pgErr(err, "NodeLinksInBox: query")
+			}
+			links, err := scanNodeLinks(rows)
+			if err != nil {
+				return pgErr(err, "NodeLinksInBox")
+			}
+			for _, l := range links {
+				if key := [2]NodeID{l.Node, l.Core}; !seen[key] {
+					seen[key] = true
+					res = append(res, l)
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) NodeHistory(id NodeID) (res NodeHistory, err error) {
+	err = s.doTxn("NodeHistory", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT first_seen, last_seen, gone FROM sighting WHERE node=$1 ORDER BY first_seen", id[:])
+		if err != nil {
+			return pgErr(err, "NodeHistory: query")
+		}
+		defer rows.Close()
+		var intervals []spec.Sighting
+		for rows.Next() {
+			var si spec.Sighting
+			if err := rows.Scan(&si.First, &si.Last, &si.Gone); err != nil {
+				return pgErr(err, "NodeHistory: scan")
+			}
+			intervals = append(intervals, si)
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "NodeHistory: rows")
+		}
+		if len(intervals) == 0 {
+			return spec.NotFoundError
+		}
+		res = spec.MakeNodeHistory(id, intervals)
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) NetNodeCount() (count int, err error) {
+	err = s.doTxn("NetNodeCount", func(tx *sql.Tx) error {
+		err := tx.QueryRow("SELECT COUNT(*) FROM netnode").Scan(&count)
+		if err != nil {
+			return pgErr(err, "NetNodeCount: query")
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) IdentityProfiles() (res []spec.IdentityProfile, err error) {
+	err = s.doTxn("IdentityProfiles", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		rows, err := tx.Query("SELECT identity, lat, lon, country, city, profile, fetched, changed FROM identity")
+		if err != nil {
+			return pgErr(err, "IdentityProfiles: query")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p spec.IdentityProfile
+			var profile string
+			if err := rows.Scan(&p.Identity, &p.Lat, &p.Lon, &p.Country, &p.City, &profile, &p.Fetched, &p.Changed); err != nil {
+				return pgErr(err, "IdentityProfiles: scan")
+			}
+			p.Profile = json.RawMessage(profile)
+			res = append(res, p)
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "IdentityProfiles: rows")
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) UpdateIdentities(profiles []spec.IdentityProfile) (changed int, err error) {
+	err = s.doTxn("UpdateIdentities", func(tx *sql.Tx) error {
+		changed = 0 // in case of retry
+		now := s.clock.Now().Unix()
+		for _, p := range profiles {
+			var old string
+			err := tx.QueryRow("SELECT profile FROM identity WHERE identity=$1 FOR UPDATE", p.Identity).Scan(&old)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return pgErr(err, "UpdateIdentities: query")
+			}
+			if err == nil && old == string(p.Profile) {
+				_, err = tx.Exec("UPDATE identity SET fetched=$1 WHERE identity=$2", now, p.Identity)
+				if err != nil {
+					return pgErr(err, "UpdateIdentities: update")
+				}
+				continue
+			}
+			_, err = tx.Exec("INSERT INTO identity (identity, lat, lon, country, city, profile, fetched, changed) VALUES ($1,$2,$3,$4,$5,$6,$7,$7) ON CONFLICT (identity) DO UPDATE SET lat=excluded.lat, lon=excluded.lon, country=excluded.country, city=excluded.city, profile=excluded.profile, fetched=excluded.fetched, changed=excluded.changed",
+				p.Identity, p.Lat, p.Lon, p.Country, p.City, string(p.Profile), now)
+			if err != nil {
+				return pgErr(err, "UpdateIdentities: upsert")
+			}
+			_, err = tx.Exec("INSERT INTO identity_history (identity, time, profile) VALUES ($1,$2,$3)", p.Identity, now, string(p.Profile))
+			if err != nil {
+				return pgErr(err, "UpdateIdentities: history")
+			}
+			changed++
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) TrimIdentities(before int64) (removed int64, err error) {
+	err = s.doTxn("TrimIdentities", func(tx *sql.Tx) error {
+		now := s.clock.Now().Unix()
+		_, err := tx.Exec("INSERT INTO identity_history (identity, time, profile) SELECT identity, $1, '' FROM identity WHERE fetched < $2", now, before)
+		if err != nil {
+			return pgErr(err, "TrimIdentities: history")
+		}
+		res, err := tx.Exec("DELETE FROM identity WHERE fetched < $1", before)
+		if err != nil {
+			return pgErr(err, "TrimIdentities: delete")
+		}
+		removed, err = res.RowsAffected()
+		if err != nil {
+			return pgErr(err, "TrimIdentities: rows-affected")
+		}
+		// the history of identities removed before `before`
+		_, err = tx.Exec("DELETE FROM identity_history WHERE identity NOT IN (SELECT identity FROM identity) AND identity IN (SELECT identity FROM identity_history GROUP BY identity HAVING max(time) < $1)", before)
+		if err != nil {
+			return pgErr(err, "TrimIdentities: delete-history")
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) IdentityHistory(identity string) (res []spec.IdentityChange, err error) {
+	err = s.doTxn("IdentityHistory", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		rows, err := tx.Query("SELECT time, profile FROM identity_history WHERE identity=$1 ORDER BY time", identity)
+		if err != nil {
+			return pgErr(err, "IdentityHistory: query")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c spec.IdentityChange
+			var profile string
+			if err := rows.Scan(&c.Time, &profile); err != nil {
+				return pgErr(err, "IdentityHistory: scan")
+			}
+			if profile != "" { // removed
+				c.Profile = json.RawMessage(profile)
+			}
+			res = append(res, c)
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "IdentityHistory: rows")
+		}
+		if len(res) == 0 {
+			return spec.NotFoundError
+		}
+		return nil
+	})
+	return
+}
+
+func (s PostgresStore) SetSearchIndex(docs []spec.SearchDoc) error {
+	return s.doTxn("SetSearchIndex", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, kind, text FROM search")
+		if err != nil {
+			return pgErr(err, "SetSearchIndex: query")
+		}
+		defer rows.Close()
+		indexed := make(map[string]spec.SearchDoc)
+		for rows.Next() {
+			var doc spec.SearchDoc
+			if err := rows.Scan(&doc.ID, &doc.Kind, &doc.Text); err != nil {
+				return pgErr(err, "SetSearchIndex: scan")
+			}
+			indexed[doc.ID] = doc
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "SetSearchIndex: rows")
+		}
+		changed, removed := diffSearchIndex(indexed, docs)
+		for _, id := range removed {
+			_, err = tx.Exec("DELETE FROM search WHERE id=$1", id)
+			if err != nil {
+				return pgErr(err, "SetSearchIndex: delete")
+			}
+		}
+		for _, doc := range changed {
+			_, err = tx.Exec("INSERT INTO search (id, kind, text, doc) VALUES ($1,$2,$3,to_tsvector('simple',$3)) ON CONFLICT (id) DO UPDATE SET kind=excluded.kind, text=excluded.text, doc=excluded.doc", doc.ID, doc.Kind, doc.Text)
+			if err != nil {
+				return pgErr(err, "SetSearchIndex: upsert")
+			}
+		}
+		return nil
+	})
+}
+
+func (s PostgresStore) Search(query string, limit int) (res []spec.SearchHit, err error) {
+	terms := spec.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	// every term as a prefix, e.g. `lond:* & 22556:*`
+	match := strings.Join(terms, ":* & ") + ":*"
+	var docs []spec.SearchDoc
+	err = s.doTxn("Search", func(tx *sql.Tx) error {
+		docs = nil // in case of retry
+		rows, err := tx.Query("SELECT id, kind, text FROM search WHERE doc @@ to_tsquery('simple',$1)", match)
+		if err != nil {
+			return pgErr(err, "Search: query")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var doc spec.SearchDoc
+			if err := rows.Scan(&doc.ID, &doc.Kind, &doc.Text); err != nil {
+				return pgErr(err, "Search: scan")
+			}
+			docs = append(docs, doc)
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "Search: rows")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spec.RankSearch(terms, docs, limit), nil
+}
+
+func (s PostgresStore) AddCensus(interval string, time int64, counts []spec.CensusCount) error {
+	table, err := censusTable(interval)
+	if err != nil {
+		return err
+	}
+	return s.doTxn("AddCensus", func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE time=$1", time)
+		if err != nil {
+			return pgErr(err, "AddCensus: delete")
+		}
+		stmt, err := tx.Prepare("INSERT INTO " + table + " (time, dimension, label, count) VALUES ($1,$2,$3,$4)")
+		if err != nil {
+			return pgErr(err, "AddCensus: prepare")
+		}
+		defer stmt.Close()
+		for _, c := range counts {
+			if _, err := stmt.Exec(time, c.Dimension, c.Label, c.Count); err != nil {
+				return pgErr(err, "AddCensus: insert")
+			}
+		}
+		return nil
+	})
+}
+
+func (s PostgresStore) CensusSeries(interval string, dimension string, from int64, to int64) (res []spec.CensusCount, err error) {
+	table, err := censusTable(interval)
+	if err != nil {
+		return nil, err
+	}
+	err = s.doTxn("CensusSeries", func(tx *sql.Tx) error {
+		res = nil // in case of retry
+		rows, err := tx.Query("SELECT time, dimension, label, count FROM "+table+" WHERE dimension=$1 AND time>=$2 AND time<=$3 ORDER BY time, label",
+			dimension, from, to)
+		if err != nil {
+			return pgErr(err, "CensusSeries: query")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c spec.CensusCount
+			if err := rows.Scan(&c.Time, &c.Dimension, &c.Label, &c.Count); err != nil {
+				return pgErr(err, "CensusSeries: scan")
+			}
+			res = append(res, c)
+		}
+		if err = rows.Err(); err != nil {
+			return pgErr(err, "CensusSeries: rows")
+		}
+		return nil
+	})
+	return
+}
+
+// pgSightNode extends the node's open sighting interval, or opens a new one.
+func pgSightNode(tx *sql.Tx, id NodeID, now int64) error {
+	res, err := tx.Exec("UPDATE sighting SET last_seen=$1 WHERE node=$2 AND gone=0", now, id[:])
+	if err != nil {
+		return pgErr(err, "sighting: update")
+	}
+	num, err := res.RowsAffected()
+	if err != nil {
+		return pgErr(err, "sighting: rows-affected")
+	}
+	if num == 0 {
+		_, err = tx.Exec("INSERT INTO sighting (node, kind, first_seen, last_seen, gone) VALUES ($1,$2,$3,$4,0)", id[:], int(id.Kind()), now, now)
+		if err != nil {
+			return pgErr(err, "sighting: insert")
+		}
+	}
+	return nil
+}
+
+// pgGoneCoreNodes closes the open sighting intervals of the core nodes
+// selected by `query` (which must select core.address)
+func pgGoneCoreNodes(tx *sql.Tx, query string, now int64, args ...any) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("sighting: query: %w", err)
+	}
+	var gone []NodeID
+	for rows.Next() {
+		var addr []byte
+		if err := rows.Scan(&addr); err != nil {
+			rows.Close()
+			return fmt.Errorf("sighting: scan: %w", err)
+		}
+		if a, err := dnet.AddressFromBytes(addr); err == nil {
+			gone = append(gone, spec.NodeIDFromAddress(a))
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("sighting: rows: %w", err)
+	}
+	for _, id := range gone {
+		if _, err := tx.Exec("UPDATE sighting SET gone=$1 WHERE node=$2 AND gone=0", now, id[:]); err != nil {
+			return fmt.Errorf("sighting: update: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/spec/storetest"
+)
+
+// Set DOGEMAP_TEST_PG_DSN to a PostgreSQL database to run the conformance
+// suite against PostgresStore; each test gets its own schema, which is
+// dropped afterwards.
+const testPgEnv = "DOGEMAP_TEST_PG_DSN"
+
+func TestPostgresStore(t *testing.T) {
+	dsn := testPgDSN(t)
+	storetest.Run(t, func(t *testing.T) spec.Store {
+		db, err := NewPostgresStore(testPgSchema(t, dsn), context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(db.(*PostgresStore).Close)
+		return db
+	})
+}
+
+func TestPostgresReadOnly(t *testing.T) {
+	dsn := testPgSchema(t, testPgDSN(t))
+	db, err := NewPostgresStore(dsn, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.(*PostgresStore).Close()
+	if err := db.AddCoreNode(storetest.Addr(1), 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := NewPostgresStoreReadOnly(dsn, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.(*PostgresStore).Close()
+	nodes, err := ro.NodeList()
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("expected one node, got %v %v", nodes, err)
+	}
+	storetest.CheckErr(t, ro.AddCoreNode(storetest.Addr(2), 1000, 1), spec.ReadOnly)
+}
+
+func TestPgErr(t *testing.T) {
+	tests := []struct {
+		code pq.ErrorCode
+		want spec.ErrorCode
+	}{
+		{"23505", spec.AlreadyExists}, // unique_violation
+		{"23503", spec.AlreadyExists}, // foreign_key_violation
+		{"40001", spec.DBConflict},    // serialization_failure
+		{"40P01", spec.DBConflict},    // deadlock_detected
+		{"55P03", spec.DBConflict},    // lock_not_available
+		{"25006", spec.ReadOnly},      // read_only_sql_transaction
+		{"42P01", spec.DBProblem},     // undefined_table
+	}
+	for _, tc := range tests {
+		err := pgErr(fmt.Errorf("query: %w", &pq.Error{Code: tc.code}), "TestPgErr")
+		storetest.CheckErr(t, err, tc.want)
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) {
+			t.Errorf("%v: cause was not wrapped: %v", tc.code, err)
+		}
+	}
+	storetest.CheckErr(t, pgErr(errors.New("connection refused"), "TestPgErr"), spec.DBProblem)
+
+	// already-mapped errors are returned unchanged.
+	notFound := spec.NewErr(spec.NotFound, "not found")
+	if pgErr(notFound, "TestPgErr") != notFound {
+		t.Error("pgErr re-wrapped a spec error")
+	}
+}
+
+func testPgDSN(t *testing.T) string {
+	dsn := os.Getenv(testPgEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testPgEnv)
+	}
+	return dsn
+}
+
+// testPgSchema creates an empty schema and returns a DSN that uses it.
+func testPgSchema(t *testing.T, dsn string) string {
+	t.Helper()
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("dogemap_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping %s: %v", schema, err)
+		}
+		admin.Close()
+	})
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema) // sent to the server as a run-time parameter
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"log"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/governor"
+)
+
+// Nodes re-resolved per transaction by the Relocator.
+const RelocateBatch = 1000
+
+// NewRelocator resolves the location of core nodes that were stored
+// without one, or located with a different version of the GeoIP database.
+func NewRelocator(store spec.Store, locator spec.Locator) *Relocator {
+	return &Relocator{
+		store:   store,
+		locator: locator,
+		clock:   clock.System,
+	}
+}
+
+type Relocator struct {
+	governor.ServiceCtx
+	store   spec.Store
+	locator spec.Locator
+	clock   clock.Clock
+}
+
+// WithClock replaces clock.System, for the store and the schedule.
+func (sv *Relocator) WithClock(clk clock.Clock) *Relocator {
+	sv.clock = clk
+	return sv
+}
+
+// goroutine
+func (sv *Relocator) Run() {
+	store := sv.store.WithCtx(sv.Context).WithClock(sv.clock)
+	for !sv.Stopping() {
+		total := 0
+		for !sv.Stopping() {
+			n, err := sv.relocate(store)
+			if err != nil {
+				log.Printf("[relocate] %v", err)
+				break
+			}
+			total += n
+			if n < RelocateBatch {
+				break
+			}
+		}
+		if total > 0 {
+			log.Printf("[relocate] located %v core nodes using %v", total, sv.locator.Source())
+		}
+		sv.clock.Sleep(sv.Context, 10*time.Minute)
+	}
+}
+
+func (sv *Relocator) relocate(store spec.Store) (int, error) {
+	source := sv.locator.Source()
+	stale, err := store.StaleLocations(source, RelocateBatch)
+	if err != nil {
+		return 0, err
+	}
+	locs := make([]spec.CoreLocation, 0, len(stale))
+	for _, addr := range stale {
+		locs = append(locs, spec.CoreLocation{Address: addr, Location: sv.locator.Locate(addr.Host)})
+	}
+	return len(locs), store.UpdateCoreLocations(locs)
+}
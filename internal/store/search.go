@@ -0,0 +1,86 @@
+package store
+
+import (
+	"log"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/governor"
+)
+
+// How often SearchIndexer updates the search index
+const DefaultSearchPeriod = 1 * time.Minute
+
+// NewSearchIndexer updates the search index (see spec.MakeSearchDocs)
+// from the stored nodes and identity profiles every DefaultSearchPeriod.
+func NewSearchIndexer(store spec.Store) *SearchIndexer {
+	return &SearchIndexer{
+		store: store,
+		clock: clock.System,
+	}
+}
+
+type SearchIndexer struct {
+	governor.ServiceCtx
+	store spec.Store
+	clock clock.Clock
+}
+
+// WithClock replaces clock.System, for the store and the schedule.
+func (sv *SearchIndexer) WithClock(clk clock.Clock) *SearchIndexer {
+	sv.clock = clk
+	return sv
+}
+
+// goroutine
+func (sv *SearchIndexer) Run() {
+	store := sv.store.WithCtx(sv.Context).WithClock(sv.clock)
+	indexed := -1
+	for !sv.Stopping() {
+		count, err := sv.index(store)
+		if err != nil {
+			log.Printf("[search] index: %v", err)
+		} else if count != indexed {
+			log.Printf("[search] indexed %d documents", count)
+			indexed = count
+		}
+		sv.clock.Sleep(sv.Context, DefaultSearchPeriod)
+	}
+}
+
+func (sv *SearchIndexer) index(store spec.Store) (int, error) {
+	coreNodes, err := store.NodeList()
+	if err != nil {
+		return 0, err
+	}
+	netNodes, err := store.NetNodeList()
+	if err != nil {
+		return 0, err
+	}
+	profiles, err := store.IdentityProfiles()
+	if err != nil {
+		return 0, err
+	}
+	docs := spec.MakeSearchDocs(coreNodes, netNodes, profiles)
+	return len(docs), store.SetSearchIndex(docs)
+}
+
+// diffSearchIndex compares the indexed documents (by ID) with `docs`,
+// returning the new or changed documents and the IDs of those removed,
+// so SetSearchIndex only writes what changed.
+func diffSearchIndex(indexed map[string]spec.SearchDoc, docs []spec.SearchDoc) (changed []spec.SearchDoc, removed []string) {
+	keep := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		keep[doc.ID] = true
+		if old, found := indexed[doc.ID]; !found || old != doc {
+			changed = append(changed, doc)
+		}
+	}
+	for id := range indexed {
+		if !keep[id] {
+			removed = append(removed, id)
+		}
+	}
+	return changed, removed
+}
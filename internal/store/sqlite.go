@@ -3,112 +3,130 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
+	"code.dogecoin.org/dogemap-backend/internal/clock"
 	"code.dogecoin.org/dogemap-backend/internal/spec"
 	"code.dogecoin.org/gossip/dnet"
 	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
 type NodeID = spec.NodeID
+type NodeHistory = spec.NodeHistory
 type Address = spec.Address
 
 // SELECT * FROM table WHERE id IN (SELECT id FROM table ORDER BY RANDOM() LIMIT 10)
 
+// SQLite allows one writer at a time, but in WAL mode readers do not
+// block the writer (or each other). SQLiteStore keeps a single writer
+// connection and a pool of read-only connections.
 type SQLiteStore struct {
-	db  *sql.DB
-	ctx context.Context
+	db    *sql.DB // writer: a single connection
+	rdb   *sql.DB // readers: read-only connections
+	ctx   context.Context
+	clock clock.Clock
 }
 
 var _ spec.Store = &SQLiteStore{}
 
-// WITHOUT ROWID: SQLite version 3.8.2 (2013-12-06) or later
-
-const SQL_SCHEMA string = `
-CREATE TABLE IF NOT EXISTS migration (
-	version INTEGER NOT NULL DEFAULT 1
-);
-CREATE TABLE IF NOT EXISTS core (
-	address BLOB NOT NULL PRIMARY KEY,
-	time INTEGER NOT NULL,
-	services INTEGER NOT NULL,
-	isnew BOOLEAN NOT NULL,
-	dayc INTEGER NOT NULL
-);
-CREATE INDEX IF NOT EXISTS core_time_i ON core (time);
-CREATE INDEX IF NOT EXISTS core_isnew_i ON core (isnew);
-`
-
-var MIGRATIONS = []struct {
-	ver   int
-	query string
-}{}
+// Read-only connections per SQLiteStore.
+const SQLiteReadConns = 8
+
+// How long a connection waits for a lock held by another connection
+// (or process) before the query fails with SQLITE_BUSY.
+const SQLiteBusyTimeout = 10 * time.Second
+
+// sqliteDSN returns a DSN for go-sqlite3 that enables WAL and a busy timeout.
+// NORMAL sync is safe in WAL mode: a power failure can lose the last few
+// commits, but never corrupts the database.
+// Write transactions take the write lock up front (BEGIN IMMEDIATE) so they
+// wait in the busy handler instead of failing when they upgrade to write.
+func sqliteDSN(fileName string, readOnly bool) string {
+	path := (&url.URL{Path: fileName}).EscapedPath()
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=%d", path, SQLiteBusyTimeout.Milliseconds())
+	if readOnly {
+		return dsn + "&mode=ro"
+	}
+	return dsn + "&_txlock=immediate"
+}
 
 // NewSQLiteStore returns a spec.Store implementation that uses SQLite
 func NewSQLiteStore(fileName string, ctx context.Context) (spec.Store, error) {
-	backend := "sqlite3"
-	db, err := sql.Open(backend, fileName)
-	store := &SQLiteStore{db: db, ctx: ctx}
+	db, err := sql.Open("sqlite3", sqliteDSN(fileName, false))
+	store := &SQLiteStore{db: db, ctx: ctx, clock: clock.System}
 	if err != nil {
 		return store, dbErr(err, "opening database")
 	}
-	if backend == "sqlite3" {
-		// limit concurrent access until we figure out a way to start transactions
-		// with the BEGIN CONCURRENT statement in Go. Avoids "database locked" errors.
-		db.SetMaxOpenConns(1)
-	}
+	db.SetMaxOpenConns(1)
 	err = store.initSchema()
+	if err != nil {
+		return store, err
+	}
+	// open readers after migrations have created the database.
+	rdb, err := sql.Open("sqlite3", sqliteDSN(fileName, true))
+	if err != nil {
+		return store, dbErr(err, "opening database")
+	}
+	rdb.SetMaxOpenConns(SQLiteReadConns)
+	rdb.SetMaxIdleConns(SQLiteReadConns)
+	store.rdb = rdb
+	return store, nil
+}
+
+// NewSQLiteStoreReadOnly opens an existing SQLite database read-only,
+// e.g. for a web instance while another process crawls (see --mode).
+// The schema must be up to date: it is checked, not migrated.
+// Each query sees the latest committed data; writes return ReadOnly.
+func NewSQLiteStoreReadOnly(fileName string, ctx context.Context) (spec.Store, error) {
+	if _, err := os.Stat(fileName); err != nil {
+		return nil, dbErr(err, "opening database")
+	}
+	rdb, err := sql.Open("sqlite3", sqliteDSN(fileName, true))
+	store := &SQLiteStore{rdb: rdb, ctx: ctx, clock: clock.System}
+	if err != nil {
+		return store, dbErr(err, "opening database")
+	}
+	rdb.SetMaxOpenConns(SQLiteReadConns)
+	rdb.SetMaxIdleConns(SQLiteReadConns)
+	err = checkSchema(rdb, DialectSQLite)
 	return store, err
 }
 
 func (s *SQLiteStore) Close() {
-	s.db.Close()
+	if s.rdb != nil {
+		s.rdb.Close()
+	}
+	if s.db != nil {
+		s.db.Close()
+	}
 }
 
+// initSchema applies any pending migrations (see migrate.go)
 func (s *SQLiteStore) initSchema() error {
-	return s.doTxn("init schema", func(tx *sql.Tx) error {
-		// apply migrations
-		verRow := tx.QueryRow("SELECT version FROM migration LIMIT 1")
-		var version int
-		err := verRow.Scan(&version)
-		if err != nil {
-			// first-time database init.
-			// init schema (idempotent)
-			_, err := tx.Exec(SQL_SCHEMA)
-			if err != nil {
-				return dbErr(err, "creating database schema")
-			}
-			// set up version table (idempotent)
-			err = tx.QueryRow("SELECT version FROM migration LIMIT 1").Scan(&version)
+	return migrateSchema(s.db, DialectSQLite)
+}
+
+// reindexGeo rebuilds the core_geo and netnode_geo spatial indexes (see
+// 0012_geo.up.sql) which are keyed by rowid: VACUUM can renumber rowids,
+// so they are rebuilt when a backup is restored (see RestoreSQLite).
+func (s *SQLiteStore) reindexGeo() error {
+	return s.doTxn("reindexGeo", func(tx *sql.Tx) error {
+		for _, table := range []string{"core", "netnode"} {
+			_, err := tx.Exec("DELETE FROM " + table + "_geo")
 			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					version = 1
-					_, err = tx.Exec("INSERT INTO migration (version) VALUES (?)", version)
-					if err != nil {
-						return dbErr(err, "updating version")
-					}
-				} else {
-					return dbErr(err, "querying version")
-				}
-			}
-		}
-		initVer := version
-		for _, m := range MIGRATIONS {
-			if version < m.ver {
-				_, err = tx.Exec(m.query)
-				if err != nil {
-					return dbErr(err, fmt.Sprintf("applying migration %v", m.ver))
-				}
-				version = m.ver
+				return fmt.Errorf("delete: %w", err)
 			}
-		}
-		if version != initVer {
-			_, err = tx.Exec("UPDATE migration SET version=?", version)
+			_, err = tx.Exec("INSERT INTO " + table + "_geo (id, minLat, maxLat, minLon, maxLon) SELECT rowid, lat, lat, lon, lon FROM " + table + " WHERE located")
 			if err != nil {
-				return dbErr(err, "updating version")
+				return fmt.Errorf("insert: %w", err)
 			}
 		}
 		return nil
@@ -117,78 +135,65 @@ func (s *SQLiteStore) initSchema() error {
 
 func (s *SQLiteStore) WithCtx(ctx context.Context) spec.Store {
 	return &SQLiteStore{
-		db:  s.db,
-		ctx: ctx,
+		db:    s.db,
+		rdb:   s.rdb,
+		ctx:   ctx,
+		clock: s.clock,
 	}
 }
 
-// The number of whole days since the unix epoch.
-func unixDayStamp() int64 {
-	return time.Now().Unix() / spec.SecondsPerDay
+func (s *SQLiteStore) WithClock(clock clock.Clock) spec.Store {
+	return &SQLiteStore{
+		db:    s.db,
+		rdb:   s.rdb,
+		ctx:   s.ctx,
+		clock: clock,
+	}
 }
 
-func IsConflict(err error) bool {
-	if sqErr, isSq := err.(sqlite3.Error); isSq {
-		if sqErr.Code == sqlite3.ErrBusy || sqErr.Code == sqlite3.ErrLocked {
-			return true
-		}
-	}
-	return false
+// The number of whole days since the unix epoch.
+func unixDayStamp(clock clock.Clock) int64 {
+	return clock.Now().Unix() / spec.SecondsPerDay
 }
 
+// doTxn runs `work` in a write transaction on the writer connection.
+// Lock waits are handled by SQLite's busy timeout (see sqliteDSN)
 func (s SQLiteStore) doTxn(name string, work func(tx *sql.Tx) error) error {
-	limit := 120
-	for {
-		tx, err := s.db.Begin()
-		if err != nil {
-			if IsConflict(err) {
-				s.Sleep(250 * time.Millisecond)
-				limit--
-				if limit != 0 {
-					continue
-				}
-			}
-			return dbErr(err, "cannot begin transaction: "+name)
-		}
-		defer tx.Rollback()
-		err = work(tx)
-		if err != nil {
-			if IsConflict(err) {
-				s.Sleep(250 * time.Millisecond)
-				limit--
-				if limit != 0 {
-					continue
-				}
-			}
-			return err
-		}
-		err = tx.Commit()
-		if err != nil {
-			if IsConflict(err) {
-				s.Sleep(250 * time.Millisecond)
-				limit--
-				if limit != 0 {
-					continue
-				}
-			}
-			return dbErr(err, "cannot commit: "+name)
-		}
-		return nil
+	if s.db == nil {
+		return spec.NewErr(spec.ReadOnly, "SQLiteStore: read-only: %s", name)
 	}
+	return s.txn(s.db, nil, name, work)
 }
 
-func (s SQLiteStore) Sleep(dur time.Duration) {
-	select {
-	case <-s.ctx.Done():
-	case <-time.After(dur):
+// readTxn runs `work` in a read-only transaction on the reader pool.
+func (s SQLiteStore) readTxn(name string, work func(tx *sql.Tx) error) error {
+	return s.txn(s.rdb, &sql.TxOptions{ReadOnly: true}, name, work)
+}
+
+func (s SQLiteStore) txn(db *sql.DB, opts *sql.TxOptions, name string, work func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(s.ctx, opts)
+	if err != nil {
+		return dbErr(err, "cannot begin transaction: "+name)
+	}
+	defer tx.Rollback()
+	err = work(tx)
+	if err != nil {
+		return dbErr(err, name)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return dbErr(err, "cannot commit: "+name)
 	}
+	return nil
 }
 
 func dbErr(err error, where string) error {
-	if errors.Is(err, spec.NotFoundError) {
-		return err
+	var info *spec.ErrorInfo
+	if errors.As(err, &info) {
+		return err // already mapped
 	}
-	if sqErr, isSq := err.(sqlite3.Error); isSq {
+	var sqErr sqlite3.Error
+	if errors.As(err, &sqErr) {
 		if sqErr.Code == sqlite3.ErrConstraint {
 			// MUST detect 'AlreadyExists' to fulfil the API contract!
 			// Constraint violation, e.g. a duplicate key.
@@ -207,7 +212,7 @@ func dbErr(err error, where string) error {
 // STORE INTERFACE
 
 func (s SQLiteStore) CoreStats() (mapSize int, newNodes int, err error) {
-	err = s.doTxn("CoreStats", func(tx *sql.Tx) error {
+	err = s.readTxn("CoreStats", func(tx *sql.Tx) error {
 		row := tx.QueryRow("WITH t AS (SELECT COUNT(address) AS num, 1 AS rn FROM core), u AS (SELECT COUNT(address) AS isnew, 1 AS rn FROM core WHERE isnew=TRUE) SELECT t.num, u.isnew FROM t INNER JOIN u ON t.rn=u.rn")
 		err := row.Scan(&mapSize, &newNodes)
 		if err != nil {
@@ -223,40 +228,68 @@ func (s SQLiteStore) CoreStats() (mapSize int, newNodes int, err error) {
 }
 
 func (s SQLiteStore) NodeList() (res []spec.CoreNode, err error) {
-	err = s.doTxn("NodeList", func(tx *sql.Tx) error {
-		rows, err := tx.Query("SELECT address,CAST(time AS INTEGER),services FROM core")
+	err = s.readTxn("NodeList", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT " + sqliteCoreColumns + " FROM core")
 		if err != nil {
-			return fmt.Errorf("[Store] coreNodeList: query: %v", err)
+			return fmt.Errorf("[Store] coreNodeList: query: %w", err)
 		}
 		defer rows.Close()
 		for rows.Next() {
-			var addr []byte
-			var unixTime int64
-			var services uint64
-			err := rows.Scan(&addr, &unixTime, &services)
+			node, err := scanSQLiteCore(rows)
 			if err != nil {
-				log.Printf("[Store] coreNodeList: scanning row: %v", err)
+				log.Printf("[Store] coreNodeList: %v", err)
 				continue
 			}
-			s_adr, err := dnet.AddressFromBytes(addr)
-			if err != nil {
-				log.Printf("[Store] bad node address: %v", err)
-				continue
-			}
-			res = append(res, spec.CoreNode{
-				Address:  s_adr.String(),
-				Time:     unixTime,
-				Services: services,
-			})
+			res = append(res, node)
 		}
 		if err = rows.Err(); err != nil { // docs say this check is required!
-			return fmt.Errorf("[Store] query: %v", err)
+			return fmt.Errorf("[Store] query: %w", err)
 		}
 		return nil
 	})
 	return
 }
 
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+const sqliteCoreColumns = "address,CAST(time AS INTEGER),services,caps,skew,rtt,probed,version,agent,reachable,lat,lon,country,city,locsrc,located"
+
+// scanSQLiteCore scans sqliteCoreColumns into a CoreNode.
+func scanSQLiteCore(row rowScanner) (spec.CoreNode, error) {
+	var addr []byte
+	var node spec.CoreNode
+	err := row.Scan(&addr, &node.Time, &node.Services, &node.Caps, &node.Skew, &node.RTT, &node.Probed, &node.Version, &node.Agent, &node.Reachable,
+		&node.Lat, &node.Lon, &node.Country, &node.City, &node.LocSource, &node.Located)
+	if err != nil {
+		return node, err
+	}
+	s_adr, err := dnet.AddressFromBytes(addr)
+	if err != nil {
+		return node, fmt.Errorf("bad node address: %w", err)
+	}
+	node.Address = s_adr.String()
+	return node, nil
+}
+
+const netColumns = "node, address, channels, identity, time, seen, lat, lon, country, city, locsrc, located"
+
+// scanNetNode scans netColumns into a NetNode.
+func scanNetNode(row rowScanner) (spec.NetNode, error) {
+	var node []byte
+	var channels string
+	var n spec.NetNode
+	if err := row.Scan(&node, &n.Address, &channels, &n.Identity, &n.Time, &n.Seen,
+		&n.Lat, &n.Lon, &n.Country, &n.City, &n.LocSource, &n.Located); err != nil {
+		return n, err
+	}
+	n.PubKey = netNodePubKey(node)
+	n.Channels = splitChannels(channels)
+	return n, nil
+}
+
 // TrimNodes expires records after N days.
 //
 // To take account of the possibility that this software has not
@@ -264,22 +297,71 @@ func (s SQLiteStore) NodeList() (res []spec.CoreNode, err error) {
 // expiring all records) we use a system where:
 //
 // We keep a day counter that we increment once per day.
-// All records, when updated, store the current day counter + N.
-// Records expire once their stored day-count is < today.
+// All records, when updated, store the current day counter.
+// Records expire once their stored day-count + N is < today.
 //
 // This causes expiry to lag by the number of offline days.
-func (s SQLiteStore) TrimNodes() (advanced bool, remCore int64, err error) {
+// The counter lives in the `daycount` table; every node table
+// stores a `dayc` column and is trimmed here in the same way.
+// N depends on the class of node (see spec.Retention)
+func (s SQLiteStore) TrimNodes(retain spec.Retention) (advanced bool, remCore int64, remNet int64, err error) {
 	err = s.doTxn("TrimNodes", func(tx *sql.Tx) error {
+		// advance the day counter, at most once per day.
+		var dayc, day int64
+		err := tx.QueryRow("SELECT dayc, day FROM daycount WHERE id=1").Scan(&dayc, &day)
+		if err != nil {
+			return fmt.Errorf("TrimNodes: SELECT daycount: %w", err)
+		}
+		today := unixDayStamp(s.clock)
+		if today > day {
+			dayc++
+			_, err = tx.Exec("UPDATE daycount SET dayc=?, day=? WHERE id=1", dayc, today)
+			if err != nil {
+				return fmt.Errorf("TrimNodes: UPDATE daycount: %w", err)
+			}
+			advanced = true
+		}
 		// expire core nodes
-		unixTimeSec := time.Now().Unix()
-		expireBefore := unixTimeSec - spec.MaxCoreNodeDays*spec.SecondsPerDay
-		res, err := tx.Exec("DELETE FROM core WHERE time < ?", expireBefore)
+		expired := "(reachable AND dayc < ?1) OR (NOT reachable AND dayc < ?2)"
+		reachableBefore, gossipedBefore := dayc-retain.Reachable, dayc-retain.Gossiped
+		now := s.clock.Now().Unix()
+		err = goneCoreNodes(tx, "SELECT address FROM core WHERE "+expired, now, reachableBefore, gossipedBefore)
+		if err != nil {
+			return fmt.Errorf("TrimNodes: %w", err)
+		}
+		if retain.Archive {
+			_, err = tx.Exec("INSERT INTO core_archive (address,time,services,reachable,caps,skew,rtt,probed,lat,lon,country,city,locsrc,located,expired) SELECT address,time,services,reachable,caps,skew,rtt,probed,lat,lon,country,city,locsrc,located,?3 FROM core WHERE "+expired,
+				reachableBefore, gossipedBefore, now)
+			if err != nil {
+				return fmt.Errorf("TrimNodes: archive core: %w", err)
+			}
+		}
+		res, err := tx.Exec("DELETE FROM core WHERE "+expired, reachableBefore, gossipedBefore)
 		if err != nil {
-			return fmt.Errorf("TrimNodes: DELETE core: %v", err)
+			return fmt.Errorf("TrimNodes: DELETE core: %w", err)
 		}
 		remCore, err = res.RowsAffected()
 		if err != nil {
-			return fmt.Errorf("TrimNodes: rows-affected: %v", err)
+			return fmt.Errorf("TrimNodes: rows-affected: %w", err)
+		}
+		// expire dogenet nodes
+		identityBefore := dayc - retain.Identity
+		_, err = tx.Exec("UPDATE sighting SET gone=?1 WHERE gone=0 AND node IN (SELECT node FROM netnode WHERE dayc < ?2)", now, identityBefore)
+		if err != nil {
+			return fmt.Errorf("TrimNodes: sighting: %w", err)
+		}
+		res, err = tx.Exec("DELETE FROM netnode WHERE dayc < ?", identityBefore)
+		if err != nil {
+			return fmt.Errorf("TrimNodes: DELETE netnode: %w", err)
+		}
+		remNet, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("TrimNodes: rows-affected: %w", err)
+		}
+		// remove links to expired nodes
+		_, err = tx.Exec("DELETE FROM netlink WHERE node NOT IN (SELECT node FROM netnode) OR address NOT IN (SELECT address FROM core)")
+		if err != nil {
+			return fmt.Errorf("TrimNodes: DELETE netlink: %w", err)
 		}
 		return nil
 	})
@@ -287,21 +369,81 @@ func (s SQLiteStore) TrimNodes() (advanced bool, remCore int64, err error) {
 }
 
 func (s SQLiteStore) AddCoreNode(address Address, unixTimeSec int64, services uint64) error {
-	return s.doTxn("AddCoreNode", func(tx *sql.Tx) error {
-		addrKey := address.ToBytes()
-		res, err := tx.Exec("UPDATE core SET time=?, services=? WHERE address=?", unixTimeSec, services, addrKey)
+	_, _, err := s.AddCoreNodes([]spec.CoreAddr{{Address: address, Time: unixTimeSec, Services: services}})
+	return err
+}
+
+func (s SQLiteStore) AddCoreNodes(nodes []spec.CoreAddr) (added int, updated int, err error) {
+	err = s.doTxn("AddCoreNodes", func(tx *sql.Tx) error {
+		upd, err := tx.Prepare("UPDATE core SET time=?, services=?, dayc=(SELECT dayc FROM daycount WHERE id=1) WHERE address=?")
+		if err != nil {
+			return fmt.Errorf("prepare: %w", err)
+		}
+		defer upd.Close()
+		ins, err := tx.Prepare("INSERT INTO core (address, time, services, isnew, dayc) VALUES (?1,?2,?3,true,(SELECT dayc FROM daycount WHERE id=1))")
 		if err != nil {
-			return fmt.Errorf("update: %v", err)
+			return fmt.Errorf("prepare: %w", err)
 		}
-		num, err := res.RowsAffected()
+		defer ins.Close()
+		loc, err := tx.Prepare("UPDATE core SET lat=?, lon=?, country=?, city=?, locsrc=?, located=? WHERE address=?")
 		if err != nil {
-			return fmt.Errorf("rows-affected: %v", err)
+			return fmt.Errorf("prepare: %w", err)
 		}
-		if num == 0 {
-			_, e := tx.Exec("INSERT INTO core (address, time, services, isnew, dayc) VALUES (?1,?2,?3,true,0)",
-				addrKey, unixTimeSec, services)
-			if e != nil {
-				return fmt.Errorf("insert: %v", e)
+		defer loc.Close()
+		now := s.clock.Now().Unix()
+		for _, node := range nodes {
+			addrKey := node.Address.ToBytes()
+			res, err := upd.Exec(node.Time, node.Services, addrKey)
+			if err != nil {
+				return fmt.Errorf("update: %w", err)
+			}
+			num, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("rows-affected: %w", err)
+			}
+			if num == 0 {
+				_, e := ins.Exec(addrKey, node.Time, node.Services)
+				if e != nil {
+					return fmt.Errorf("insert: %w", e)
+				}
+				added++
+			} else {
+				updated++
+			}
+			if l := node.Location; l.Source != "" {
+				if _, err := loc.Exec(l.Lat, l.Lon, l.Country, l.City, l.Source, l.Found, addrKey); err != nil {
+					return fmt.Errorf("location: %w", err)
+				}
+			}
+			if err := sightNode(tx, spec.NodeIDFromAddress(node.Address), now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) ImportCoreNodes(nodes []spec.CoreNode) error {
+	return s.doTxn("ImportCoreNodes", func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO core (address, time, services, caps, skew, rtt, probed, version, agent, reachable, lat, lon, country, city, locsrc, located, isnew, dayc)
+VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,true,(SELECT dayc FROM daycount WHERE id=1))
+ON CONFLICT (address) DO UPDATE SET time=excluded.time, services=excluded.services, caps=excluded.caps, skew=excluded.skew, rtt=excluded.rtt,
+probed=excluded.probed, version=excluded.version, agent=excluded.agent, reachable=excluded.reachable, lat=excluded.lat, lon=excluded.lon,
+country=excluded.country, city=excluded.city, locsrc=excluded.locsrc, located=excluded.located, dayc=excluded.dayc`)
+		if err != nil {
+			return fmt.Errorf("prepare: %w", err)
+		}
+		defer stmt.Close()
+		for _, node := range nodes {
+			addr, err := dnet.ParseAddress(node.Address)
+			if err != nil {
+				return fmt.Errorf("invalid address: %v", node.Address)
+			}
+			_, err = stmt.Exec(addr.ToBytes(), node.Time, node.Services, node.Caps, node.Skew, node.RTT, node.Probed, node.Version, node.Agent,
+				node.Reachable, node.Lat, node.Lon, node.Country, node.City, node.LocSource, node.Located)
+			if err != nil {
+				return fmt.Errorf("upsert: %w", err)
 			}
 		}
 		return nil
@@ -311,17 +453,120 @@ func (s SQLiteStore) AddCoreNode(address Address, unixTimeSec int64, services ui
 func (s SQLiteStore) UpdateCoreTime(address Address) (err error) {
 	return s.doTxn("UpdateCoreTime", func(tx *sql.Tx) error {
 		addrKey := address.ToBytes()
-		unixTimeSec := time.Now().Unix()
-		_, err := tx.Exec("UPDATE core SET time=? WHERE address=?", unixTimeSec, addrKey)
+		unixTimeSec := s.clock.Now().Unix()
+		res, err := tx.Exec("UPDATE core SET time=?, reachable=true, dayc=(SELECT dayc FROM daycount WHERE id=1) WHERE address=?",
+			unixTimeSec, addrKey)
+		if err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+		if num, err := res.RowsAffected(); err != nil || num == 0 {
+			return err // unknown node: no sighting
+		}
+		return sightNode(tx, spec.NodeIDFromAddress(address), unixTimeSec)
+	})
+}
+
+func (s SQLiteStore) UpdateCoreProbe(address Address, probe spec.CoreProbe) error {
+	return s.doTxn("UpdateCoreProbe", func(tx *sql.Tx) error {
+		addrKey := address.ToBytes()
+		unixTimeSec := s.clock.Now().Unix()
+		_, err := tx.Exec("UPDATE core SET caps=?, skew=?, rtt=?, version=?, agent=?, probed=? WHERE address=?",
+			probe.Caps, probe.Skew, probe.RTT, probe.Version, probe.Agent, unixTimeSec, addrKey)
+		if err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s SQLiteStore) StaleLocations(source string, limit int) (res []Address, err error) {
+	err = s.readTxn("StaleLocations", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT address FROM core WHERE locsrc != ? LIMIT ?", source, limit)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var addr []byte
+			if err := rows.Scan(&addr); err != nil {
+				return fmt.Errorf("scan: %w", err)
+			}
+			a, err := dnet.AddressFromBytes(addr)
+			if err != nil {
+				log.Printf("[Store] bad node address: %v", err)
+				continue
+			}
+			res = append(res, a)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) UpdateCoreLocations(locs []spec.CoreLocation) error {
+	return s.doTxn("UpdateCoreLocations", func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("UPDATE core SET lat=?, lon=?, country=?, city=?, locsrc=?, located=? WHERE address=?")
 		if err != nil {
-			return fmt.Errorf("update: %v", err)
+			return fmt.Errorf("prepare: %w", err)
+		}
+		defer stmt.Close()
+		for _, cl := range locs {
+			l := cl.Location
+			if _, err := stmt.Exec(l.Lat, l.Lon, l.Country, l.City, l.Source, l.Found, cl.Address.ToBytes()); err != nil {
+				return fmt.Errorf("update: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s SQLiteStore) CoreNodesInBox(box spec.GeoBox) (res []spec.CoreNode, err error) {
+	err = s.readTxn("CoreNodesInBox", func(tx *sql.Tx) error {
+		for _, b := range box.Split() {
+			nodes, err := sqliteCoreInBox(tx, b)
+			if err != nil {
+				return err
+			}
+			res = append(res, nodes...)
 		}
 		return nil
 	})
+	return
+}
+
+// sqliteInBox selects the located rows of `table` inside a box ?1..?4
+// (minLat, maxLat, minLon, maxLon) using its spatial index, `table`_geo.
+func sqliteInBox(table string) string {
+	return "rowid IN (SELECT id FROM " + table + "_geo WHERE maxLat >= ?1 AND minLat <= ?2 AND maxLon >= ?3 AND minLon <= ?4) AND located AND lat BETWEEN ?1 AND ?2 AND lon BETWEEN ?3 AND ?4"
+}
+
+// sqliteCoreInBox queries core_geo for a box that does not cross the antimeridian.
+func sqliteCoreInBox(tx *sql.Tx, b spec.GeoBox) (res []spec.CoreNode, err error) {
+	rows, err := tx.Query("SELECT "+sqliteCoreColumns+" FROM core WHERE "+sqliteInBox("core"),
+		b.MinLat, b.MaxLat, b.MinLon, b.MaxLon)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		node, err := scanSQLiteCore(rows)
+		if err != nil {
+			log.Printf("[Store] CoreNodesInBox: %v", err)
+			continue
+		}
+		res = append(res, node)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return res, nil
 }
 
 func (s SQLiteStore) ChooseCoreNode() (res Address, err error) {
-	err = s.doTxn("ChooseCoreNode", func(tx *sql.Tx) error {
+	err = s.readTxn("ChooseCoreNode", func(tx *sql.Tx) error {
 		row := tx.QueryRow("SELECT address FROM core WHERE isnew=TRUE ORDER BY RANDOM() LIMIT 1")
 		var addr []byte
 		err := row.Scan(&addr)
@@ -330,17 +575,629 @@ func (s SQLiteStore) ChooseCoreNode() (res Address, err error) {
 				row = tx.QueryRow("SELECT address FROM core WHERE isnew=FALSE ORDER BY RANDOM() LIMIT 1")
 				err = row.Scan(&addr)
 				if err != nil {
-					return fmt.Errorf("query-not-new: %v", err)
+					if errors.Is(err, sql.ErrNoRows) {
+						return spec.NotFoundError
+					}
+					return fmt.Errorf("query-not-new: %w", err)
 				}
 			} else {
-				return fmt.Errorf("query-is-new: %v", err)
+				return fmt.Errorf("query-is-new: %w", err)
 			}
 		}
 		res, err = dnet.AddressFromBytes(addr)
 		if err != nil {
-			return fmt.Errorf("invalid address: %v", err)
+			return fmt.Errorf("invalid address: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) AddNetNodes(nodes []spec.NetNode) (added int, updated int, err error) {
+	return s.putNetNodes("AddNetNodes", nodes, false)
+}
+
+func (s SQLiteStore) ImportNetNodes(nodes []spec.NetNode) error {
+	_, _, err := s.putNetNodes("ImportNetNodes", nodes, true)
+	return err
+}
+
+// putNetNodes stores dogenet nodes and links them to core nodes on the
+// same host; imported nodes keep their Seen time, and are not sighted.
+func (s SQLiteStore) putNetNodes(name string, nodes []spec.NetNode, imported bool) (added int, updated int, err error) {
+	err = s.doTxn(name, func(tx *sql.Tx) error {
+		upd, err := tx.Prepare("UPDATE netnode SET address=?, channels=?, identity=?, time=?, seen=?, lat=?, lon=?, country=?, city=?, locsrc=?, located=?, dayc=(SELECT dayc FROM daycount WHERE id=1) WHERE node=?")
+		if err != nil {
+			return fmt.Errorf("prepare: %w", err)
+		}
+		defer upd.Close()
+		ins, err := tx.Prepare("INSERT INTO netnode (node, address, channels, identity, time, seen, lat, lon, country, city, locsrc, located, dayc) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,(SELECT dayc FROM daycount WHERE id=1))")
+		if err != nil {
+			return fmt.Errorf("prepare: %w", err)
+		}
+		defer ins.Close()
+		link, err := tx.Prepare("INSERT INTO netlink (node, address, seen) SELECT ?1, address, ?2 FROM core WHERE address BETWEEN ?3 AND ?4 ON CONFLICT (node, address) DO UPDATE SET seen=excluded.seen")
+		if err != nil {
+			return fmt.Errorf("prepare: %w", err)
+		}
+		defer link.Close()
+		now := s.clock.Now().Unix()
+		for _, node := range nodes {
+			id, err := node.NodeID()
+			if err != nil {
+				return err
+			}
+			channels := strings.Join(node.Channels, ",")
+			seen := now
+			if imported {
+				seen = node.Seen
+			}
+			res, err := upd.Exec(node.Address, channels, node.Identity, node.Time, seen,
+				node.Lat, node.Lon, node.Country, node.City, node.LocSource, node.Located, id[:])
+			if err != nil {
+				return fmt.Errorf("update: %w", err)
+			}
+			num, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("rows-affected: %w", err)
+			}
+			if num == 0 {
+				_, e := ins.Exec(id[:], node.Address, channels, node.Identity, node.Time, seen,
+					node.Lat, node.Lon, node.Country, node.City, node.LocSource, node.Located)
+				if e != nil {
+					return fmt.Errorf("insert: %w", e)
+				}
+				added++
+			} else {
+				updated++
+			}
+			if lo, hi, ok := hostRange(node.Address); ok {
+				if _, err := link.Exec(id[:], now, lo, hi); err != nil {
+					return fmt.Errorf("link: %w", err)
+				}
+			}
+			if imported {
+				continue
+			}
+			if err := sightNode(tx, id, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) NetNodeList() (res []spec.NetNode, err error) {
+	err = s.readTxn("NetNodeList", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT " + netColumns + " FROM netnode")
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		res, err = scanNetNodes(rows)
+		return err
+	})
+	return
+}
+
+func (s SQLiteStore) NetNodesInBox(box spec.GeoBox) (res []spec.NetNode, err error) {
+	err = s.readTxn("NetNodesInBox", func(tx *sql.Tx) error {
+		for _, b := range box.Split() {
+			rows, err := tx.Query("SELECT "+netColumns+" FROM netnode WHERE "+sqliteInBox("netnode"),
+				b.MinLat, b.MaxLat, b.MinLon, b.MaxLon)
+			if err != nil {
+				return fmt.Errorf("query: %w", err)
+			}
+			nodes, err := scanNetNodes(rows)
+			if err != nil {
+				return err
+			}
+			res = append(res, nodes...)
+		}
+		return nil
+	})
+	return
+}
+
+// scanNetNodes scans and closes `rows` of netColumns.
+func scanNetNodes(rows *sql.Rows) (res []spec.NetNode, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		n, err := scanNetNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		res = append(res, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return res, nil
+}
+
+func (s SQLiteStore) NodeInfo(id NodeID) (res spec.NodeInfo, err error) {
+	err = s.readTxn("NodeInfo", func(tx *sql.Tx) error {
+		res = spec.NodeInfo{ID: id.String(), Linked: []string{}}
+		var links *sql.Rows
+		if addr, isCore := id.Address(); isCore {
+			node, err := scanSQLiteCore(tx.QueryRow("SELECT "+sqliteCoreColumns+" FROM core WHERE address=?", addr.ToBytes()))
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return spec.NotFoundError
+				}
+				return fmt.Errorf("query: %w", err)
+			}
+			res.Core = &node
+			links, err = tx.Query("SELECT node FROM netlink WHERE address=?", addr.ToBytes())
+			if err != nil {
+				return fmt.Errorf("links: %w", err)
+			}
+		} else {
+			node, err := scanNetNode(tx.QueryRow("SELECT "+netColumns+" FROM netnode WHERE node=?", id[:]))
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return spec.NotFoundError
+				}
+				return fmt.Errorf("query: %w", err)
+			}
+			res.Net = &node
+			links, err = tx.Query("SELECT address FROM netlink WHERE node=?", id[:])
+			if err != nil {
+				return fmt.Errorf("links: %w", err)
+			}
+		}
+		defer links.Close()
+		for links.Next() {
+			var linked []byte
+			if err := links.Scan(&linked); err != nil {
+				return fmt.Errorf("links: scan: %w", err)
+			}
+			if res.Net != nil {
+				core, err := coreNodeID(linked)
+				if err != nil {
+					return fmt.Errorf("links: %w", err)
+				}
+				linked = core[:]
+			}
+			res.Linked = append(res.Linked, hex.EncodeToString(linked))
+		}
+		if err := links.Err(); err != nil {
+			return fmt.Errorf("links: rows: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) NodeLinks() (res []spec.NodeLink, err error) {
+	err = s.readTxn("NodeLinks", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT node, address, seen FROM netlink")
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		res, err = scanNodeLinks(rows)
+		return err
+	})
+	return
+}
+
+func (s SQLiteStore) NodeLinksInBox(box spec.GeoBox) (res []spec.NodeLink, err error) {
+	err = s.readTxn("NodeLinksInBox", func(tx *sql.Tx) error {
+		// a link can span both halves of a split box
+		seen := make(map[[2]NodeID]bool)
+		for _, b := range box.Split() {
+			rows, err := tx.Query("SELECT l.node, l.address, l.seen FROM netlink l JOIN netnode n ON n.node = l.node WHERE n.located AND "+
+				"(l.node IN (SELECT node FROM netnode WHERE "+sqliteInBox("netnode")+") OR l.address IN (SELECT address FROM core WHERE "+sqliteInBox("core")+"))",
+				b.MinLat, b.MaxLat, b.MinLon, b.MaxLon)
+			if err != nil {
+				return fmt.Errorf("query: %w", err)
+			}
+			links, err := scanNodeLinks(rows)
+			if err != nil {
+				return err
+			}
+			for _, l := range links {
+				if key := [2]NodeID{l.Node, l.Core}; !seen[key] {
+					seen[key] = true
+					res = append(res, l)
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// scanNodeLinks scans and closes `rows` of netlink (node, address, seen).
+func scanNodeLinks(rows *sql.Rows) (res []spec.NodeLink, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var node, addr []byte
+		var l spec.NodeLink
+		if err := rows.Scan(&node, &addr, &l.Seen); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		copy(l.Node[:], node)
+		if l.Core, err = coreNodeID(addr); err != nil {
+			log.Printf("[Store] bad node address: %v", err)
+			continue
+		}
+		res = append(res, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	return res, nil
+}
+
+func (s SQLiteStore) NodeHistory(id NodeID) (res NodeHistory, err error) {
+	err = s.readTxn("NodeHistory", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT first_seen, last_seen, gone FROM sighting WHERE node=? ORDER BY first_seen", id[:])
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		defer rows.Close()
+		var intervals []spec.Sighting
+		for rows.Next() {
+			var si spec.Sighting
+			if err := rows.Scan(&si.First, &si.Last, &si.Gone); err != nil {
+				return fmt.Errorf("scan: %w", err)
+			}
+			intervals = append(intervals, si)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows: %w", err)
+		}
+		if len(intervals) == 0 {
+			return spec.NotFoundError
+		}
+		res = spec.MakeNodeHistory(id, intervals)
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) NetNodeCount() (count int, err error) {
+	err = s.readTxn("NetNodeCount", func(tx *sql.Tx) error {
+		err := tx.QueryRow("SELECT COUNT(*) FROM netnode").Scan(&count)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) IdentityProfiles() (res []spec.IdentityProfile, err error) {
+	err = s.readTxn("IdentityProfiles", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT identity, lat, lon, country, city, profile, fetched, changed FROM identity")
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p spec.IdentityProfile
+			var profile string
+			if err := rows.Scan(&p.Identity, &p.Lat, &p.Lon, &p.Country, &p.City, &profile, &p.Fetched, &p.Changed); err != nil {
+				return fmt.Errorf("scan: %w", err)
+			}
+			p.Profile = json.RawMessage(profile)
+			res = append(res, p)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) UpdateIdentities(profiles []spec.IdentityProfile) (changed int, err error) {
+	err = s.doTxn("UpdateIdentities", func(tx *sql.Tx) error {
+		now := s.clock.Now().Unix()
+		for _, p := range profiles {
+			var old string
+			err := tx.QueryRow("SELECT profile FROM identity WHERE identity=?", p.Identity).Scan(&old)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("query: %w", err)
+			}
+			if err == nil && old == string(p.Profile) {
+				_, err = tx.Exec("UPDATE identity SET fetched=? WHERE identity=?", now, p.Identity)
+				if err != nil {
+					return fmt.Errorf("update: %w", err)
+				}
+				continue
+			}
+			_, err = tx.Exec("INSERT INTO identity (identity, lat, lon, country, city, profile, fetched, changed) VALUES (?,?,?,?,?,?,?,?) ON CONFLICT (identity) DO UPDATE SET lat=excluded.lat, lon=excluded.lon, country=excluded.country, city=excluded.city, profile=excluded.profile, fetched=excluded.fetched, changed=excluded.changed",
+				p.Identity, p.Lat, p.Lon, p.Country, p.City, string(p.Profile), now, now)
+			if err != nil {
+				return fmt.Errorf("upsert: %w", err)
+			}
+			_, err = tx.Exec("INSERT INTO identity_history (identity, time, profile) VALUES (?,?,?)", p.Identity, now, string(p.Profile))
+			if err != nil {
+				return fmt.Errorf("history: %w", err)
+			}
+			changed++
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) TrimIdentities(before int64) (removed int64, err error) {
+	err = s.doTxn("TrimIdentities", func(tx *sql.Tx) error {
+		now := s.clock.Now().Unix()
+		_, err := tx.Exec("INSERT INTO identity_history (identity, time, profile) SELECT identity, ?, '' FROM identity WHERE fetched < ?", now, before)
+		if err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+		res, err := tx.Exec("DELETE FROM identity WHERE fetched < ?", before)
+		if err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		removed, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows-affected: %w", err)
+		}
+		// the history of identities removed before `before`
+		_, err = tx.Exec("DELETE FROM identity_history WHERE identity NOT IN (SELECT identity FROM identity) AND identity IN (SELECT identity FROM identity_history GROUP BY identity HAVING max(time) < ?)", before)
+		if err != nil {
+			return fmt.Errorf("delete-history: %w", err)
 		}
 		return nil
 	})
 	return
 }
+
+func (s SQLiteStore) IdentityHistory(identity string) (res []spec.IdentityChange, err error) {
+	err = s.readTxn("IdentityHistory", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT time, profile FROM identity_history WHERE identity=? ORDER BY time", identity)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c spec.IdentityChange
+			var profile string
+			if err := rows.Scan(&c.Time, &profile); err != nil {
+				return fmt.Errorf("scan: %w", err)
+			}
+			if profile != "" { // removed
+				c.Profile = json.RawMessage(profile)
+			}
+			res = append(res, c)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows: %w", err)
+		}
+		if len(res) == 0 {
+			return spec.NotFoundError
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) SetSearchIndex(docs []spec.SearchDoc) error {
+	return s.doTxn("SetSearchIndex", func(tx *sql.Tx) error {
+		// FTS4 cannot look up by id (it is not indexed), so read them all.
+		rows, err := tx.Query("SELECT rowid, id, kind, text FROM search")
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		defer rows.Close()
+		indexed := make(map[string]spec.SearchDoc)
+		rowids := make(map[string]int64)
+		for rows.Next() {
+			var rowid int64
+			var doc spec.SearchDoc
+			if err := rows.Scan(&rowid, &doc.ID, &doc.Kind, &doc.Text); err != nil {
+				return fmt.Errorf("scan: %w", err)
+			}
+			indexed[doc.ID] = doc
+			rowids[doc.ID] = rowid
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows: %w", err)
+		}
+		changed, removed := diffSearchIndex(indexed, docs)
+		for _, id := range removed {
+			_, err = tx.Exec("DELETE FROM search WHERE rowid=?", rowids[id])
+			if err != nil {
+				return fmt.Errorf("delete: %w", err)
+			}
+		}
+		for _, doc := range changed {
+			if rowid, found := rowids[doc.ID]; found {
+				_, err = tx.Exec("UPDATE search SET kind=?, text=? WHERE rowid=?", doc.Kind, doc.Text, rowid)
+				if err != nil {
+					return fmt.Errorf("update: %w", err)
+				}
+				continue
+			}
+			_, err = tx.Exec("INSERT INTO search (id, kind, text) VALUES (?,?,?)", doc.ID, doc.Kind, doc.Text)
+			if err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s SQLiteStore) Search(query string, limit int) (res []spec.SearchHit, err error) {
+	terms := spec.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	// every term as a prefix, e.g. `lond* 22556*` (terms are only
+	// letters and digits, so they need no quoting)
+	match := strings.Join(terms, "* ") + "*"
+	var docs []spec.SearchDoc
+	err = s.readTxn("Search", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, kind, text FROM search WHERE search MATCH ?", match)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var doc spec.SearchDoc
+			if err := rows.Scan(&doc.ID, &doc.Kind, &doc.Text); err != nil {
+				return fmt.Errorf("scan: %w", err)
+			}
+			docs = append(docs, doc)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spec.RankSearch(terms, docs, limit), nil
+}
+
+// censusTable returns the rollup table for a census interval.
+func censusTable(interval string) (string, error) {
+	if _, found := spec.CensusIntervals[interval]; !found {
+		return "", spec.NewErr(spec.DBProblem, "unknown census interval: %v", interval)
+	}
+	return "census_" + interval, nil
+}
+
+func (s SQLiteStore) AddCensus(interval string, time int64, counts []spec.CensusCount) error {
+	table, err := censusTable(interval)
+	if err != nil {
+		return err
+	}
+	return s.doTxn("AddCensus", func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE time=?", time)
+		if err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		stmt, err := tx.Prepare("INSERT INTO " + table + " (time, dimension, label, count) VALUES (?,?,?,?)")
+		if err != nil {
+			return fmt.Errorf("prepare: %w", err)
+		}
+		defer stmt.Close()
+		for _, c := range counts {
+			if _, err := stmt.Exec(time, c.Dimension, c.Label, c.Count); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s SQLiteStore) CensusSeries(interval string, dimension string, from int64, to int64) (res []spec.CensusCount, err error) {
+	table, err := censusTable(interval)
+	if err != nil {
+		return nil, err
+	}
+	err = s.readTxn("CensusSeries", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT time, dimension, label, count FROM "+table+" WHERE dimension=? AND time>=? AND time<=? ORDER BY time, label",
+			dimension, from, to)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c spec.CensusCount
+			if err := rows.Scan(&c.Time, &c.Dimension, &c.Label, &c.Count); err != nil {
+				return fmt.Errorf("scan: %w", err)
+			}
+			res = append(res, c)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("rows: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+// sightNode extends the node's open sighting interval, or opens a new one.
+func sightNode(tx *sql.Tx, id NodeID, now int64) error {
+	res, err := tx.Exec("UPDATE sighting SET last_seen=? WHERE node=? AND gone=0", now, id[:])
+	if err != nil {
+		return fmt.Errorf("sighting: update: %w", err)
+	}
+	num, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("sighting: rows-affected: %w", err)
+	}
+	if num == 0 {
+		_, err = tx.Exec("INSERT INTO sighting (node, kind, first_seen, last_seen, gone) VALUES (?,?,?,?,0)", id[:], id.Kind(), now, now)
+		if err != nil {
+			return fmt.Errorf("sighting: insert: %w", err)
+		}
+	}
+	return nil
+}
+
+// coreNodeID returns the NodeID of a stored core.address
+func coreNodeID(addr []byte) (NodeID, error) {
+	a, err := dnet.AddressFromBytes(addr)
+	if err != nil {
+		return NodeID{}, err
+	}
+	return spec.NodeIDFromAddress(a), nil
+}
+
+// hostRange returns the range of core.address keys on the host of
+// `address` (any port), for linking dogenet nodes to core nodes.
+func hostRange(address string) (lo []byte, hi []byte, ok bool) {
+	addr, err := dnet.ParseAddress(address)
+	if err != nil {
+		return nil, nil, false
+	}
+	lo = spec.Address{Host: addr.Host.To16(), Port: 0}.ToBytes()
+	hi = spec.Address{Host: addr.Host.To16(), Port: 0xFFFF}.ToBytes()
+	return lo, hi, true
+}
+
+// netNodePubKey returns the pubkey hex of a stored dogenet NodeID.
+func netNodePubKey(node []byte) string {
+	if len(node) < 1 {
+		return ""
+	}
+	return hex.EncodeToString(node[1:])
+}
+
+// splitChannels parses the stored form of NetNode.Channels.
+func splitChannels(channels string) []string {
+	if channels == "" {
+		return []string{}
+	}
+	return strings.Split(channels, ",")
+}
+
+// goneCoreNodes closes the open sighting intervals of the core nodes
+// selected by `query` (which must select core.address)
+func goneCoreNodes(tx *sql.Tx, query string, now int64, args ...any) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("sighting: query: %w", err)
+	}
+	var gone []NodeID
+	for rows.Next() {
+		var addr []byte
+		if err := rows.Scan(&addr); err != nil {
+			rows.Close()
+			return fmt.Errorf("sighting: scan: %w", err)
+		}
+		if a, err := dnet.AddressFromBytes(addr); err == nil {
+			gone = append(gone, spec.NodeIDFromAddress(a))
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("sighting: rows: %w", err)
+	}
+	for _, id := range gone {
+		if _, err := tx.Exec("UPDATE sighting SET gone=? WHERE node=? AND gone=0", now, id[:]); err != nil {
+			return fmt.Errorf("sighting: update: %w", err)
+		}
+	}
+	return nil
+}
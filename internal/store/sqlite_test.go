@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/spec/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) spec.Store {
+		return openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	})
+}
+
+func TestSQLiteReadOnly(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	db := openTestSQLite(t, file)
+	if err := db.AddCoreNode(storetest.Addr(1), 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := NewSQLiteStoreReadOnly(file, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.(*SQLiteStore).Close()
+	nodes, err := ro.NodeList()
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("expected one node, got %v %v", nodes, err)
+	}
+	storetest.CheckErr(t, ro.AddCoreNode(storetest.Addr(2), 1000, 1), spec.ReadOnly)
+	// the search index is part of the schema, so it can be searched
+	// before the crawler first indexes.
+	if hits, err := ro.Search("node", 10); err != nil || len(hits) != 0 {
+		t.Fatalf("expected no search results, got %v %v", hits, err)
+	}
+}
+
+// VACUUM INTO can renumber core and netnode rowids, so a restored backup
+// must rebuild the spatial indexes.
+func TestSQLiteRestoreGeo(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.db")
+	db := openTestSQLite(t, file)
+	now := time.Now().Unix()
+	sydney := spec.Location{Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", Source: "geoip:1", Found: true}
+	var addrs []spec.CoreAddr
+	for i := 1; i <= 20; i++ {
+		addrs = append(addrs, spec.CoreAddr{Address: storetest.Addr(i), Time: now, Services: 1, Location: sydney})
+	}
+	if _, _, err := db.AddCoreNodes(addrs); err != nil {
+		t.Fatal(err)
+	}
+	var boxes []spec.NetNode
+	for i := 1; i <= 20; i++ {
+		n := storetest.Net(100 + i)
+		n.SetLocation(sydney)
+		boxes = append(boxes, n)
+	}
+	if _, _, err := db.AddNetNodes(boxes); err != nil {
+		t.Fatal(err)
+	}
+	// leave gaps in the rowids, and indexes that do not match them.
+	for _, table := range []string{"core", "netnode"} {
+		if _, err := db.(*SQLiteStore).db.Exec("DELETE FROM " + table + " WHERE rowid % 2 = 1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.(*SQLiteStore).db.Exec("DELETE FROM " + table + "_geo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backup := filepath.Join(dir, "backup.db")
+	if err := db.(Backuper).Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(dir, "restored.db")
+	if err := RestoreSQLite(backup, restored); err != nil {
+		t.Fatal(err)
+	}
+	rs := openTestSQLite(t, restored)
+	nodes, err := rs.CoreNodesInBox(spec.BoxAround(sydney.Lat, sydney.Lon, 10))
+	if err != nil || len(nodes) != 10 {
+		t.Fatalf("expected 10 nodes in the restored index, got %d %v", len(nodes), err)
+	}
+	netNodes, err := rs.NetNodesInBox(spec.BoxAround(sydney.Lat, sydney.Lon, 10))
+	if err != nil || len(netNodes) != 10 {
+		t.Fatalf("expected 10 dogeboxes in the restored index, got %d %v", len(netNodes), err)
+	}
+}
+
+// The day counter (see TrimNodes) is stored in the database, so a
+// restart does not expire nodes that were kept while the crawler was down.
+func TestSQLiteDayCounterReopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	clk := clock.NewFake(time.Now())
+	day := time.Duration(spec.SecondsPerDay) * time.Second
+	retain := spec.Retention{Gossiped: 2, Reachable: 2, Identity: 2}
+	trim := func(db spec.Store, expectAdvanced bool, expectRemoved int64) {
+		t.Helper()
+		advanced, remCore, _, err := db.TrimNodes(retain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if advanced != expectAdvanced || remCore != expectRemoved {
+			t.Fatalf("TrimNodes: expected advanced=%v removed=%d, got advanced=%v removed=%d",
+				expectAdvanced, expectRemoved, advanced, remCore)
+		}
+	}
+
+	db := openTestSQLite(t, file).WithClock(clk)
+	if err := db.AddCoreNode(storetest.Addr(1), clk.Now().Unix(), 1); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(day)
+	trim(db, true, 0)
+	db.(*SQLiteStore).Close()
+
+	// down for 10 days: the counter resumes where it was.
+	clk.Advance(10 * day)
+	db = openTestSQLite(t, file).WithClock(clk)
+	trim(db, true, 0)
+	trim(db, false, 0)
+	clk.Advance(day)
+	trim(db, true, 1)
+}
+
+func TestDbErr(t *testing.T) {
+	tests := []struct {
+		code sqlite3.ErrNo
+		want spec.ErrorCode
+	}{
+		{sqlite3.ErrConstraint, spec.AlreadyExists},
+		{sqlite3.ErrBusy, spec.DBConflict},
+		{sqlite3.ErrLocked, spec.DBConflict},
+		{sqlite3.ErrCorrupt, spec.DBProblem},
+	}
+	for _, tc := range tests {
+		err := dbErr(fmt.Errorf("query: %w", sqlite3.Error{Code: tc.code}), "TestDbErr")
+		storetest.CheckErr(t, err, tc.want)
+		var sqErr sqlite3.Error
+		if !errors.As(err, &sqErr) {
+			t.Errorf("%v: cause was not wrapped: %v", tc.code, err)
+		}
+	}
+	storetest.CheckErr(t, dbErr(errors.New("disk full"), "TestDbErr"), spec.DBProblem)
+
+	// already-mapped errors are returned unchanged.
+	notFound := spec.NewErr(spec.NotFound, "not found")
+	if dbErr(notFound, "TestDbErr") != notFound {
+		t.Error("dbErr re-wrapped a spec error")
+	}
+}
+
+func openTestSQLite(t *testing.T, file string) spec.Store {
+	t.Helper()
+	db, err := NewSQLiteStore(file, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.(*SQLiteStore).Close)
+	return db
+}
@@ -4,35 +4,53 @@ import (
 	"log"
 	"time"
 
+	"code.dogecoin.org/dogemap-backend/internal/clock"
 	"code.dogecoin.org/dogemap-backend/internal/spec"
 	"code.dogecoin.org/governor"
 )
 
-func NewStoreTrimmer(store spec.Store) governor.Service {
+func NewStoreTrimmer(store spec.Store, retain spec.Retention) *StoreTrimmer {
 	return &StoreTrimmer{
-		store: store,
+		store:  store,
+		retain: retain,
+		clock:  clock.System,
 	}
 }
 
 type StoreTrimmer struct {
 	governor.ServiceCtx
-	store spec.Store
+	store  spec.Store
+	retain spec.Retention
+	clock  clock.Clock
+}
+
+// WithClock replaces clock.System, for the store and the schedule.
+func (sv *StoreTrimmer) WithClock(clk clock.Clock) *StoreTrimmer {
+	sv.clock = clk
+	return sv
 }
 
 // goroutine
 func (sv *StoreTrimmer) Run() {
-	store := sv.store.WithCtx(sv.Context)
-	sv.Sleep(1 * time.Minute)
+	store := sv.store.WithCtx(sv.Context).WithClock(sv.clock)
+	sv.clock.Sleep(sv.Context, 1*time.Minute)
 	for !sv.Stopping() {
-		advanced, remCore, err := store.TrimNodes()
+		advanced, remCore, remNet, err := store.TrimNodes(sv.retain)
 		if err != nil {
 			log.Printf("[store] TrimNodes: %v", err)
 		} else {
 			if advanced {
 				log.Printf("[store] TrimNodes: day-count has advanced.")
 			}
-			log.Printf("[store] TrimNodes: trimmed %v core nodes", remCore)
+			if sv.retain.Archive {
+				log.Printf("[store] TrimNodes: archived %v core nodes", remCore)
+			} else {
+				log.Printf("[store] TrimNodes: trimmed %v core nodes", remCore)
+			}
+			if remNet > 0 {
+				log.Printf("[store] TrimNodes: trimmed %v dogenet nodes", remNet)
+			}
 		}
-		sv.Sleep(1 * time.Hour) // once an hour is enough
+		sv.clock.Sleep(sv.Context, 1*time.Hour) // once an hour is enough
 	}
 }
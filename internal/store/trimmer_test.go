@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/spec/storetest"
+)
+
+// runService runs a governor service's Run() until the test ends.
+func runService(t *testing.T, ctx *context.Context, run func()) {
+	t.Helper()
+	c, cancel := context.WithCancel(context.Background())
+	*ctx = c
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("service did not stop")
+		}
+	})
+}
+
+// waitSleeping waits for a service to block in its clock.
+func waitSleeping(t *testing.T, clk *clock.Fake) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for clk.Sleepers() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the service to sleep")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStoreTrimmer(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	day := time.Duration(spec.SecondsPerDay) * time.Second
+	db := NewMemoryStore(context.Background()).WithClock(clk)
+	now := clk.Now().Unix()
+	if err := db.AddCoreNode(storetest.Addr(1), now, 1); err != nil { // gossiped only
+		t.Fatal(err)
+	}
+	if err := db.AddCoreNode(storetest.Addr(2), now, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateCoreTime(storetest.Addr(2)); err != nil { // reachable
+		t.Fatal(err)
+	}
+	sv := NewStoreTrimmer(db, spec.Retention{Gossiped: 1, Reachable: 3, Identity: 3}).WithClock(clk)
+	runService(t, &sv.Context, sv.Run)
+
+	nodes := func() int {
+		t.Helper()
+		list, err := db.NodeList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(list)
+	}
+	// the trimmer waits a minute, then trims hourly; the day
+	// counter advances at most once per trim.
+	waitSleeping(t, clk)
+	clk.Advance(day)
+	waitSleeping(t, clk)
+	if n := nodes(); n != 2 {
+		t.Fatalf("expected 2 nodes after one day, got %d", n)
+	}
+	clk.Advance(day)
+	waitSleeping(t, clk)
+	if n := nodes(); n != 1 {
+		t.Fatalf("expected the gossiped node to expire after 1 day, got %d nodes", n)
+	}
+	for i := 0; i < 2; i++ {
+		clk.Advance(day)
+		waitSleeping(t, clk)
+	}
+	if n := nodes(); n != 0 {
+		t.Fatalf("expected the reachable node to expire after 3 days, got %d nodes", n)
+	}
+}
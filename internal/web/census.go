@@ -0,0 +1,97 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// Number of intervals returned by /history/counts when `from` is omitted.
+const DefaultCensusPoints = 30
+
+// Limit on the number of intervals in one /history/counts response.
+const MaxCensusPoints = 10000
+
+type CensusSeries struct {
+	Interval string        `json:"interval"` // "hour" or "day"
+	GroupBy  string        `json:"groupBy"`  // census dimension (see spec.CensusDimensions)
+	From     int64         `json:"from"`
+	To       int64         `json:"to"`
+	Series   []CensusPoint `json:"series"` // oldest first; only intervals with a snapshot
+}
+
+type CensusPoint struct {
+	Time   int64            `json:"time"`   // start of the interval
+	Counts map[string]int64 `json:"counts"` // node count per label
+}
+
+// getCensusCounts serves the network census time series.
+// Query: ?from=<unix>&to=<unix>&interval=hour|day&groupBy=<dimension>
+func (a *WebAPI) getCensusCounts(w http.ResponseWriter, r *http.Request) {
+	options := "GET, OPTIONS"
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		res := CensusSeries{Interval: "day", GroupBy: spec.CensusTotal, To: a.clock.Now().Unix(), Series: []CensusPoint{}}
+		if arg := query.Get("interval"); arg != "" {
+			res.Interval = arg
+		}
+		secs, found := spec.CensusIntervals[res.Interval]
+		if !found {
+			sendError(w, http.StatusBadRequest, "bad-request", "invalid interval", options)
+			return
+		}
+		if arg := query.Get("groupBy"); arg != "" {
+			res.GroupBy = arg
+		}
+		if !isCensusDimension(res.GroupBy) {
+			sendError(w, http.StatusBadRequest, "bad-request", "invalid groupBy", options)
+			return
+		}
+		if arg := query.Get("to"); arg != "" {
+			val, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				sendError(w, http.StatusBadRequest, "bad-request", "invalid to", options)
+				return
+			}
+			res.To = val
+		}
+		res.From = res.To - DefaultCensusPoints*secs
+		if arg := query.Get("from"); arg != "" {
+			val, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				sendError(w, http.StatusBadRequest, "bad-request", "invalid from", options)
+				return
+			}
+			res.From = val
+		}
+		if res.From > res.To || (res.To-res.From)/secs > MaxCensusPoints {
+			sendError(w, http.StatusBadRequest, "bad-request", "invalid range", options)
+			return
+		}
+		counts, err := a.store.CensusSeries(res.Interval, res.GroupBy, res.From, res.To)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		for _, c := range counts {
+			if n := len(res.Series); n == 0 || res.Series[n-1].Time != c.Time {
+				res.Series = append(res.Series, CensusPoint{Time: c.Time, Counts: map[string]int64{}})
+			}
+			res.Series[len(res.Series)-1].Counts[c.Label] = c.Count
+		}
+		sendJson(w, res, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
+
+func isCensusDimension(dim string) bool {
+	for _, d := range spec.CensusDimensions {
+		if d == dim {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// Defaults for /nodes/near when `radiusKm` or `limit` is omitted.
+const DefaultNearRadiusKm = 100
+const DefaultNearLimit = 50
+
+// Limits on /nodes/near: half the Earth's circumference, and the number
+// of nodes in one response.
+const MaxNearRadiusKm = 20016
+const MaxNearLimit = 1000
+
+type NearNode struct {
+	MapNode
+	DistanceKm float64 `json:"distanceKm"` // great-circle distance (see spec.DistanceKm)
+}
+
+// boxMapNodes returns the map nodes inside `box` (and DogeBoxes outside
+// it that absorb Core Nodes inside it), using the spatial indexes (see
+// Store.CoreNodesInBox, Store.NetNodesInBox and Store.NodeLinksInBox).
+func (a *WebAPI) boxMapNodes(box spec.GeoBox) ([]MapNode, error) {
+	coreNodes, err := a.store.CoreNodesInBox(box)
+	if err != nil {
+		return nil, err
+	}
+	netNodes, err := a.store.NetNodesInBox(box)
+	if err != nil {
+		return nil, err
+	}
+	links, err := a.store.NodeLinksInBox(box)
+	if err != nil {
+		return nil, err
+	}
+	return mapNodes(coreNodes, netNodes, links), nil
+}
+
+// getNodesInBox returns the map nodes inside `box`, ordered by distance
+// from its center.
+func (a *WebAPI) getNodesInBox(w http.ResponseWriter, box spec.GeoBox, options string) {
+	nodes, err := a.boxMapNodes(box)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	lat, lon := box.Center()
+	res := make([]MapNode, 0, len(nodes))
+	for _, node := range nearNodes(nodes, lat, lon) {
+		nlat, nlon, _ := mapNodeLocation(node.MapNode)
+		if box.Contains(nlat, nlon) {
+			res = append(res, node.MapNode)
+		}
+	}
+	sendJson(w, res, options)
+}
+
+// getNearNodes returns the map nodes within `radiusKm` of a location,
+// nearest first.
+// Query: ?lat=<deg>&lon=<deg>&radiusKm=<km>&limit=<n>
+func (a *WebAPI) getNearNodes(w http.ResponseWriter, r *http.Request) {
+	options := "GET, OPTIONS"
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "bad-request", "invalid lat", options)
+			return
+		}
+		lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "bad-request", "invalid lon", options)
+			return
+		}
+		if !spec.ValidLatLon(lat, lon) {
+			sendError(w, http.StatusBadRequest, "bad-request", "expecting longitude -180..180 and latitude -90..90", options)
+			return
+		}
+		radius := float64(DefaultNearRadiusKm)
+		if arg := query.Get("radiusKm"); arg != "" {
+			val, err := strconv.ParseFloat(arg, 64)
+			if err != nil || !(val > 0 && val <= MaxNearRadiusKm) {
+				sendError(w, http.StatusBadRequest, "bad-request", "invalid radiusKm", options)
+				return
+			}
+			radius = val
+		}
+		limit := DefaultNearLimit
+		if arg := query.Get("limit"); arg != "" {
+			val, err := strconv.Atoi(arg)
+			if err != nil || val < 1 || val > MaxNearLimit {
+				sendError(w, http.StatusBadRequest, "bad-request", "invalid limit", options)
+				return
+			}
+			limit = val
+		}
+		nodes, err := a.boxMapNodes(spec.BoxAround(lat, lon, radius))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		res := make([]NearNode, 0, limit)
+		for _, node := range nearNodes(nodes, lat, lon) {
+			if node.DistanceKm > radius || len(res) == limit {
+				break
+			}
+			res = append(res, node)
+		}
+		sendJson(w, res, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
+
+// nearNodes orders map nodes by distance from lat, lon, nearest first;
+// nodes without a location are omitted.
+func nearNodes(nodes []MapNode, lat float64, lon float64) []NearNode {
+	res := make([]NearNode, 0, len(nodes))
+	for _, node := range nodes {
+		nlat, nlon, ok := mapNodeLocation(node)
+		if !ok {
+			continue
+		}
+		res = append(res, NearNode{MapNode: node, DistanceKm: spec.DistanceKm(lat, lon, nlat, nlon)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].DistanceKm != res[j].DistanceKm {
+			return res[i].DistanceKm < res[j].DistanceKm
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// mapNodeLocation parses a MapNode's location (false if it has none)
+func mapNodeLocation(node MapNode) (lat float64, lon float64, ok bool) {
+	lat, err := strconv.ParseFloat(node.Lat, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lon, err = strconv.ParseFloat(node.Lon, 64)
+	if err != nil || !spec.ValidLatLon(lat, lon) {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
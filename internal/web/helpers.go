@@ -1,10 +1,8 @@
 package web
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
 )
@@ -55,39 +53,3 @@ func sendError(w http.ResponseWriter, statusCode int, code string, reason string
 	w.WriteHeader(statusCode)
 	w.Write(bytes)
 }
-
-// fetch Json from an http endpoint, optionally posting json.
-func fetchJson(url string, postData any, result any) (err error) {
-	var res *http.Response
-	if postData != nil {
-		// either JSON-able postData or pre-encoded payload.
-		var payload []byte
-		if p, ok := postData.([]byte); ok {
-			payload = p
-		} else {
-			payload, err = json.Marshal(postData)
-			if err != nil {
-				return fmt.Errorf("fetch: %v: json decode: %v", url, err)
-			}
-		}
-		res, err = http.Post(url, "application/json", bytes.NewReader(payload))
-	} else {
-		res, err = http.Get(url)
-	}
-	if err != nil {
-		return fmt.Errorf("fetch: %v: %v", url, err)
-	}
-	defer res.Body.Close() // ensure body is closed
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("fetch: %v: status %v", url, res.StatusCode)
-	}
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return fmt.Errorf("fetch: %v: %v", url, err)
-	}
-	err = json.Unmarshal(body, result)
-	if err != nil {
-		return fmt.Errorf("fetch: %v: json decode: %v", url, err)
-	}
-	return nil
-}
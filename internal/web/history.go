@@ -0,0 +1,122 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// getNodeRoutes handles /nodes/near, /nodes/{id} and /nodes/{id}/...
+// where {id} is a NodeID in hex (see MapNode.ID)
+func (a *WebAPI) getNodeRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/")
+	if len(parts) == 1 && parts[0] == "near" {
+		a.getNearNodes(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		a.getNode(w, r, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "history" {
+		a.getNodeHistory(w, r, parts[0])
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// getNode returns a Core Node or DogeBox and the nodes linked to it
+// (see spec.NodeInfo)
+func (a *WebAPI) getNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	options := "GET, OPTIONS"
+	if r.Method == http.MethodGet {
+		id, err := spec.ParseNodeID(nodeID)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "bad-request", err.Error(), options)
+			return
+		}
+		info, err := a.store.NodeInfo(id)
+		if err != nil {
+			if spec.IsNotFoundError(err) {
+				sendError(w, http.StatusNotFound, "not-found", "node not found", options)
+				return
+			}
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		sendJson(w, info, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
+
+// getNodeHistory returns when a node first appeared, each interval
+// it was present, and when it disappeared.
+func (a *WebAPI) getNodeHistory(w http.ResponseWriter, r *http.Request, nodeID string) {
+	options := "GET, OPTIONS"
+	if r.Method == http.MethodGet {
+		id, err := spec.ParseNodeID(nodeID)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "bad-request", err.Error(), options)
+			return
+		}
+		hist, err := a.store.NodeHistory(id)
+		if err != nil {
+			if spec.IsNotFoundError(err) {
+				sendError(w, http.StatusNotFound, "not-found", "node has never been seen", options)
+				return
+			}
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		sendJson(w, hist, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
+
+// getIdentityRoutes handles /identities/{identity}/history, where
+// {identity} is an identity pubkey in hex (see MapNode.Identity)
+func (a *WebAPI) getIdentityRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/identities/"), "/")
+	if len(parts) == 2 && parts[1] == "history" {
+		a.getIdentityHistory(w, r, parts[0])
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// getIdentityHistory returns each version of an identity's profile,
+// oldest first (see collector.IdentityCache)
+func (a *WebAPI) getIdentityHistory(w http.ResponseWriter, r *http.Request, identity string) {
+	options := "GET, OPTIONS"
+	if r.Method == http.MethodGet {
+		if _, err := spec.ParsePubKey(identity); err != nil {
+			sendError(w, http.StatusBadRequest, "bad-request", err.Error(), options)
+			return
+		}
+		hist, err := a.store.IdentityHistory(identity)
+		if err != nil {
+			if spec.IsNotFoundError(err) {
+				sendError(w, http.StatusNotFound, "not-found", "identity has no profile history", options)
+				return
+			}
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		sendJson(w, hist, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
+
+// netNodeID returns the NodeID hex of a dogenet node, or "" if its pubkey is invalid.
+func netNodeID(pubKey string) string {
+	key, err := spec.ParsePubKey(pubKey)
+	if err != nil {
+		return ""
+	}
+	return spec.NodeIDFromKey(key).String()
+}
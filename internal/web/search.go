@@ -0,0 +1,122 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+// Number of results returned by /search when `limit` is omitted.
+const DefaultSearchLimit = 20
+
+// Limit on the number of results in one /search response.
+const MaxSearchLimit = 100
+
+type SearchResult struct {
+	ID       string  `json:"id"`       // NodeID hex (see /nodes/{id}), or identity pubkey hex
+	Kind     string  `json:"kind"`     // "core", "net" or "identity" (see spec.SearchCore)
+	Score    float64 `json:"score"`    // higher is better
+	Address  string  `json:"address"`  // node address (empty for an identity)
+	Name     string  `json:"name"`     // identity profile name, if any
+	Identity string  `json:"identity"` // identity pubkey hex (DogeBoxes and identities)
+	Lat      string  `json:"lat"`
+	Lon      string  `json:"lon"`
+	Country  string  `json:"country"`
+	City     string  `json:"city"`
+}
+
+// getSearch returns nodes and identity profiles matching a query, best
+// first (see store.SearchIndexer)
+// Query: ?q=<text>&limit=<n>
+func (a *WebAPI) getSearch(w http.ResponseWriter, r *http.Request) {
+	options := "GET, OPTIONS"
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		q := query.Get("q")
+		if len(spec.SearchTerms(q)) == 0 {
+			sendError(w, http.StatusBadRequest, "bad-request", "missing q", options)
+			return
+		}
+		limit := DefaultSearchLimit
+		if arg := query.Get("limit"); arg != "" {
+			val, err := strconv.Atoi(arg)
+			if err != nil || val < 1 || val > MaxSearchLimit {
+				sendError(w, http.StatusBadRequest, "bad-request", "invalid limit", options)
+				return
+			}
+			limit = val
+		}
+		hits, err := a.store.Search(q, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		profiles, err := a.store.IdentityProfiles()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		identities := make(map[string]spec.IdentityProfile, len(profiles))
+		for _, p := range profiles {
+			identities[p.Identity] = p
+		}
+		res := make([]SearchResult, 0, len(hits))
+		for _, hit := range hits {
+			result, found, err := a.searchResult(hit, identities)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+				return
+			}
+			if found { // otherwise removed since it was indexed
+				res = append(res, result)
+			}
+		}
+		sendJson(w, res, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
+
+// searchResult locates a search hit at its stored location, as in /nodes;
+// the location of a node that is not located is empty.
+func (a *WebAPI) searchResult(hit spec.SearchHit, identities map[string]spec.IdentityProfile) (res SearchResult, found bool, err error) {
+	res = SearchResult{ID: hit.ID, Kind: hit.Kind, Score: hit.Score}
+	if hit.Kind == spec.SearchIdentity {
+		profile, found := identities[hit.ID]
+		if !found {
+			return res, false, nil
+		}
+		res.Identity, res.Name = profile.Identity, profile.Name()
+		res.Lat, res.Lon, res.Country, res.City = profile.Lat, profile.Lon, profile.Country, profile.City
+		return res, true, nil
+	}
+	id, err := spec.ParseNodeID(hit.ID)
+	if err != nil {
+		return res, false, nil
+	}
+	info, err := a.store.NodeInfo(id)
+	if err != nil {
+		if spec.IsNotFoundError(err) {
+			return res, false, nil
+		}
+		return res, false, err
+	}
+	switch {
+	case info.Core != nil:
+		core := info.Core
+		res.Address = core.Address
+		if core.Located {
+			res.Lat, res.Lon, res.Country, res.City = formatDegrees(core.Lat), formatDegrees(core.Lon), core.Country, core.City
+		}
+	case info.Net != nil:
+		node := info.Net
+		res.Address, res.Identity = node.Address, node.Identity
+		res.Name = identities[node.Identity].Name()
+		if node.Located {
+			res.Lat, res.Lon, res.Country, res.City = formatDegrees(node.Lat), formatDegrees(node.Lon), node.Country, node.City
+		}
+	}
+	return res, true, nil
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+)
+
+type CapStats struct {
+	Total  int            `json:"total"`  // core nodes in the map
+	Probed int            `json:"probed"` // core nodes probed at least once
+	Caps   map[string]int `json:"caps"`   // number of nodes with each capability
+	Bits   map[string]int `json:"bits"`   // bit value of each capability (for decoding MapNode.caps)
+}
+
+// getCapStats summarises protocol feature adoption across core nodes.
+func (a *WebAPI) getCapStats(w http.ResponseWriter, r *http.Request) {
+	options := "GET, OPTIONS"
+	if r.Method == http.MethodGet {
+		coreNodes, err := a.store.NodeList()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		res := CapStats{
+			Total: len(coreNodes),
+			Caps:  make(map[string]int, len(spec.CapNames)),
+			Bits:  make(map[string]int, len(spec.CapNames)),
+		}
+		for _, c := range spec.CapNames {
+			res.Caps[c.Name] = 0
+			res.Bits[c.Name] = int(c.Bit)
+		}
+		for _, node := range coreNodes {
+			if node.Probed != 0 {
+				res.Probed++
+			}
+			for _, c := range spec.CapNames {
+				if node.Caps&c.Bit != 0 {
+					res.Caps[c.Name]++
+				}
+			}
+		}
+		sendJson(w, res, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
+
+// Default threshold for /stats/skew outliers: Core Nodes reject blocks
+// more than 2 hours in the future, but a few minutes is already unusual.
+const DefaultSkewThreshold = 300
+
+// Histogram bucket upper bounds (seconds) for /stats/skew
+var skewBuckets = []int64{-7200, -3600, -600, -60, -10, 10, 60, 600, 3600, 7200}
+
+type SkewStats struct {
+	Measured  int          `json:"measured"`  // core nodes with a clock measurement
+	Median    int64        `json:"median"`    // median skew in seconds
+	P90       int64        `json:"p90"`       // 90th percentile of absolute skew
+	P99       int64        `json:"p99"`       // 99th percentile of absolute skew
+	Buckets   []SkewBucket `json:"buckets"`   // histogram of skew
+	Threshold int64        `json:"threshold"` // outlier threshold in seconds
+	Outliers  []SkewNode   `json:"outliers"`  // nodes with |skew| >= threshold, worst first
+}
+
+type SkewBucket struct {
+	Min   *int64 `json:"min"` // inclusive lower bound, null for -infinity
+	Max   *int64 `json:"max"` // exclusive upper bound, null for +infinity
+	Count int    `json:"count"`
+}
+
+type SkewNode struct {
+	Address string `json:"address"`
+	Skew    int64  `json:"skew"`   // seconds
+	RTT     int64  `json:"rtt"`    // milliseconds
+	Probed  int64  `json:"probed"` // unix time of the measurement
+}
+
+// getSkewStats reports the distribution of core node clock offsets.
+// Query: ?threshold=<seconds> for outliers (default 300)
+func (a *WebAPI) getSkewStats(w http.ResponseWriter, r *http.Request) {
+	options := "GET, OPTIONS"
+	if r.Method == http.MethodGet {
+		threshold := int64(DefaultSkewThreshold)
+		if arg := r.URL.Query().Get("threshold"); arg != "" {
+			val, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || val < 0 {
+				sendError(w, http.StatusBadRequest, "bad-request", "invalid threshold", options)
+				return
+			}
+			threshold = val
+		}
+		coreNodes, err := a.store.NodeList()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		res := SkewStats{Threshold: threshold, Outliers: []SkewNode{}}
+		for i := 0; i <= len(skewBuckets); i++ {
+			var b SkewBucket
+			if i > 0 {
+				b.Min = &skewBuckets[i-1]
+			}
+			if i < len(skewBuckets) {
+				b.Max = &skewBuckets[i]
+			}
+			res.Buckets = append(res.Buckets, b)
+		}
+		skews := []int64{}
+		for _, node := range coreNodes {
+			if node.Probed == 0 {
+				continue // never measured
+			}
+			skews = append(skews, node.Skew)
+			res.Buckets[sort.Search(len(skewBuckets), func(i int) bool { return node.Skew < skewBuckets[i] })].Count++
+			if abs(node.Skew) >= threshold {
+				res.Outliers = append(res.Outliers, SkewNode{Address: node.Address, Skew: node.Skew, RTT: node.RTT, Probed: node.Probed})
+			}
+		}
+		res.Measured = len(skews)
+		if len(skews) > 0 {
+			sort.Slice(skews, func(i, j int) bool { return skews[i] < skews[j] })
+			res.Median = skews[len(skews)/2]
+			absSkews := make([]int64, len(skews))
+			for i, s := range skews {
+				absSkews[i] = abs(s)
+			}
+			sort.Slice(absSkews, func(i, j int) bool { return absSkews[i] < absSkews[j] })
+			res.P90 = absSkews[len(absSkews)*90/100]
+			res.P99 = absSkews[len(absSkews)*99/100]
+		}
+		sort.Slice(res.Outliers, func(i, j int) bool { return abs(res.Outliers[i].Skew) > abs(res.Outliers[j].Skew) })
+		sendJson(w, res, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -7,38 +7,39 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"time"
 
-	"code.dogecoin.org/dogemap-backend/internal/geoip"
+	"code.dogecoin.org/dogemap-backend/internal/clock"
 	"code.dogecoin.org/dogemap-backend/internal/spec"
 	"code.dogecoin.org/gossip/dnet"
 	"code.dogecoin.org/governor"
 )
 
-func New(bind spec.Address, store spec.Store, geoIP *geoip.GeoIPDatabase, webdir string, dogeNetAddr string, identityAddr string) governor.Service {
+func New(bind spec.Address, store spec.Store, webdir string, identityAddr string) governor.Service {
 	mux := http.NewServeMux()
 	a := &WebAPI{
 		_store: store,
+		clock:  clock.System,
 		srv: http.Server{
 			Addr:    bind.String(),
 			Handler: mux,
 		},
-		geoIP: geoIP,
-	}
-	if dogeNetAddr != "" {
-		// used by /nodes API
-		a.dogeNetUrl = fmt.Sprintf("http://%v/nodes", dogeNetAddr)
 	}
 	if identityAddr != "" {
-		// used by /nodes API
-		a.locationsUrl = fmt.Sprintf("http://%v/locations", identityAddr)
 		// create a proxy for /chits API
 		identityUrl := &url.URL{Scheme: "http", Host: identityAddr, Path: "/"}
 		a.identityProxy = httputil.NewSingleHostReverseProxy(identityUrl)
 	}
 
 	mux.HandleFunc("/nodes", a.getNodes)
+	mux.HandleFunc("/nodes/", a.getNodeRoutes)
+	mux.HandleFunc("/identities/", a.getIdentityRoutes)
+	mux.HandleFunc("/search", a.getSearch)
 	mux.HandleFunc("/chits", a.getChits)
+	mux.HandleFunc("/stats/caps", a.getCapStats)
+	mux.HandleFunc("/stats/skew", a.getSkewStats)
+	mux.HandleFunc("/history/counts", a.getCensusCounts)
 
 	fs := http.FileServer(http.Dir(webdir))
 	mux.Handle("/", fs)
@@ -51,10 +52,15 @@ type WebAPI struct {
 	_store        spec.Store
 	store         spec.Store
 	srv           http.Server
-	geoIP         *geoip.GeoIPDatabase
-	dogeNetUrl    string
-	locationsUrl  string
 	identityProxy *httputil.ReverseProxy
+	clock         clock.Clock
+}
+
+// WithClock replaces clock.System, for the store and the default time
+// range of /history/counts
+func (a *WebAPI) WithClock(clk clock.Clock) *WebAPI {
+	a.clock = clk
+	return a
 }
 
 // called on any
@@ -70,7 +76,7 @@ func (a *WebAPI) Stop() {
 
 // goroutine
 func (a *WebAPI) Run() {
-	a.store = a._store.WithCtx(a.Context) // Service Context is first available here
+	a.store = a._store.WithCtx(a.Context).WithClock(a.clock) // Service Context is first available here
 	log.Printf("HTTP server listening on: %v\n", a.srv.Addr)
 	if err := a.srv.ListenAndServe(); err != http.ErrServerClosed { // blocking call
 		log.Printf("HTTP server: %v\n", err)
@@ -78,27 +84,18 @@ func (a *WebAPI) Run() {
 }
 
 type MapNode struct {
-	SubVer   string  `json:"subver"` // IP address
-	Lat      string  `json:"lat"`
-	Lon      string  `json:"lon"`
-	City     string  `json:"city"`
-	Country  string  `json:"country"`
-	IPInfo   *string `json:"ipinfo"`   // always null
-	Node     string  `json:"node"`     // node pubkey hex
-	Identity string  `json:"identity"` // can be empty
-	Core     bool    `json:"core"`     // true if core node
-}
-
-type GetChit struct {
-	Identity string `json:"identity"` // identity (node owner) pubkey hex
-	Node     string `json:"node"`     // node pubkey hex
-}
-
-type IdentChit struct {
-	Lat     string `json:"lat"`     // WGS84 +/- 90 degrees, 60 seconds (accurate to 1850m)
-	Lon     string `json:"lon"`     // WGS84 +/- 180 degrees, 60 seconds (accurate to 1850m)
-	Country string `json:"country"` // [2] ISO 3166-1 alpha-2 code (optional)
-	City    string `json:"city"`    // [30] city name (optional)
+	ID       string   `json:"id"`     // NodeID hex (see /nodes/{id})
+	SubVer   string   `json:"subver"` // IP address
+	Lat      string   `json:"lat"`
+	Lon      string   `json:"lon"`
+	City     string   `json:"city"`
+	Country  string   `json:"country"`
+	IPInfo   *string  `json:"ipinfo"`          // always null
+	Node     string   `json:"node"`            // node pubkey hex
+	Identity string   `json:"identity"`        // can be empty
+	Core     bool     `json:"core"`            // true if core node
+	Caps     uint32   `json:"caps"`            // core node capability bitmap (see /stats/caps)
+	Cores    []string `json:"cores,omitempty"` // NodeIDs of the core nodes run by this DogeBox
 }
 
 func (a *WebAPI) getChits(w http.ResponseWriter, r *http.Request) {
@@ -118,121 +115,138 @@ func (a *WebAPI) getChits(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getNodes returns every node on the map, or with ?bbox= the nodes in a
+// bounding box, ordered by distance from its center (see spec.ParseGeoBox)
 func (a *WebAPI) getNodes(w http.ResponseWriter, r *http.Request) {
 	options := "GET, OPTIONS"
 	if r.Method == http.MethodGet {
-		coreNodes, err := a.store.NodeList()
+		if arg := r.URL.Query().Get("bbox"); arg != "" {
+			box, err := spec.ParseGeoBox(arg)
+			if err != nil {
+				sendError(w, http.StatusBadRequest, "bad-request", err.Error(), options)
+				return
+			}
+			a.getNodesInBox(w, box, options)
+			return
+		}
+		nodes, err := a.allMapNodes()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
 			return
 		}
+		sendJson(w, nodes, options)
+	} else {
+		sendOptions(w, r, options)
+	}
+}
 
-		// unique nodes (may find both a dogenet and a core node)
-		nodeMap := make(map[string]MapNode, 8192)
-
-		// fetch all nodes from `dogenet` and their identities
-		netNodes := make([]spec.NetNode, 0, 8192)
-		identities := make(map[string]IdentChit, 8192)
-		if a.dogeNetUrl != "" {
-			err = fetchJson(a.dogeNetUrl, nil, &netNodes)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+// allMapNodes returns every node on the map (see mapNodes)
+func (a *WebAPI) allMapNodes() ([]MapNode, error) {
+	coreNodes, err := a.store.NodeList()
+	if err != nil {
+		return nil, err
+	}
+	netNodes, err := a.store.NetNodeList()
+	if err != nil {
+		return nil, err
+	}
+	allLinks, err := a.store.NodeLinks()
+	if err != nil {
+		return nil, err
+	}
+	located := make(map[spec.NodeID]bool, len(netNodes))
+	for _, node := range netNodes {
+		if id, err := node.NodeID(); err == nil && node.Located {
+			located[id] = true
+		}
+	}
+	links := make([]spec.NodeLink, 0, len(allLinks))
+	for _, link := range allLinks {
+		if located[link.Node] {
+			links = append(links, link)
+		}
+	}
+	return mapNodes(coreNodes, netNodes, links), nil
+}
 
-			// all identities from the set of nodes
-			if a.locationsUrl != "" {
-				var getChits []GetChit
-				for _, net := range netNodes {
-					if net.Identity != "" {
-						getChits = append(getChits, GetChit{
-							Identity: net.Identity,
-							Node:     net.PubKey,
-						})
-					}
-				}
-
-				// fetch identities from `identity` handler
-				err = fetchJson(a.locationsUrl, getChits, &identities)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-			}
+// mapNodes returns `coreNodes` and `netNodes` (DogeBoxes) as map nodes.
+// `links` are links to located DogeBoxes (see Store.NodeLinksInBox): a
+// DogeBox absorbs the Core Nodes it runs, even when the DogeBox itself
+// is not in `netNodes`. Nodes are placed at their stored location (see
+// store.Relocator and collector.NetCollector); nodes that are not
+// located are left off the map.
+func mapNodes(coreNodes []spec.CoreNode, netNodes []spec.NetNode, links []spec.NodeLink) []MapNode {
+	// unique nodes by NodeID: a DogeBox absorbs the Core Nodes it runs.
+	nodeMap := make(map[string]MapNode, len(coreNodes)+len(netNodes))
 
-			// make result nodes for all dogenet nodes
-			for _, node := range netNodes {
-				if profile, found := identities[node.Identity]; found {
-					nodeMap[node.Address] = MapNode{
-						SubVer:   node.Address,
-						Lat:      profile.Lat,
-						Lon:      profile.Lon,
-						Country:  profile.Country,
-						City:     profile.City,
-						IPInfo:   nil,
-						Node:     node.PubKey,
-						Identity: node.Identity,
-					}
-				} else {
-					// note: NetNode.Address is already normalized.
-					// BUG: dnet.ParseAddress (net.ParseIP) always returns IPv6.
-					addr, err := dnet.ParseAddress(node.Address)
-					if err != nil {
-						log.Printf("[GET /nodes] invalid core address: %v", node.Address)
-						continue
-					}
-					// re-normalize to IPv4 (see above)
-					addr = normalizeIP4(addr)
-					lat, lon, country, city := a.geoIP.FindLocation(addr.Host)
-					nodeMap[node.Address] = MapNode{
-						SubVer:   node.Address,
-						Lat:      lat,
-						Lon:      lon,
-						Country:  country,
-						City:     city,
-						IPInfo:   nil,
-						Node:     node.PubKey,
-						Identity: node.Identity,
-					}
-				}
-			}
+	// dogenet nodes (see collector.NetCollector)
+	for _, node := range netNodes {
+		if !node.Located {
+			continue // left off the map
+		}
+		id := netNodeID(node.PubKey)
+		nodeMap[id] = MapNode{
+			ID:       id,
+			SubVer:   node.Address,
+			Lat:      formatDegrees(node.Lat),
+			Lon:      formatDegrees(node.Lon),
+			Country:  node.Country,
+			City:     node.City,
+			IPInfo:   nil,
+			Node:     node.PubKey,
+			Identity: node.Identity,
 		}
+	}
 
-		// add core nodes to the result.
-		for _, core := range coreNodes {
-			addr, err := dnet.ParseAddress(core.Address)
-			if err != nil {
-				log.Printf("[GET /nodes] invalid core address: %v", core.Address)
-				continue
-			}
-			addr = normalizeIP4(addr)
-			key := addr.String() // normalized address
-			if _, found := nodeMap[key]; !found {
-				lat, lon, country, city := a.geoIP.FindLocation(addr.Host)
-				nodeMap[key] = MapNode{
-					SubVer:   key,
-					Lat:      lat,
-					Lon:      lon,
-					Country:  country,
-					City:     city,
-					IPInfo:   nil,
-					Node:     "",
-					Identity: "",
-					Core:     true,
-				}
-			}
+	// link core nodes to the DogeBoxes running them (see spec.NodeLink)
+	linked := make(map[string]bool, len(links))
+	for _, link := range links {
+		box, found := nodeMap[link.Node.String()]
+		if found {
+			box.Cores = append(box.Cores, link.Core.String())
+			nodeMap[link.Node.String()] = box
 		}
+		linked[link.Core.String()] = true
+	}
 
-		// values from the map
-		nodes := make([]MapNode, 0, len(nodeMap))
-		for _, node := range nodeMap {
-			nodes = append(nodes, node)
+	// add core nodes to the result.
+	for _, core := range coreNodes {
+		addr, err := dnet.ParseAddress(core.Address)
+		if err != nil {
+			log.Printf("[GET /nodes] invalid core address: %v", core.Address)
+			continue
 		}
+		addr = normalizeIP4(addr)
+		id := spec.NodeIDFromAddress(addr).String()
+		if !linked[id] && core.Located {
+			nodeMap[id] = MapNode{
+				ID:       id,
+				SubVer:   addr.String(),
+				Lat:      formatDegrees(core.Lat),
+				Lon:      formatDegrees(core.Lon),
+				Country:  core.Country,
+				City:     core.City,
+				IPInfo:   nil,
+				Node:     "",
+				Identity: "",
+				Core:     true,
+				Caps:     core.Caps,
+			}
+		}
+	}
 
-		sendJson(w, nodes, options)
-	} else {
-		sendOptions(w, r, options)
+	// values from the map
+	nodes := make([]MapNode, 0, len(nodeMap))
+	for _, node := range nodeMap {
+		nodes = append(nodes, node)
 	}
+
+	return nodes
+}
+
+// formatDegrees formats a latitude or longitude for MapNode.
+func formatDegrees(deg float64) string {
+	return strconv.FormatFloat(deg, 'f', -1, 64)
 }
 
 // normalizeIP4 normalizes an Address to IPv4 if possible.
@@ -0,0 +1,253 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/dogemap-backend/internal/clock"
+	"code.dogecoin.org/dogemap-backend/internal/spec"
+	"code.dogecoin.org/dogemap-backend/internal/store"
+)
+
+var (
+	sydney   = spec.Address{Host: net.IPv4(10, 1, 0, 1).To16(), Port: 22556}
+	auckland = spec.Address{Host: net.IPv4(10, 1, 0, 2).To16(), Port: 22556}
+	berlin   = spec.Address{Host: net.IPv4(10, 2, 0, 1).To16(), Port: 22556} // not yet located
+	nowhere  = spec.Address{Host: net.ParseIP("2001:db8::1"), Port: 22556}   // GeoIP lookup failed
+
+	dogebox   = spec.NetNode{PubKey: strings.Repeat("ab", 32), Address: "10.3.0.1:42069", Time: 1000, Identity: strings.Repeat("cd", 32)}
+	unboxed   = spec.NetNode{PubKey: strings.Repeat("ef", 32), Address: "10.3.0.2:42069", Time: 1000} // not yet located
+	boxSource = spec.Location{Lat: 52.52, Lon: 13.405, Country: "DE", City: "Berlin", Source: spec.IdentityLocSource, Found: true}
+)
+
+// newTestAPI returns a WebAPI over a MemoryStore holding four core nodes
+// and two DogeBoxes.
+func newTestAPI(t *testing.T) (*WebAPI, spec.Store) {
+	t.Helper()
+	db := store.NewMemoryStore(context.Background())
+	_, _, err := db.AddCoreNodes([]spec.CoreAddr{
+		{Address: sydney, Time: 1000, Services: 1, Location: spec.Location{Lat: -33.8688, Lon: 151.2093, Country: "AU", City: "Sydney", Source: "test", Found: true}},
+		{Address: auckland, Time: 1000, Services: 1, Location: spec.Location{Lat: -36.8485, Lon: 174.7633, Country: "NZ", City: "Auckland", Source: "test", Found: true}},
+		{Address: berlin, Time: 1000, Services: 1},
+		{Address: nowhere, Time: 1000, Services: 1, Location: spec.Location{Source: "test"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	box := dogebox
+	box.SetLocation(boxSource)
+	if _, _, err := db.AddNetNodes([]spec.NetNode{box, unboxed}); err != nil {
+		t.Fatal(err)
+	}
+	a := New(spec.Address{}, db, t.TempDir(), "").(*WebAPI)
+	a.store = db
+	return a, db
+}
+
+// get performs a GET request and decodes the JSON response into `res`.
+func get(t *testing.T, a *WebAPI, path string, status int, res any) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	a.srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != status {
+		t.Fatalf("GET %s: expected status %d, got %d: %s", path, status, w.Code, w.Body.String())
+	}
+	if res != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatalf("GET %s: %v: %s", path, err, w.Body.String())
+		}
+	}
+}
+
+func nodesByAddress(nodes []MapNode) map[string]MapNode {
+	res := make(map[string]MapNode, len(nodes))
+	for _, node := range nodes {
+		res[node.SubVer] = node
+	}
+	return res
+}
+
+func TestGetNodes(t *testing.T) {
+	a, _ := newTestAPI(t)
+	var nodes []MapNode
+	get(t, a, "/nodes", http.StatusOK, &nodes)
+	byAddr := nodesByAddress(nodes)
+	// unlocated nodes are left off the map, not placed at 0,0.
+	if len(nodes) != 3 || len(byAddr) != 3 {
+		t.Fatalf("expected 3 nodes, got %+v", nodes)
+	}
+	expect := map[string][4]string{
+		sydney.String():   {"-33.8688", "151.2093", "AU", "Sydney"},
+		auckland.String(): {"-36.8485", "174.7633", "NZ", "Auckland"},
+	}
+	for addr, loc := range expect {
+		node := byAddr[addr]
+		if got := [4]string{node.Lat, node.Lon, node.Country, node.City}; got != loc || !node.Core {
+			t.Errorf("%s: expected core node at %v, got %+v", addr, loc, node)
+		}
+	}
+	box := byAddr[dogebox.Address]
+	if got := [4]string{box.Lat, box.Lon, box.Country, box.City}; got != [4]string{"52.52", "13.405", "DE", "Berlin"} || box.Core || box.Identity != dogebox.Identity {
+		t.Errorf("expected the DogeBox at its stored location, got %+v", box)
+	}
+
+	// bounding box: Sydney and Auckland, nearest the center first.
+	get(t, a, "/nodes?bbox=150,-40,180,-30", http.StatusOK, &nodes)
+	if len(nodes) != 2 || nodes[0].SubVer != auckland.String() || nodes[1].SubVer != sydney.String() {
+		t.Fatalf("bbox: expected Auckland, Sydney, got %+v", nodes)
+	}
+	// the failed lookup is not placed at 0,0.
+	get(t, a, "/nodes?bbox=-1,-1,1,1", http.StatusOK, &nodes)
+	if len(nodes) != 0 {
+		t.Fatalf("bbox: expected no nodes at 0,0, got %+v", nodes)
+	}
+	get(t, a, "/nodes?bbox=1,2,3", http.StatusBadRequest, nil)
+}
+
+func TestGetNode(t *testing.T) {
+	a, _ := newTestAPI(t)
+	var nodes []MapNode
+	get(t, a, "/nodes", http.StatusOK, &nodes)
+	id := nodesByAddress(nodes)[sydney.String()].ID
+
+	var info spec.NodeInfo
+	get(t, a, "/nodes/"+id, http.StatusOK, &info)
+	if info.ID != id || info.Core == nil || info.Core.Address != sydney.String() || info.Core.City != "Sydney" {
+		t.Fatalf("expected the Sydney node, got %+v", info)
+	}
+	get(t, a, "/nodes/not-hex", http.StatusBadRequest, nil)
+	get(t, a, "/nodes/"+spec.NodeIDFromAddress(spec.Address{Host: net.IPv4(10, 9, 9, 9).To16(), Port: 22556}).String(), http.StatusNotFound, nil)
+}
+
+func TestSearch(t *testing.T) {
+	a, db := newTestAPI(t)
+	coreNodes, err := db.NodeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetSearchIndex(spec.MakeSearchDocs(coreNodes, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	var res []SearchResult
+	get(t, a, "/search?q=auckland", http.StatusOK, &res)
+	if len(res) != 1 || res[0].Kind != spec.SearchCore || res[0].Address != auckland.String() || res[0].City != "Auckland" {
+		t.Fatalf("expected the Auckland node, got %+v", res)
+	}
+	get(t, a, "/search?q=10.1&limit=1", http.StatusOK, &res)
+	if len(res) != 1 {
+		t.Fatalf("expected one result, got %+v", res)
+	}
+	get(t, a, "/search?q=nowhere", http.StatusOK, &res)
+	if len(res) != 0 {
+		t.Fatalf("expected no results, got %+v", res)
+	}
+	get(t, a, "/search", http.StatusBadRequest, nil)
+	get(t, a, "/search?q=sydney&limit=0", http.StatusBadRequest, nil)
+}
+
+func TestNearNodes(t *testing.T) {
+	a, _ := newTestAPI(t)
+	var res []NearNode
+	get(t, a, "/nodes/near?lat=-33.87&lon=151.21", http.StatusOK, &res)
+	if len(res) != 1 || res[0].SubVer != sydney.String() || res[0].DistanceKm > 1 {
+		t.Fatalf("expected the Sydney node, got %+v", res)
+	}
+	get(t, a, "/nodes/near?lat=-33.87&lon=151.21&radiusKm=3000", http.StatusOK, &res)
+	if len(res) != 2 || res[0].SubVer != sydney.String() || res[1].SubVer != auckland.String() {
+		t.Fatalf("expected Sydney, Auckland, got %+v", res)
+	}
+	if d := res[1].DistanceKm; d < 2100 || d > 2200 {
+		t.Errorf("expected Auckland about 2160 km from Sydney, got %v", d)
+	}
+	get(t, a, "/nodes/near?lat=-33.87&lon=151.21&radiusKm=3000&limit=1", http.StatusOK, &res)
+	if len(res) != 1 {
+		t.Fatalf("expected one node, got %+v", res)
+	}
+	get(t, a, "/nodes/near?lat=0&lon=0", http.StatusOK, &res)
+	if len(res) != 0 {
+		t.Fatalf("expected no nodes near 0,0, got %+v", res)
+	}
+	get(t, a, "/nodes/near?lat=-33.87", http.StatusBadRequest, nil)
+	get(t, a, "/nodes/near?lat=91&lon=0", http.StatusBadRequest, nil)
+	get(t, a, "/nodes/near?lat=0&lon=0&radiusKm=0", http.StatusBadRequest, nil)
+}
+
+func TestCapStats(t *testing.T) {
+	a, db := newTestAPI(t)
+	// probed, but no optional features.
+	if err := db.UpdateCoreProbe(sydney, spec.CoreProbe{Version: 70015}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateCoreProbe(auckland, spec.CoreProbe{Caps: spec.CapSendHeaders}); err != nil {
+		t.Fatal(err)
+	}
+	var res CapStats
+	get(t, a, "/stats/caps", http.StatusOK, &res)
+	if res.Total != 4 || res.Probed != 2 || res.Caps["sendheaders"] != 1 {
+		t.Fatalf("expected 4 nodes, 2 probed, 1 with sendheaders, got %+v", res)
+	}
+}
+
+func TestDogeBoxInBox(t *testing.T) {
+	a, db := newTestAPI(t)
+	// a Core Node in Sydney on the DogeBox's host is absorbed by the DogeBox.
+	boxCore := spec.Address{Host: net.IPv4(10, 3, 0, 1).To16(), Port: 22556}
+	_, _, err := db.AddCoreNodes([]spec.CoreAddr{{Address: boxCore, Time: 1000, Services: 1,
+		Location: spec.Location{Lat: -33.87, Lon: 151.21, Country: "AU", City: "Sydney", Source: "test", Found: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	box := dogebox
+	box.SetLocation(boxSource)
+	if _, _, err := db.AddNetNodes([]spec.NetNode{box}); err != nil {
+		t.Fatal(err)
+	}
+	coreID := spec.NodeIDFromAddress(boxCore).String()
+
+	var nodes []MapNode
+	get(t, a, "/nodes?bbox=13,52,14,53", http.StatusOK, &nodes)
+	if len(nodes) != 1 || nodes[0].SubVer != dogebox.Address || len(nodes[0].Cores) != 1 || nodes[0].Cores[0] != coreID {
+		t.Fatalf("bbox: expected the DogeBox with its Core Node, got %+v", nodes)
+	}
+	get(t, a, "/nodes?bbox=150,-40,180,-30", http.StatusOK, &nodes)
+	if len(nodes) != 2 || nodes[0].SubVer != auckland.String() || nodes[1].SubVer != sydney.String() {
+		t.Fatalf("bbox: expected Auckland, Sydney without the absorbed Core Node, got %+v", nodes)
+	}
+	var near []NearNode
+	get(t, a, "/nodes/near?lat=52.5&lon=13.4", http.StatusOK, &near)
+	if len(near) != 1 || near[0].SubVer != dogebox.Address || near[0].City != "Berlin" {
+		t.Fatalf("expected the DogeBox near Berlin, got %+v", near)
+	}
+	get(t, a, "/nodes", http.StatusOK, &nodes)
+	if byAddr := nodesByAddress(nodes); len(nodes) != 3 || len(byAddr[dogebox.Address].Cores) != 1 {
+		t.Fatalf("expected the DogeBox to absorb its Core Node, got %+v", nodes)
+	}
+}
+
+func TestCensusCountsClock(t *testing.T) {
+	a, db := newTestAPI(t)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	a.WithClock(clock.NewFake(now))
+	day := spec.CensusIntervals["day"]
+	recent := now.Unix() - 2*day
+	if err := db.AddCensus("day", recent, []spec.CensusCount{{Time: recent, Dimension: spec.CensusTotal, Count: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	old := now.Unix() - 40*day // before the default range
+	if err := db.AddCensus("day", old, []spec.CensusCount{{Time: old, Dimension: spec.CensusTotal, Count: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	// the default range ends at the clock's time, not the system time.
+	var res CensusSeries
+	get(t, a, "/history/counts", http.StatusOK, &res)
+	if res.To != now.Unix() || res.From != now.Unix()-DefaultCensusPoints*day ||
+		len(res.Series) != 1 || res.Series[0].Time != recent || res.Series[0].Counts[""] != 4 {
+		t.Fatalf("expected one snapshot up to %v, got %+v", now.Unix(), res)
+	}
+}